@@ -0,0 +1,518 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/uuid"
+)
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: osbctl [options] %s %s\n\nOptions:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fmt.Sprintf("unexpected arguments: %v", fs.Args()))
+	}
+	return nil
+}
+
+// target holds the flags naming the instance, service and plan a command acts
+// on.
+type target struct {
+	instance string
+	service  string
+	plan     string
+}
+
+func (t *target) addFlags(fs *flag.FlagSet, instanceUsage string) {
+	fs.StringVar(&t.instance, "instance", "", instanceUsage)
+	fs.StringVar(&t.service, "service", "", "ID or name of the service")
+	fs.StringVar(&t.plan, "plan", "", "ID or name of the plan")
+}
+
+// requestParameters holds the flags setting the parameters and context of a
+// request.
+type requestParameters struct {
+	file        string
+	set         setFlags
+	contextFile string
+	namespace   string
+	org         string
+	space       string
+}
+
+func (p *requestParameters) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.file, "params", "", "JSON or YAML file with the parameters of the request; '-' reads stdin")
+	fs.Var(&p.set, "set", "set a parameter as key=value, where value is YAML and a dotted key sets a nested parameter; may be repeated")
+	fs.StringVar(&p.contextFile, "context", "", "JSON or YAML file with the context of the request")
+	fs.StringVar(&p.namespace, "namespace", "", "send a Kubernetes context for this namespace, unless --context is set")
+	fs.StringVar(&p.org, "org", "", "GUID of the platform organization to send; required by provision")
+	fs.StringVar(&p.space, "space", "", "GUID of the platform space to send; required by provision")
+}
+
+func (p *requestParameters) build() (params, context map[string]interface{}, err error) {
+	if params, err = parameters(p.file, p.set); err != nil {
+		return nil, nil, err
+	}
+	if context, err = platformContext(p.contextFile, p.namespace, p.org, p.space); err != nil {
+		return nil, nil, err
+	}
+	return params, context, nil
+}
+
+func runCatalog(c *cli, args []string) error {
+	fs := newFlagSet("catalog", "")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	catalog, err := c.client.GetCatalog()
+	if err != nil {
+		return err
+	}
+	return c.printCatalog(catalog)
+}
+
+func runProvision(c *cli, args []string) error {
+	fs := newFlagSet("provision", "--service <service> --plan <plan> --org <guid> --space <guid> [--instance <id>]")
+	var t target
+	t.addFlags(fs, "ID of the instance; a random ID is generated if empty")
+	var p requestParameters
+	p.addFlags(fs)
+	var w waitOptions
+	w.addFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if t.plan == "" {
+		return usageError("--plan is required")
+	}
+	if p.org == "" || p.space == "" {
+		return usageError("--org and --space are required")
+	}
+	if t.instance == "" {
+		t.instance = uuid.New()
+	}
+
+	serviceID, planID, err := c.resolve(t.service, t.plan)
+	if err != nil {
+		return err
+	}
+	params, context, err := p.build()
+	if err != nil {
+		return err
+	}
+	response, err := c.client.ProvisionInstance(&osb.ProvisionRequest{
+		InstanceID:          t.instance,
+		AcceptsIncomplete:   w.async,
+		ServiceID:           serviceID,
+		PlanID:              planID,
+		OrganizationGUID:    p.org,
+		SpaceGUID:           p.space,
+		Parameters:          params,
+		Context:             context,
+		OriginatingIdentity: c.identity,
+	})
+	if err != nil {
+		return err
+	}
+
+	r := &result{
+		InstanceID: t.instance,
+		ServiceID:  serviceID,
+		PlanID:     planID,
+		State:      osb.StateSucceeded,
+	}
+	if response.DashboardURL != nil {
+		r.DashboardURL = *response.DashboardURL
+	}
+	if response.Async {
+		if err := c.waitForInstance(r, response.OperationKey, w, false, "provision"); err != nil {
+			return err
+		}
+	}
+	return c.printResult(r)
+}
+
+func runUpdate(c *cli, args []string) error {
+	fs := newFlagSet("update", "--instance <id> --service <service> [--plan <plan>]")
+	var t target
+	t.addFlags(fs, "ID of the instance")
+	var p requestParameters
+	p.addFlags(fs)
+	var w waitOptions
+	w.addFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if t.instance == "" {
+		return usageError("--instance is required")
+	}
+
+	serviceID, planID, err := c.resolve(t.service, t.plan)
+	if err != nil {
+		return err
+	}
+	params, context, err := p.build()
+	if err != nil {
+		return err
+	}
+
+	request := &osb.UpdateInstanceRequest{
+		InstanceID:          t.instance,
+		AcceptsIncomplete:   w.async,
+		ServiceID:           serviceID,
+		Parameters:          params,
+		Context:             context,
+		OriginatingIdentity: c.identity,
+	}
+	if planID != "" {
+		request.PlanID = &planID
+	}
+	response, err := c.client.UpdateInstance(request)
+	if err != nil {
+		return err
+	}
+
+	r := &result{
+		InstanceID: t.instance,
+		ServiceID:  serviceID,
+		PlanID:     planID,
+		State:      osb.StateSucceeded,
+	}
+	if response.Async {
+		if err := c.waitForInstance(r, response.OperationKey, w, false, "update"); err != nil {
+			return err
+		}
+	}
+	return c.printResult(r)
+}
+
+func runDeprovision(c *cli, args []string) error {
+	fs := newFlagSet("deprovision", "--instance <id> --service <service> --plan <plan>")
+	var t target
+	t.addFlags(fs, "ID of the instance")
+	var w waitOptions
+	w.addFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if t.instance == "" {
+		return usageError("--instance is required")
+	}
+	if t.plan == "" {
+		return usageError("--plan is required")
+	}
+
+	serviceID, planID, err := c.resolve(t.service, t.plan)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.client.DeprovisionInstance(&osb.DeprovisionRequest{
+		InstanceID:          t.instance,
+		AcceptsIncomplete:   w.async,
+		ServiceID:           serviceID,
+		PlanID:              planID,
+		OriginatingIdentity: c.identity,
+	})
+	if err != nil {
+		return err
+	}
+
+	r := &result{
+		InstanceID: t.instance,
+		ServiceID:  serviceID,
+		PlanID:     planID,
+		State:      osb.StateSucceeded,
+	}
+	if response.Async {
+		if err := c.waitForInstance(r, response.OperationKey, w, true, "deprovision"); err != nil {
+			return err
+		}
+	}
+	return c.printResult(r)
+}
+
+// waitForInstance records the asynchronous operation with the given key in
+// r and, unless told not to, waits for it to finish.
+func (c *cli) waitForInstance(r *result, key *osb.OperationKey, w waitOptions, gone bool, what string) error {
+	r.State = osb.StateInProgress
+	if key != nil {
+		r.Operation = string(*key)
+	}
+	if !w.wait {
+		return nil
+	}
+
+	response, err := c.wait(what+" of "+r.InstanceID, w, gone, func() (*osb.LastOperationResponse, error) {
+		return c.client.PollLastOperation(c.lastOperationRequest(r, key))
+	})
+	if response != nil {
+		r.setLastOperation(response)
+	}
+	if err != nil && response != nil {
+		// Print what the broker said about the failed operation before
+		// reporting it.
+		c.printResult(r)
+	}
+	return err
+}
+
+func (c *cli) lastOperationRequest(r *result, key *osb.OperationKey) *osb.LastOperationRequest {
+	request := &osb.LastOperationRequest{
+		InstanceID:          r.InstanceID,
+		OperationKey:        key,
+		OriginatingIdentity: c.identity,
+	}
+	if r.ServiceID != "" {
+		request.ServiceID = &r.ServiceID
+	}
+	if r.PlanID != "" {
+		request.PlanID = &r.PlanID
+	}
+	return request
+}
+
+func runLastOperation(c *cli, args []string) error {
+	fs := newFlagSet("last-operation", "--instance <id> [--operation <key>]")
+	var t target
+	t.addFlags(fs, "ID of the instance")
+	operation := fs.String("operation", "", "key of the operation the broker returned")
+	var w waitOptions
+	fs.BoolVar(&w.wait, "wait", false, "wait for the operation to finish")
+	fs.DurationVar(&w.interval, "poll-interval", 2*time.Second, "how often to poll the last operation")
+	fs.DurationVar(&w.timeout, "poll-timeout", 30*time.Minute, "how long to wait for the operation to finish")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if t.instance == "" {
+		return usageError("--instance is required")
+	}
+
+	r := &result{InstanceID: t.instance, Operation: *operation}
+	if t.service != "" {
+		var err error
+		if r.ServiceID, r.PlanID, err = c.resolve(t.service, t.plan); err != nil {
+			return err
+		}
+	}
+
+	if w.wait {
+		if err := c.waitForInstance(r, operationKey(*operation), w, false, "operation"); err != nil {
+			return err
+		}
+		return c.printResult(r)
+	}
+	response, err := c.client.PollLastOperation(c.lastOperationRequest(r, operationKey(*operation)))
+	if err != nil {
+		return err
+	}
+	r.setLastOperation(response)
+	return c.printResult(r)
+}
+
+func runBind(c *cli, args []string) error {
+	fs := newFlagSet("bind", "--instance <id> --service <service> --plan <plan> [--binding <id>]")
+	var t target
+	t.addFlags(fs, "ID of the instance to bind to")
+	binding := fs.String("binding", "", "ID of the binding; a random ID is generated if empty")
+	appGUID := fs.String("app-guid", "", "GUID of the application to bind")
+	var p requestParameters
+	p.addFlags(fs)
+	var w waitOptions
+	w.addFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if t.instance == "" {
+		return usageError("--instance is required")
+	}
+	if t.plan == "" {
+		return usageError("--plan is required")
+	}
+	if *binding == "" {
+		*binding = uuid.New()
+	}
+
+	serviceID, planID, err := c.resolve(t.service, t.plan)
+	if err != nil {
+		return err
+	}
+	params, context, err := p.build()
+	if err != nil {
+		return err
+	}
+
+	request := &osb.BindRequest{
+		BindingID:           *binding,
+		InstanceID:          t.instance,
+		AcceptsIncomplete:   w.async,
+		ServiceID:           serviceID,
+		PlanID:              planID,
+		Parameters:          params,
+		Context:             context,
+		OriginatingIdentity: c.identity,
+	}
+	if *appGUID != "" {
+		request.AppGUID = appGUID
+		request.BindResource = &osb.BindResource{AppGUID: appGUID}
+	}
+	response, err := c.client.Bind(request)
+	if err != nil {
+		return err
+	}
+
+	r := &result{
+		InstanceID:  t.instance,
+		BindingID:   *binding,
+		ServiceID:   serviceID,
+		PlanID:      planID,
+		State:       osb.StateSucceeded,
+		Credentials: response.Credentials,
+	}
+	if response.Async {
+		if err := c.waitForBinding(r, response.OperationKey, w, false, "bind"); err != nil {
+			return err
+		}
+		if r.State == osb.StateSucceeded {
+			// Credentials of an asynchronous binding are only returned by
+			// fetching it once it has been created.
+			b, err := c.client.GetBinding(&osb.GetBindingRequest{
+				InstanceID: r.InstanceID,
+				BindingID:  r.BindingID,
+			})
+			if err != nil {
+				return err
+			}
+			r.Credentials = b.Credentials
+		}
+	}
+	return c.printResult(r)
+}
+
+func runUnbind(c *cli, args []string) error {
+	fs := newFlagSet("unbind", "--instance <id> --binding <id> --service <service> --plan <plan>")
+	var t target
+	t.addFlags(fs, "ID of the instance")
+	binding := fs.String("binding", "", "ID of the binding")
+	var w waitOptions
+	w.addFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if t.instance == "" || *binding == "" {
+		return usageError("--instance and --binding are required")
+	}
+	if t.plan == "" {
+		return usageError("--plan is required")
+	}
+
+	serviceID, planID, err := c.resolve(t.service, t.plan)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.client.Unbind(&osb.UnbindRequest{
+		InstanceID:          t.instance,
+		BindingID:           *binding,
+		AcceptsIncomplete:   w.async,
+		ServiceID:           serviceID,
+		PlanID:              planID,
+		OriginatingIdentity: c.identity,
+	})
+	if err != nil {
+		return err
+	}
+
+	r := &result{
+		InstanceID: t.instance,
+		BindingID:  *binding,
+		ServiceID:  serviceID,
+		PlanID:     planID,
+		State:      osb.StateSucceeded,
+	}
+	if response.Async {
+		if err := c.waitForBinding(r, response.OperationKey, w, true, "unbind"); err != nil {
+			return err
+		}
+	}
+	return c.printResult(r)
+}
+
+// waitForBinding records the asynchronous operation on a binding with the
+// given key in r and, unless told not to, waits for it to finish.
+func (c *cli) waitForBinding(r *result, key *osb.OperationKey, w waitOptions, gone bool, what string) error {
+	r.State = osb.StateInProgress
+	if key != nil {
+		r.Operation = string(*key)
+	}
+	if !w.wait {
+		return nil
+	}
+
+	response, err := c.wait(what+" of "+r.BindingID, w, gone, func() (*osb.LastOperationResponse, error) {
+		request := &osb.BindingLastOperationRequest{
+			InstanceID:          r.InstanceID,
+			BindingID:           r.BindingID,
+			OperationKey:        key,
+			OriginatingIdentity: c.identity,
+		}
+		if r.ServiceID != "" {
+			request.ServiceID = &r.ServiceID
+		}
+		if r.PlanID != "" {
+			request.PlanID = &r.PlanID
+		}
+		return c.client.PollBindingLastOperation(request)
+	})
+	if response != nil {
+		r.setLastOperation(response)
+	}
+	if err != nil && response != nil {
+		c.printResult(r)
+	}
+	return err
+}
+
+func runGetBinding(c *cli, args []string) error {
+	fs := newFlagSet("get-binding", "--instance <id> --binding <id>")
+	instance := fs.String("instance", "", "ID of the instance")
+	binding := fs.String("binding", "", "ID of the binding")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *instance == "" || *binding == "" {
+		return usageError("--instance and --binding are required")
+	}
+
+	response, err := c.client.GetBinding(&osb.GetBindingRequest{
+		InstanceID: *instance,
+		BindingID:  *binding,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.printResult(&result{
+		InstanceID:  *instance,
+		BindingID:   *binding,
+		State:       osb.StateSucceeded,
+		Credentials: response.Credentials,
+		Parameters:  response.Parameters,
+	})
+}
@@ -0,0 +1,242 @@
+// osbctl drives an Open Service Broker API broker from the terminal. It sends
+// every OSB operation with the go-open-service-broker-client library, waits
+// for asynchronous operations by polling their last operation and prints the
+// results as tables or JSON.
+//
+// Global options can also be set by OSBCTL_ environment variables, for
+// example OSBCTL_URL or OSBCTL_TOKEN=file:/path/to/token.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/config"
+)
+
+var options struct {
+	URL              string
+	Username         string
+	Password         string
+	Token            string
+	Insecure         bool
+	CAFile           string
+	APIVersion       string
+	Timeout          time.Duration
+	Output           string
+	IdentityPlatform string
+	IdentityValue    string
+}
+
+// Exit codes of osbctl.
+const (
+	exitOK = 0
+	// exitError means the broker or the connection to it returned an error,
+	// or an asynchronous operation failed.
+	exitError = 1
+	// exitUsage means osbctl was run with invalid arguments.
+	exitUsage = 2
+)
+
+// command is an osbctl subcommand.
+type command struct {
+	name        string
+	description string
+	run         func(c *cli, args []string) error
+}
+
+var commands = []command{
+	{"catalog", "show the services and plans the broker offers", runCatalog},
+	{"provision", "provision a service instance", runProvision},
+	{"update", "update the plan or parameters of a service instance", runUpdate},
+	{"deprovision", "deprovision a service instance", runDeprovision},
+	{"last-operation", "show the state of the last operation on a service instance", runLastOperation},
+	{"bind", "create a binding to a service instance and show its credentials", runBind},
+	{"unbind", "delete a binding", runUnbind},
+	{"get-binding", "show a binding, if the service's bindings are retrievable", runGetBinding},
+}
+
+func init() {
+	flag.StringVar(&options.URL, "url", "https://localhost:8443", "URL of the broker")
+	flag.StringVar(&options.Username, "username", "", "username for basic authentication")
+	flag.StringVar(&options.Password, "password", "", "password for basic authentication")
+	flag.StringVar(&options.Token, "token", "", "bearer token, for example a Kubernetes service account token")
+	flag.BoolVar(&options.Insecure, "insecure", false, "skip verification of the broker's TLS certificate")
+	flag.StringVar(&options.CAFile, "ca-file", "", "file containing the PEM encoded CA certificate to verify the broker's TLS certificate with")
+	flag.StringVar(&options.APIVersion, "api-version", osb.LatestAPIVersion().HeaderValue(), "OSB API version to send, one of 2.11, 2.12 or 2.13")
+	flag.DurationVar(&options.Timeout, "timeout", 60*time.Second, "timeout of each request to the broker")
+	flag.StringVar(&options.Output, "output", "table", "output format, either 'table' or 'json'")
+	flag.StringVar(&options.IdentityPlatform, "identity-platform", "", "platform of the originating identity to send, for example 'kubernetes'")
+	flag.StringVar(&options.IdentityValue, "identity-value", "", "JSON value of the originating identity to send, for example '{\"username\":\"alice\"}'")
+	flag.Usage = usage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: osbctl [options] <command> [command options]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s%s\n", c.name, c.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'osbctl <command> -h' for the options of a command.\n\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Parse()
+
+	config.MarkSecret("password", "token")
+	if _, err := config.Apply(flag.CommandLine, "OSBCTL_", ""); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	c, err := newCLI()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	err = cmd.run(c, flag.Args()[1:])
+	switch err.(type) {
+	case nil:
+		os.Exit(exitOK)
+	case usageError:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	if err == flag.ErrHelp {
+		os.Exit(exitUsage)
+	}
+	fmt.Fprintln(os.Stderr, "error:", describeError(err))
+	os.Exit(exitError)
+}
+
+// usageError is returned by commands run with invalid arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// cli holds what every command needs to talk to the broker.
+type cli struct {
+	client   osb.Client
+	output   string
+	identity *osb.OriginatingIdentity
+
+	// catalog caches the broker's catalog once a command has fetched it to
+	// look up a service or plan by name.
+	catalog *osb.CatalogResponse
+}
+
+func newCLI() (*cli, error) {
+	c := &cli{output: options.Output}
+	if c.output != outputTable && c.output != outputJSON {
+		return nil, fmt.Errorf("unknown output format %q", c.output)
+	}
+
+	clientConfig := osb.DefaultClientConfiguration()
+	clientConfig.Name = "osbctl"
+	clientConfig.URL = options.URL
+	clientConfig.Insecure = options.Insecure
+	clientConfig.TimeoutSeconds = int(options.Timeout / time.Second)
+	clientConfig.TLSConfig = &tls.Config{}
+	// The client only allows asynchronous bindings with alpha features
+	// enabled.
+	clientConfig.EnableAlphaFeatures = true
+
+	switch options.APIVersion {
+	case "2.11":
+		clientConfig.APIVersion = osb.Version2_11()
+	case "2.12":
+		clientConfig.APIVersion = osb.Version2_12()
+	case "2.13":
+		clientConfig.APIVersion = osb.Version2_13()
+	default:
+		return nil, fmt.Errorf("unsupported API version %q", options.APIVersion)
+	}
+
+	switch {
+	case options.Token != "" && (options.Username != "" || options.Password != ""):
+		return nil, fmt.Errorf("--token cannot be used with --username or --password")
+	case options.Token != "":
+		clientConfig.AuthConfig = &osb.AuthConfig{
+			BearerConfig: &osb.BearerConfig{Token: options.Token},
+		}
+	case options.Username != "":
+		clientConfig.AuthConfig = &osb.AuthConfig{
+			BasicAuthConfig: &osb.BasicAuthConfig{
+				Username: options.Username,
+				Password: options.Password,
+			},
+		}
+	}
+
+	if options.CAFile != "" {
+		caData, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.CAData = caData
+	}
+
+	if options.IdentityPlatform != "" || options.IdentityValue != "" {
+		if options.IdentityPlatform == "" || options.IdentityValue == "" {
+			return nil, fmt.Errorf("--identity-platform and --identity-value must be used together")
+		}
+		c.identity = &osb.OriginatingIdentity{
+			Platform: options.IdentityPlatform,
+			Value:    options.IdentityValue,
+		}
+	}
+
+	client, err := osb.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+	return c, nil
+}
+
+// describeError returns a readable description of an error returned by the
+// client.
+func describeError(err error) string {
+	httpErr, ok := osb.IsHTTPError(err)
+	if !ok {
+		return err.Error()
+	}
+
+	s := fmt.Sprintf("the broker responded with status %d", httpErr.StatusCode)
+	if httpErr.ErrorMessage != nil {
+		s += " " + *httpErr.ErrorMessage
+	}
+	if httpErr.Description != nil {
+		s += ": " + *httpErr.Description
+	}
+	if httpErr.ResponseError != nil {
+		s += fmt.Sprintf(" (unable to read the response: %v)", httpErr.ResponseError)
+	}
+	return s
+}
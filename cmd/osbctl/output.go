@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Output formats.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// result is the outcome of a command acting on an instance or binding.
+type result struct {
+	InstanceID   string                 `json:"instance_id"`
+	BindingID    string                 `json:"binding_id,omitempty"`
+	ServiceID    string                 `json:"service_id,omitempty"`
+	PlanID       string                 `json:"plan_id,omitempty"`
+	Operation    string                 `json:"operation,omitempty"`
+	State        osb.LastOperationState `json:"state"`
+	Description  string                 `json:"description,omitempty"`
+	DashboardURL string                 `json:"dashboard_url,omitempty"`
+	Credentials  map[string]interface{} `json:"credentials,omitempty"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// setLastOperation records the outcome of an operation in r.
+func (r *result) setLastOperation(response *osb.LastOperationResponse) {
+	r.State = response.State
+	r.Description = ""
+	if response.Description != nil {
+		r.Description = *response.Description
+	}
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) printResult(r *result) error {
+	if c.output == outputJSON {
+		return c.printJSON(r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	rows := [][2]string{
+		{"INSTANCE ID", r.InstanceID},
+		{"BINDING ID", r.BindingID},
+		{"SERVICE ID", r.ServiceID},
+		{"PLAN ID", r.PlanID},
+		{"OPERATION", r.Operation},
+		{"STATE", string(r.State)},
+		{"DESCRIPTION", r.Description},
+		{"DASHBOARD URL", r.DashboardURL},
+	}
+	for _, row := range rows {
+		if row[1] != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", row[0], row[1])
+		}
+	}
+	printValues(w, "PARAMETERS", r.Parameters)
+	printValues(w, "CREDENTIALS", r.Credentials)
+	return w.Flush()
+}
+
+// printValues prints the values of m, flattened to dotted keys, under a
+// heading.
+func printValues(w *tabwriter.Writer, heading string, m map[string]interface{}) {
+	if len(m) == 0 {
+		return
+	}
+
+	flat := map[string]interface{}{}
+	flatten("", m, flat)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "%s:\t\n", heading)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s\t%v\n", k, flat[k])
+	}
+}
+
+func flatten(prefix string, m map[string]interface{}, flat map[string]interface{}) {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flatten(k, nested, flat)
+			continue
+		}
+		flat[k] = v
+	}
+}
+
+func (c *cli) printCatalog(catalog *osb.CatalogResponse) error {
+	if c.output == outputJSON {
+		return c.printJSON(catalog)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tPLAN\tSERVICE ID\tPLAN ID\tFREE\tBINDABLE\tDESCRIPTION")
+	for _, s := range catalog.Services {
+		for _, p := range s.Plans {
+			free := p.Free == nil || *p.Free
+			bindable := s.Bindable
+			if p.Bindable != nil {
+				bindable = *p.Bindable
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%t\t%s\n", s.Name, p.Name, s.ID, p.ID, free, bindable, p.Description)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// setFlags collects repeated --set key=value flags.
+type setFlags []string
+
+func (s *setFlags) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *setFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("%q must have the form key=value", value)
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+// readObject reads a JSON or YAML object from the file at path, or from
+// stdin if path is "-".
+func readObject(path string) (map[string]interface{}, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	object := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("unable to parse %q: %v", path, err)
+	}
+	return object, nil
+}
+
+// parameters builds the parameters of a request from a parameters file, if
+// path is set, and --set flags, which override the file. Values set with
+// --set are parsed as YAML, so numbers and booleans keep their type; a dotted
+// key sets a nested value.
+func parameters(path string, set setFlags) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if path != "" {
+		var err error
+		if params, err = readObject(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range set {
+		kv := strings.SplitN(s, "=", 2)
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(kv[1]), &value); err != nil {
+			return nil, fmt.Errorf("invalid value in --set %q: %v", s, err)
+		}
+
+		keys := strings.Split(kv[0], ".")
+		m := params
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := m[key].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				m[key] = next
+			}
+			m = next
+		}
+		m[keys[len(keys)-1]] = value
+	}
+
+	if len(params) == 0 {
+		return nil, nil
+	}
+	return params, nil
+}
+
+// platformContext builds the context of a request from a context file, if
+// path is set, or else from the Kubernetes namespace or Cloud Foundry
+// organization and space.
+func platformContext(path, namespace, org, space string) (map[string]interface{}, error) {
+	switch {
+	case path != "":
+		return readObject(path)
+	case namespace != "":
+		return map[string]interface{}{
+			"platform":  "kubernetes",
+			"namespace": namespace,
+		}, nil
+	case org != "" || space != "":
+		return map[string]interface{}{
+			"platform":          "cloudfoundry",
+			"organization_guid": org,
+			"space_guid":        space,
+		}, nil
+	}
+	return nil, nil
+}
+
+// resolve returns the IDs of the service and plan with the given IDs or
+// names. The catalog is only fetched if one of them is not an ID in it.
+func (c *cli) resolve(service, plan string) (serviceID, planID string, err error) {
+	if service == "" {
+		return "", "", usageError("--service is required")
+	}
+
+	if c.catalog == nil {
+		if c.catalog, err = c.client.GetCatalog(); err != nil {
+			return "", "", err
+		}
+	}
+
+	for _, s := range c.catalog.Services {
+		if s.ID != service && s.Name != service {
+			continue
+		}
+		if plan == "" {
+			return s.ID, "", nil
+		}
+		for _, p := range s.Plans {
+			if p.ID == plan || p.Name == plan {
+				return s.ID, p.ID, nil
+			}
+		}
+		return "", "", fmt.Errorf("service %q has no plan %q", s.Name, plan)
+	}
+	return "", "", fmt.Errorf("the broker's catalog has no service %q", service)
+}
+
+func operationKey(key string) *osb.OperationKey {
+	if key == "" {
+		return nil
+	}
+	k := osb.OperationKey(key)
+	return &k
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// waitOptions control how a command waits for an asynchronous operation.
+type waitOptions struct {
+	async    bool
+	wait     bool
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func (w *waitOptions) addFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&w.async, "accepts-incomplete", true, "allow the broker to complete the operation asynchronously")
+	fs.BoolVar(&w.wait, "wait", true, "wait for an asynchronous operation to finish, polling its last operation")
+	fs.DurationVar(&w.interval, "poll-interval", 2*time.Second, "how often to poll the last operation")
+	fs.DurationVar(&w.timeout, "poll-timeout", 30*time.Minute, "how long to wait for the operation to finish")
+}
+
+// pollFunc polls the last operation of an instance or binding.
+type pollFunc func() (*osb.LastOperationResponse, error)
+
+// wait polls an asynchronous operation until it succeeds or fails, showing
+// its progress on stderr. If gone is true, a 410 Gone response means the
+// operation deleted what it acted on and so succeeded, as when
+// deprovisioning.
+func (c *cli) wait(what string, w waitOptions, gone bool, poll pollFunc) (*osb.LastOperationResponse, error) {
+	p := newProgress(os.Stderr, what)
+	defer p.done()
+
+	deadline := time.Now().Add(w.timeout)
+	for {
+		response, err := poll()
+		if err != nil && gone && osb.IsGoneError(err) {
+			response, err = &osb.LastOperationResponse{State: osb.StateSucceeded}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.update(response)
+		switch response.State {
+		case osb.StateSucceeded:
+			return response, nil
+		case osb.StateFailed:
+			description := "no description given"
+			if response.Description != nil {
+				description = *response.Description
+			}
+			return response, fmt.Errorf("%s failed: %s", what, description)
+		}
+
+		if time.Now().After(deadline) {
+			return response, fmt.Errorf("%s did not finish within %v", what, w.timeout)
+		}
+		time.Sleep(w.interval)
+	}
+}
+
+// progress shows the state of an operation being polled. On a terminal the
+// status line is rewritten in place; otherwise a line is written whenever the
+// state or description changes.
+type progress struct {
+	out      io.Writer
+	what     string
+	start    time.Time
+	terminal bool
+	last     string
+}
+
+func newProgress(out *os.File, what string) *progress {
+	p := &progress{out: out, what: what, start: time.Now()}
+	if info, err := out.Stat(); err == nil {
+		p.terminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	return p
+}
+
+func (p *progress) update(response *osb.LastOperationResponse) {
+	status := string(response.State)
+	if response.Description != nil && *response.Description != "" {
+		status += ": " + *response.Description
+	}
+
+	elapsed := time.Since(p.start) / time.Second * time.Second
+	if p.terminal {
+		fmt.Fprintf(p.out, "\r\033[K%s %s (%v)", p.what, status, elapsed)
+		p.last = status
+		return
+	}
+	if status != p.last {
+		fmt.Fprintf(p.out, "%s %s (%v)\n", p.what, status, elapsed)
+		p.last = status
+	}
+}
+
+func (p *progress) done() {
+	if p.terminal && p.last != "" {
+		fmt.Fprintln(p.out)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmorie/osb-starter-pack/pkg/audit"
+)
+
+// runAudit implements the 'audit' subcommand, which searches the audit trail
+// written with --audit-log.
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	filter := &audit.Filter{}
+	fs.StringVar(&filter.Action, "action", "", "only show records for this action, for example 'provision'")
+	fs.StringVar(&filter.InstanceID, "instance", "", "only show records for this instance ID")
+	fs.StringVar(&filter.BindingID, "binding", "", "only show records for this binding ID")
+	fs.StringVar(&filter.User, "user", "", "only show records for this Kubernetes username or Cloud Foundry user_id")
+	fs.StringVar(&filter.Outcome, "outcome", "", "only show records with this outcome: succeeded, accepted or failed")
+	since := fs.String("since", "", "only show records started after this RFC3339 time or this long ago, for example '24h'")
+	output := fs.String("output", "json", "output format, either 'json' or 'table'")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if options.AuditLog == "" {
+		return fmt.Errorf("--audit-log must be set to query the audit trail")
+	}
+	if *since != "" {
+		t, err := parseSince(*since)
+		if err != nil {
+			return err
+		}
+		filter.Since = t
+	}
+
+	records, err := audit.Query(options.AuditLog, options.AuditLogMaxBackups, filter)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "STARTED\tACTION\tINSTANCE\tBINDING\tUSER\tOUTCOME")
+		for _, r := range records {
+			user := ""
+			if r.Identity != nil {
+				user = r.Identity.User
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Started.Format(time.RFC3339), r.Action, r.InstanceID, r.BindingID, user, r.Outcome)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	return nil
+}
+
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q: must be a duration or an RFC3339 time", s)
+	}
+	return t, nil
+}
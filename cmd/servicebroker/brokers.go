@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/shawn-hurley/osb-broker-k8s-lib/middleware"
+
+	brokerapi "github.com/pmorie/osb-broker-lib/pkg/broker"
+	"github.com/pmorie/osb-starter-pack/pkg/admin"
+	"github.com/pmorie/osb-starter-pack/pkg/audit"
+	"github.com/pmorie/osb-starter-pack/pkg/broker"
+	"github.com/pmorie/osb-starter-pack/pkg/brokers"
+	"github.com/pmorie/osb-starter-pack/pkg/catalog"
+	"github.com/pmorie/osb-starter-pack/pkg/config"
+	"github.com/pmorie/osb-starter-pack/pkg/health"
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+	"github.com/pmorie/osb-starter-pack/pkg/maintenance"
+	"github.com/pmorie/osb-starter-pack/pkg/policy"
+	"github.com/pmorie/osb-starter-pack/pkg/quota"
+	"github.com/pmorie/osb-starter-pack/pkg/ratelimit"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+	"github.com/pmorie/osb-starter-pack/pkg/webhook"
+)
+
+// brokersFile is the file named by --brokers-file, for example:
+//
+//	brokers:
+//	- name: team-a
+//	  stateFile: /var/lib/servicebroker/team-a.json
+//	  options:
+//	    workload-dir: /etc/servicebroker/team-a
+//	    async: true
+//	- name: team-b
+//	  prefix: /brokers/team-b
+//	  authenticateK8SToken: false
+//	  basicAuth:
+//	    username: team-b
+//	    password: file:/etc/servicebroker/team-b-password
+//	  options:
+//	    namespace-service: true
+type brokersFile struct {
+	Brokers []brokerConfig `json:"brokers"`
+}
+
+// brokerConfig configures one of the brokers the process serves.
+type brokerConfig struct {
+	// Name identifies the broker in metrics, logs and health checks.
+	Name string `json:"name"`
+	// Prefix is the path the broker is served under. It defaults to a slash
+	// followed by the broker's name.
+	Prefix string `json:"prefix,omitempty"`
+	// StateFile is the file the broker keeps its instances and bindings in,
+	// like --state-file. Brokers do not share state.
+	StateFile string `json:"stateFile,omitempty"`
+	// AuthenticateK8SToken overrides --authenticate-k8s-token for the
+	// broker.
+	AuthenticateK8SToken *bool `json:"authenticateK8SToken,omitempty"`
+	// BasicAuth, if set, requires requests to the broker to use HTTP basic
+	// authentication with these credentials.
+	BasicAuth *basicAuthConfig `json:"basicAuth,omitempty"`
+	// Options sets the broker's options by the names of the flags
+	// broker.AddFlags defines for them. Options it does not set have their
+	// flags' defaults, not the values of the skeleton's flags.
+	Options map[string]interface{} `json:"options,omitempty"`
+
+	options broker.Options
+}
+
+type basicAuthConfig struct {
+	Username string `json:"username"`
+	// Password may be a file reference, as in the file named by --config.
+	Password string `json:"password"`
+}
+
+// loadBrokers reads the configuration of the brokers to serve from the file
+// at path.
+func loadBrokers(path string) ([]brokerConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f brokersFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse brokers file %q: %v", path, err)
+	}
+	if len(f.Brokers) == 0 {
+		return nil, fmt.Errorf("brokers file %q has no brokers", path)
+	}
+
+	// stateFiles maps the state files of the brokers so far to their
+	// names. Brokers sharing a state file would overwrite each other's
+	// records.
+	stateFiles := map[string]string{}
+	for i := range f.Brokers {
+		c := &f.Brokers[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("broker %d in brokers file %q has no name", i+1, path)
+		}
+		if c.StateFile != "" {
+			stateFile, err := filepath.Abs(c.StateFile)
+			if err != nil {
+				return nil, err
+			}
+			if other, ok := stateFiles[stateFile]; ok {
+				return nil, fmt.Errorf("brokers %q and %q in brokers file %q have the same state file %q", other, c.Name, path, c.StateFile)
+			}
+			stateFiles[stateFile] = c.Name
+		}
+		if c.Prefix == "" {
+			c.Prefix = "/" + c.Name
+		}
+
+		fs := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+		broker.AddFlags(fs, &c.options)
+		if _, err := config.ApplyValues(fs, c.Options, fmt.Sprintf("the options of broker %q", c.Name)); err != nil {
+			return nil, err
+		}
+		c.options.KubernetesConfig = options.KubernetesConfig
+
+		if c.BasicAuth != nil && strings.HasPrefix(c.BasicAuth.Password, config.FileReferencePrefix) {
+			password, err := ioutil.ReadFile(strings.TrimPrefix(c.BasicAuth.Password, config.FileReferencePrefix))
+			if err != nil {
+				return nil, fmt.Errorf("unable to read basic auth password of broker %q: %v", c.Name, err)
+			}
+			c.BasicAuth.Password = strings.TrimRight(string(password), "\r\n")
+		}
+	}
+	return f.Brokers, nil
+}
+
+// openStore opens the store a broker keeps its instances and bindings in:
+// the file at stateFile, or memory if it is empty.
+func openStore(stateFile string) (state.Store, error) {
+	if stateFile == "" {
+		return state.NewMemory(), nil
+	}
+	store, err := state.NewFile(stateFile)
+	if err == state.ErrLocked {
+		return nil, fmt.Errorf("unable to open %q: %v", stateFile, err)
+	} else if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// brokerServer holds what the brokers a process serves share.
+type brokerServer struct {
+	health   *health.Registry
+	policy   *policy.Policy
+	quota    *quota.Config
+	limiter  *ratelimit.Limiter
+	webhooks *webhook.Dispatcher
+	sink     audit.Sink
+	// tokenReview is the middleware that authenticates requests with
+	// Kubernetes tokens, which is created on first use.
+	tokenReview func(http.Handler) http.Handler
+	// logics are the business logics of the brokers, to shut down and
+	// reload, and names the names of the brokers.
+	logics []*broker.BusinessLogic
+	names  []string
+}
+
+// newBroker creates the broker c configures.
+func (s *brokerServer) newBroker(c brokerConfig) (brokers.Broker, error) {
+	store, err := openStore(c.StateFile)
+	if err != nil {
+		return brokers.Broker{}, err
+	}
+	if s.quota != nil {
+		store = quota.NewStore(store, s.quota)
+	}
+	if s.webhooks != nil {
+		store = webhook.NewStore(store, s.webhooks, c.Name)
+	}
+
+	businessLogic, err := broker.NewBusinessLogic(c.options, store)
+	if err != nil {
+		return brokers.Broker{}, err
+	}
+	s.logics = append(s.logics, businessLogic)
+	s.names = append(s.names, c.Name)
+
+	checks := s.health
+	logger := log.Default()
+	if c.Name != "" {
+		checks = checks.Sub(c.Name)
+		logger = logger.With("broker", c.Name)
+	}
+	businessLogic.RegisterHealthChecks(checks)
+
+	var osbBroker brokerapi.Interface = businessLogic
+	if s.policy != nil {
+		osbBroker = policy.NewBroker(osbBroker, s.policy, store)
+	}
+	if s.sink != nil {
+		osbBroker = audit.NewBroker(osbBroker, s.sink)
+	}
+	osbBroker = log.NewBroker(osbBroker, logger)
+
+	b := brokers.Broker{
+		Name:      c.Name,
+		Prefix:    c.Prefix,
+		Interface: osbBroker,
+	}
+	authenticate := options.AuthenticateK8SToken
+	if c.AuthenticateK8SToken != nil {
+		authenticate = *c.AuthenticateK8SToken
+	}
+	if authenticate {
+		tr, err := s.tokenReviewMiddleware()
+		if err != nil {
+			return brokers.Broker{}, err
+		}
+		b.Middleware = append(b.Middleware, health.Exempt(brokers.IdentifyToken(tr)))
+	}
+	if c.BasicAuth != nil {
+		b.Middleware = append(b.Middleware, health.Exempt(brokers.BasicAuth(c.BasicAuth.Username, c.BasicAuth.Password)))
+	}
+	if s.limiter != nil {
+		b.Middleware = append(b.Middleware, s.limiter.Middleware)
+	}
+	b.Middleware = append(b.Middleware, maintenance.Middleware, catalog.Middleware(businessLogic.Catalog()))
+	return b, nil
+}
+
+// adminHandler returns the handler of the admin API of the brokers s serves,
+// which authenticates requests with --admin-token.
+func (s *brokerServer) adminHandler() (http.Handler, error) {
+	served := make([]admin.Broker, len(s.logics))
+	for i, businessLogic := range s.logics {
+		served[i] = admin.Broker{
+			Name:    s.names[i],
+			Backend: businessLogic,
+		}
+		if options.AuditLog != "" {
+			served[i].AuditTrail = func(f *audit.Filter) ([]*audit.Record, error) {
+				return audit.Query(options.AuditLog, options.AuditLogMaxBackups, f)
+			}
+		}
+	}
+	h, err := admin.NewHandler(served)
+	if err != nil {
+		return nil, err
+	}
+	return admin.TokenAuth(options.AdminToken)(h), nil
+}
+
+// tokenReviewMiddleware returns the middleware that authenticates requests
+// with Kubernetes tokens.
+func (s *brokerServer) tokenReviewMiddleware() (func(http.Handler) http.Handler, error) {
+	if s.tokenReview != nil {
+		return s.tokenReview, nil
+	}
+	k8sClient, err := getKubernetesClient(options.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.health.AddReadinessCheck("kubernetes", health.CheckerFunc(func(ctx context.Context) error {
+		_, err := k8sClient.Discovery().ServerVersion()
+		return err
+	}))
+	// Create a User Info Authorizer.
+	authz := middleware.SARUserInfoAuthorizer{
+		SAR: k8sClient.AuthorizationV1().SubjectAccessReviews(),
+	}
+	// create TokenReviewMiddleware
+	tr := middleware.TokenReviewMiddleware{
+		TokenReview: k8sClient.Authentication().TokenReviews(),
+		Authorizer:  authz,
+	}
+	s.tokenReview = tr.Middleware
+	return s.tokenReview, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pmorie/osb-starter-pack/pkg/broker"
+	"github.com/pmorie/osb-starter-pack/pkg/conformance"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+	brokertesting "github.com/pmorie/osb-starter-pack/pkg/testing"
+)
+
+// runConformance implements the 'conformance' subcommand, which checks that a
+// broker behaves as the OSB API specification requires. Without --url, it
+// checks this broker's business logic, run in-process with the options the
+// command was run with.
+func runConformance(args []string) error {
+	fs := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	c := conformance.Config{}
+	fs.StringVar(&c.URL, "url", "", "URL of the broker to check; the business logic is checked in-process if empty")
+	fs.StringVar(&c.APIVersion, "api-version", "", "OSB API version to send, 2.11, 2.12 or 2.13; defaults to the latest version")
+	fs.StringVar(&c.Username, "username", "", "username for basic authentication")
+	fs.StringVar(&c.Password, "password", "", "password for basic authentication")
+	fs.StringVar(&c.Token, "token", "", "bearer token")
+	fs.BoolVar(&c.Insecure, "insecure", false, "skip verification of the broker's TLS certificate")
+	fs.StringVar(&c.ServiceID, "service", "", "ID of the service to provision; defaults to the first bindable service")
+	fs.StringVar(&c.PlanID, "plan", "", "ID of the plan to provision; defaults to the first bindable plan")
+	fs.DurationVar(&c.PollInterval, "poll-interval", 0, "how often to poll asynchronous operations")
+	fs.DurationVar(&c.PollTimeout, "poll-timeout", 0, "how long asynchronous operations may take")
+	output := fs.String("output", "text", "output format, either 'text' or 'json'")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	if c.URL == "" {
+		url, stop, err := serveInProcess()
+		if err != nil {
+			return err
+		}
+		defer stop()
+		c.URL = url
+	}
+
+	report, err := conformance.Run(c)
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	if !report.Passed() {
+		return fmt.Errorf("%d conformance checks failed", report.Count(conformance.Fail))
+	}
+	return nil
+}
+
+// serveInProcess serves the business logic, with an in-memory store, from a
+// local test server. It returns the server's URL and a func that stops it.
+func serveInProcess() (string, func(), error) {
+	businessLogic, err := broker.NewBusinessLogic(options.Options, state.NewMemory())
+	if err != nil {
+		return "", nil, err
+	}
+
+	srv, err := brokertesting.Start(businessLogic, brokertesting.Options{})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return srv.URL, func() {
+		srv.Close()
+		businessLogic.Shutdown(context.Background())
+	}, nil
+}
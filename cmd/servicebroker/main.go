@@ -2,25 +2,37 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/golang/glog"
+	"github.com/gorilla/mux"
 	prom "github.com/prometheus/client_golang/prometheus"
-	"github.com/shawn-hurley/osb-broker-k8s-lib/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	clientset "k8s.io/client-go/kubernetes"
 	clientrest "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
-	"github.com/pmorie/osb-broker-lib/pkg/metrics"
-	"github.com/pmorie/osb-broker-lib/pkg/rest"
-	"github.com/pmorie/osb-broker-lib/pkg/server"
+	"github.com/pmorie/osb-starter-pack/pkg/admin"
+	"github.com/pmorie/osb-starter-pack/pkg/audit"
 	"github.com/pmorie/osb-starter-pack/pkg/broker"
+	"github.com/pmorie/osb-starter-pack/pkg/brokers"
+	"github.com/pmorie/osb-starter-pack/pkg/config"
+	"github.com/pmorie/osb-starter-pack/pkg/health"
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+	"github.com/pmorie/osb-starter-pack/pkg/policy"
+	"github.com/pmorie/osb-starter-pack/pkg/quota"
+	"github.com/pmorie/osb-starter-pack/pkg/ratelimit"
+	"github.com/pmorie/osb-starter-pack/pkg/webhook"
 )
 
 var options struct {
@@ -34,8 +46,25 @@ var options struct {
 	TLSKeyFile           string
 	AuthenticateK8SToken bool
 	KubeConfig           string
+	LogFormat            string
+	AuditLog             string
+	AuditLogMaxSize      int
+	AuditLogMaxBackups   int
+	PolicyFile           string
+	QuotaFile            string
+	RateLimitFile        string
+	WebhooksFile         string
+	StateFile            string
+	BrokersFile          string
+	AdminToken           string
+	AdminPort            int
+	ShutdownGracePeriod  time.Duration
+	ConfigFile           string
 }
 
+// effectiveConfig records where the value of every option came from.
+var effectiveConfig *config.Config
+
 func init() {
 	flag.IntVar(&options.Port, "port", 8443, "use '--port' option to specify the port for broker to listen on")
 	flag.BoolVar(&options.Insecure, "insecure", false, "use --insecure to use HTTP vs HTTPS.")
@@ -45,14 +74,65 @@ func init() {
 	flag.StringVar(&options.TLSKey, "tlsKey", "", "base-64 encoded PEM block to use as the private key matching the TLS certificate.")
 	flag.BoolVar(&options.AuthenticateK8SToken, "authenticate-k8s-token", false, "option to specify if the broker should validate the bearer auth token with kubernetes")
 	flag.StringVar(&options.KubeConfig, "kube-config", "", "specify the kube config path to be used")
-	broker.AddFlags(&options.Options)
+	flag.StringVar(&options.LogFormat, "log-format", log.FormatLogfmt, "format of log entries, either 'logfmt' or 'json'")
+	flag.StringVar(&options.AuditLog, "audit-log", "", "path of the file to write the audit trail of state-changing requests to; auditing is disabled if empty")
+	flag.IntVar(&options.AuditLogMaxSize, "audit-log-max-size", 100, "size in megabytes at which the audit log is rotated")
+	flag.IntVar(&options.AuditLogMaxBackups, "audit-log-max-backups", 5, "number of rotated audit log files to keep; at least 1")
+	flag.StringVar(&options.PolicyFile, "policy-file", "", "path of a YAML or JSON file with the policy used to authorize requests by originating identity")
+	flag.StringVar(&options.QuotaFile, "quota-file", "", "path of a YAML or JSON file with per-namespace, organization and space quotas on instances and bindings")
+	flag.StringVar(&options.RateLimitFile, "rate-limit-file", "", "path of a YAML or JSON file with per-client rate limits on OSB actions and a cap on concurrent mutating requests")
+	flag.StringVar(&options.WebhooksFile, "webhooks-file", "", "path of a YAML or JSON file with HTTP endpoints to post signed events to when instances and bindings change")
+	flag.StringVar(&options.StateFile, "state-file", "", "path of the file to persist instances, bindings and asynchronous operations in; they are only kept in memory if empty")
+	flag.StringVar(&options.BrokersFile, "brokers-file", "", "path of a YAML file configuring several brokers to serve under their own path prefixes instead of the one the broker options configure")
+	flag.StringVar(&options.AdminToken, "admin-token", "", "bearer token that authenticates requests to the admin API under /admin; the admin API is disabled if empty")
+	flag.IntVar(&options.AdminPort, "admin-port", 0, "port to serve the admin API on instead of the broker's port")
+	flag.DurationVar(&options.ShutdownGracePeriod, "shutdown-grace-period", 25*time.Second, "how long to wait for in-flight requests and asynchronous operations to finish when shutting down")
+	flag.StringVar(&options.ConfigFile, "config", "", "path of a YAML file setting options by flag name; environment variables named OSB_<FLAG_NAME> and flags take precedence over it")
+	broker.AddFlags(flag.CommandLine, &options.Options)
 	flag.Parse()
+
+	config.MarkSecret("tlsKey", "admin-token")
+	var err error
+	effectiveConfig, err = config.Apply(flag.CommandLine, "OSB_", "config")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	options.KubernetesConfig = func() (*clientrest.Config, error) {
+		return getKubernetesConfig(options.KubeConfig)
+	}
 }
 
+// Exit codes of the broker. Any other error exits with glog's fatal status,
+// 255.
+const (
+	// exitOK means the broker shut down cleanly.
+	exitOK = 0
+	// exitIncompleteShutdown means the broker shut down before it finished
+	// serving requests or checkpointing asynchronous operations.
+	exitIncompleteShutdown = 1
+	// exitUsage means the broker was started with invalid options.
+	exitUsage = 2
+)
+
 func main() {
-	if err := run(); err != nil && err != context.Canceled && err != context.DeadlineExceeded {
-		glog.Fatalln(err)
+	logger, err := log.New(os.Stderr, options.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	log.SetDefault(logger)
+
+	err = run()
+	if e, ok := err.(incompleteShutdownError); ok {
+		log.Error(e.Error())
+		os.Exit(exitIncompleteShutdown)
 	}
+	if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+		log.Fatal(err.Error())
+	}
+	os.Exit(exitOK)
 }
 
 func run() error {
@@ -64,9 +144,18 @@ func run() error {
 }
 
 func runWithContext(ctx context.Context) error {
-	if flag.Arg(0) == "version" {
+	switch flag.Arg(0) {
+	case "version":
 		fmt.Printf("%s/%s\n", path.Base(os.Args[0]), "0.1.0")
 		return nil
+	case "audit":
+		return runAudit(flag.Args()[1:])
+	case "config":
+		return effectiveConfig.Print(os.Stdout)
+	case "conformance":
+		return runConformance(flag.Args()[1:])
+	case "state":
+		return runState(flag.Args()[1:])
 	}
 	if (options.TLSCert != "" || options.TLSKey != "") &&
 		(options.TLSCert == "" || options.TLSKey == "") {
@@ -76,95 +165,286 @@ func runWithContext(ctx context.Context) error {
 
 	addr := ":" + strconv.Itoa(options.Port)
 
-	businessLogic, err := broker.NewBusinessLogic(options.Options)
-	if err != nil {
-		return err
+	configs := []brokerConfig{{
+		StateFile: options.StateFile,
+		options:   options.Options,
+	}}
+	if options.BrokersFile != "" {
+		var err error
+		configs, err = loadBrokers(options.BrokersFile)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Prom. metrics
-	reg := prom.NewRegistry()
-	osbMetrics := metrics.New()
-	reg.MustRegister(osbMetrics)
+	s := &brokerServer{health: health.NewRegistry()}
+	if options.QuotaFile != "" {
+		q, err := quota.Load(options.QuotaFile)
+		if err != nil {
+			return err
+		}
+		s.quota = q
+	}
+	if options.PolicyFile != "" {
+		p, err := policy.Load(options.PolicyFile)
+		if err != nil {
+			return err
+		}
+		s.policy = p
+	}
+	if options.RateLimitFile != "" {
+		c, err := ratelimit.Load(options.RateLimitFile)
+		if err != nil {
+			return err
+		}
+		s.limiter = ratelimit.New(c)
+	}
+	if options.WebhooksFile != "" {
+		c, err := webhook.Load(options.WebhooksFile)
+		if err != nil {
+			return err
+		}
+		d, err := webhook.New(c)
+		if err != nil {
+			return err
+		}
+		d.Start()
+		defer d.Stop()
+		s.webhooks = d
+	}
+	if options.AuditLog != "" {
+		sink, err := audit.NewFileSink(options.AuditLog, int64(options.AuditLogMaxSize)*1024*1024, options.AuditLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+		s.sink = sink
+	}
 
-	api, err := rest.NewAPISurface(businessLogic, osbMetrics)
-	if err != nil {
-		return err
+	served := make([]brokers.Broker, 0, len(configs))
+	for _, c := range configs {
+		b, err := s.newBroker(c)
+		if err != nil {
+			return err
+		}
+		served = append(served, b)
 	}
 
-	s := server.New(api, reg)
+	// Prom. metrics
+	reg := prom.NewRegistry()
+	if s.limiter != nil {
+		reg.MustRegister(s.limiter)
+	}
+	if s.webhooks != nil {
+		reg.MustRegister(s.webhooks)
+	}
+	router := mux.NewRouter()
+	router.Handle(health.ReadyzPath, s.health.ReadyzHandler())
+	router.Handle(health.LivezPath, s.health.LivezHandler())
+	// Each broker serves /healthz under its prefix; serve it from the root too
+	// for probes of the process.
+	router.HandleFunc(health.HealthzPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	var metricsHandler http.Handler = promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
 	if options.AuthenticateK8SToken {
-		// get k8s client
-		k8sClient, err := getKubernetesClient(options.KubeConfig)
+		tr, err := s.tokenReviewMiddleware()
 		if err != nil {
 			return err
 		}
-		// Create a User Info Authorizer.
-		authz := middleware.SARUserInfoAuthorizer{
-			SAR: k8sClient.AuthorizationV1().SubjectAccessReviews(),
+		metricsHandler = tr(metricsHandler)
+	}
+	router.Handle("/metrics", metricsHandler)
+
+	servers := []*http.Server{{
+		Addr:    addr,
+		Handler: router,
+	}}
+	if options.AdminToken != "" {
+		adminHandler, err := s.adminHandler()
+		if err != nil {
+			return err
 		}
-		// create TokenReviewMiddleware
-		tr := middleware.TokenReviewMiddleware{
-			TokenReview: k8sClient.Authentication().TokenReviews(),
-			Authorizer:  authz,
+		if options.AdminPort != 0 {
+			servers = append(servers, &http.Server{
+				Addr:    ":" + strconv.Itoa(options.AdminPort),
+				Handler: adminHandler,
+			})
+		} else {
+			router.PathPrefix(admin.Path + "/").Handler(adminHandler)
 		}
-		// Use TokenReviewMiddleware.
-		s.Router.Use(tr.Middleware)
+	} else if options.AdminPort != 0 {
+		return fmt.Errorf("--admin-port requires --admin-token")
 	}
 
-	glog.Infof("Starting broker!")
+	if err := brokers.Mount(router, reg, served); err != nil {
+		return err
+	}
 
+	var listenAndServe func(srv *http.Server) error
 	if options.Insecure {
-		err = s.Run(ctx, addr)
+		listenAndServe = (*http.Server).ListenAndServe
 	} else {
 		if options.TLSCert != "" && options.TLSKey != "" {
-			glog.V(4).Infof("Starting secure broker with TLS cert and key data")
-			err = s.RunTLS(ctx, addr, options.TLSCert, options.TLSKey)
+			log.V(4).Info("Starting secure broker with TLS cert and key data")
+			cert, err := decodeKeyPair(options.TLSCert, options.TLSKey)
+			if err != nil {
+				return err
+			}
+			tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+			listenAndServe = func(srv *http.Server) error {
+				srv.TLSConfig = tlsConfig
+				return srv.ListenAndServeTLS("", "")
+			}
 		} else {
 			if options.TLSCertFile == "" || options.TLSKeyFile == "" {
-				glog.Error("unable to run securely without TLS Certificate and Key. Please review options and if running with TLS, specify --tls-cert-file and --tls-private-key-file or --tlsCert and --tlsKey.")
+				log.Error("unable to run securely without TLS Certificate and Key. Please review options and if running with TLS, specify --tls-cert-file and --tls-private-key-file or --tlsCert and --tlsKey.")
 				return nil
 			}
-			glog.V(4).Infof("Starting secure broker with file based TLS cert and key")
-			err = s.RunTLSWithTLSFiles(ctx, addr, options.TLSCertFile, options.TLSKeyFile)
+			log.V(4).Info("Starting secure broker with file based TLS cert and key")
+			listenAndServe = func(srv *http.Server) error {
+				return srv.ListenAndServeTLS(options.TLSCertFile, options.TLSKeyFile)
+			}
 		}
 	}
-	return err
+
+	log.With("addr", addr).Info("Starting broker!")
+	if len(servers) > 1 {
+		log.With("addr", servers[1].Addr).Info("Serving the admin API")
+	}
+
+	go reloadOnHangup(ctx, s)
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			errs <- listenAndServe(srv)
+		}(srv)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	return shutdown(servers, s.logics)
 }
 
-func getKubernetesClient(kubeConfigPath string) (clientset.Interface, error) {
-	var clientConfig *clientrest.Config
-	var err error
-	if kubeConfigPath == "" {
-		clientConfig, err = clientrest.InClusterConfig()
-		if err != nil {
-			return nil, err
+// shutdown stops the servers from accepting connections, waits for the
+// requests in flight to finish and then lets the business logics finish or
+// checkpoint their asynchronous operations, all within the shutdown grace
+// period. It returns an incompleteShutdownError if the grace period ran out
+// first.
+func shutdown(servers []*http.Server, businessLogics []*broker.BusinessLogic) error {
+	log.With("grace_period", options.ShutdownGracePeriod).Info("Shutting down broker")
+	ctx, cancel := context.WithTimeout(context.Background(), options.ShutdownGracePeriod)
+	defer cancel()
+
+	var incomplete []string
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			incomplete = append(incomplete, fmt.Sprintf("unable to drain HTTP requests: %v", err))
 		}
-	} else {
-		config, err := clientcmd.LoadFromFile(kubeConfigPath)
-		if err != nil {
-			return nil, err
+	}
+	for _, businessLogic := range businessLogics {
+		if err := businessLogic.Shutdown(ctx); err != nil {
+			incomplete = append(incomplete, err.Error())
 		}
+	}
 
-		clientConfig, err = clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
-		if err != nil {
-			return nil, err
-		}
+	if len(incomplete) > 0 {
+		return incompleteShutdownError(strings.Join(incomplete, "; "))
+	}
+	log.Info("Broker shut down")
+	return nil
+}
+
+// incompleteShutdownError is returned when the broker could not finish its
+// work within the shutdown grace period.
+type incompleteShutdownError string
+
+func (e incompleteShutdownError) Error() string {
+	return "shutdown grace period exceeded: " + string(e)
+}
+
+// decodeKeyPair decodes a base-64 encoded PEM certificate and private key.
+func decodeKeyPair(cert, key string) (tls.Certificate, error) {
+	decodedCert, err := base64.StdEncoding.DecodeString(cert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(decodedCert, decodedKey)
+}
+
+func getKubernetesClient(kubeConfigPath string) (clientset.Interface, error) {
+	clientConfig, err := getKubernetesConfig(kubeConfigPath)
+	if err != nil {
+		return nil, err
 	}
 	return clientset.NewForConfig(clientConfig)
 }
 
-func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
-	term := make(chan os.Signal)
-	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+// getKubernetesConfig returns the client configuration from the kube config
+// file at kubeConfigPath, or the in-cluster configuration if it is empty.
+func getKubernetesConfig(kubeConfigPath string) (*clientrest.Config, error) {
+	if kubeConfigPath == "" {
+		return clientrest.InClusterConfig()
+	}
+
+	config, err := clientcmd.LoadFromFile(kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// reloadOnHangup reloads the catalogs of the brokers s serves whenever the
+// process receives SIGHUP, until ctx is done.
+func reloadOnHangup(ctx context.Context, s *brokerServer) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 
 	for {
 		select {
-		case <-term:
-			glog.Infof("Received SIGTERM, exiting gracefully...")
-			f()
-			os.Exit(0)
+		case <-hup:
 		case <-ctx.Done():
-			os.Exit(0)
+			return
+		}
+		log.Info("Received SIGHUP, reloading the catalog")
+		for i, businessLogic := range s.logics {
+			if err := businessLogic.ReloadCatalog(); err != nil {
+				logger := log.With("error", err)
+				if s.names[i] != "" {
+					logger = logger.With("broker", s.names[i])
+				}
+				logger.Error("unable to reload the catalog")
+			}
 		}
 	}
 }
+
+// cancelOnInterrupt cancels ctx when the process receives SIGTERM or an
+// interrupt, starting a graceful shutdown. A second signal exits immediately.
+func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
+	term := make(chan os.Signal, 2)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-term:
+		log.Info("Received SIGTERM, exiting gracefully...")
+		f()
+	case <-ctx.Done():
+		return
+	}
+
+	<-term
+	log.Error("Received second signal, exiting immediately")
+	os.Exit(exitIncompleteShutdown)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// runState implements the 'state' subcommand, which exports the instances,
+// bindings and operations a broker has recorded and imports them into the
+// same or another broker. Brokers do not reread their state while they run,
+// so importing into the state file of a running broker fails.
+func runState(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: state export|import [flags]")
+	}
+	switch args[0] {
+	case "export":
+		return runStateExport(args[1:])
+	case "import":
+		return runStateImport(args[1:])
+	}
+	return fmt.Errorf("unknown state command %q: must be 'export' or 'import'", args[0])
+}
+
+// runStateExport implements 'state export', which writes the broker's records
+// as versioned JSON.
+func runStateExport(args []string) error {
+	fs := flag.NewFlagSet("state export", flag.ContinueOnError)
+	output := fs.String("output", "", "path of the file to write the export to; it is written to standard output if empty")
+	name := fs.String("broker", "", "name of the broker in --brokers-file to export")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stateFile, err := configuredStateFile(*name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		return err
+	}
+	// Exporting only reads the file, so it does not need the lock the
+	// broker holds on it.
+	store, err := state.LoadFile(stateFile)
+	if err != nil {
+		return err
+	}
+	e, err := state.Dump(store)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	// The export holds the credentials of bindings.
+	if err := ioutil.WriteFile(*output, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d instances and %d bindings to %s\n", len(e.Instances), len(e.Bindings), *output)
+	return nil
+}
+
+// runStateImport implements 'state import', which checks an export and writes
+// its records to the broker's store.
+func runStateImport(args []string) error {
+	fs := flag.NewFlagSet("state import", flag.ContinueOnError)
+	name := fs.String("broker", "", "name of the broker in --brokers-file to import into")
+	replace := fs.Bool("replace", false, "delete the instances and bindings the export does not have")
+	dryRun := fs.Bool("dry-run", false, "only print the changes the import would make")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: state import [flags] <file>")
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	e := &state.Export{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return fmt.Errorf("unable to parse export %q: %v", fs.Arg(0), err)
+	}
+	if err := e.Check(); err != nil {
+		return fmt.Errorf("export %q is invalid: %v", fs.Arg(0), err)
+	}
+
+	stateFile, err := configuredStateFile(*name)
+	if err != nil {
+		return err
+	}
+	store, err := state.NewFile(stateFile)
+	if err == state.ErrLocked {
+		return fmt.Errorf("unable to import into %q: %v; stop the broker first", stateFile, err)
+	} else if err != nil {
+		return err
+	}
+	changes, err := state.Import(store, e, *replace, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, c := range changes {
+		fmt.Println(c)
+		counts[c.Action]++
+	}
+	verb := "Imported"
+	if *dryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%s: %d to create, %d to update, %d to delete\n", verb, counts["create"], counts["update"], counts["delete"])
+	return nil
+}
+
+// configuredStateFile returns the state file of the broker with the given
+// name in --brokers-file, or of the broker --state-file configures if name is
+// empty. A broker that keeps its state in memory is refused, since its state
+// cannot be read from another process.
+func configuredStateFile(name string) (string, error) {
+	stateFile := options.StateFile
+	switch {
+	case name != "" && options.BrokersFile == "":
+		return "", fmt.Errorf("--broker requires --brokers-file")
+	case name == "" && options.BrokersFile != "":
+		return "", fmt.Errorf("--broker must name one of the brokers in --brokers-file")
+	case name != "":
+		configs, err := loadBrokers(options.BrokersFile)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for _, c := range configs {
+			if c.Name == name {
+				stateFile, found = c.StateFile, true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("brokers file %q has no broker named %q", options.BrokersFile, name)
+		}
+	}
+	if stateFile == "" {
+		return "", fmt.Errorf("the broker keeps its state in memory; configure a state file to export or import it")
+	}
+	return stateFile, nil
+}
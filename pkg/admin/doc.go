@@ -0,0 +1,21 @@
+// Package admin serves an API for operators to inspect and repair what a
+// broker has recorded in its state store. It is served under /admin, apart
+// from the OSB API and with its own authentication, and offers:
+//
+//	GET    /admin/instances                          list instances
+//	GET    /admin/instances/{id}                     show an instance, its bindings and its history
+//	DELETE /admin/instances/{id}                     force-delete an instance and its bindings
+//	POST   /admin/instances/{id}/retry               retry the failed last operation of an instance
+//	DELETE /admin/instances/{id}/bindings/{binding}  force-delete a binding
+//	GET    /admin/bindings                           list bindings
+//	GET    /admin/operations                         list operations
+//
+// Lists are filtered by query parameters, for example
+// /admin/instances?state=failed or /admin/operations?type=deprovision&state=in
+// progress. Force-deleting a record only forgets it; the resources the
+// broker created for it are left for the operator to remove. When a process
+// serves several brokers, the paths of each are under /admin/brokers/{name}.
+//
+// Parameters are shown with their sensitive values redacted, as in the audit
+// trail, and the credentials of bindings are never shown.
+package admin // import "github.com/pmorie/osb-starter-pack/pkg/admin"
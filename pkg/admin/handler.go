@@ -0,0 +1,386 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/audit"
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+	"github.com/pmorie/osb-starter-pack/pkg/quota"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Path is the path the admin API is served under.
+const Path = "/admin"
+
+// Backend is the broker whose state the admin API serves.
+type Backend interface {
+	// Store returns the store the broker keeps its instances and bindings
+	// in.
+	Store() state.Store
+	// Running reports whether an operation on the instance with the given
+	// ID is running.
+	Running(instanceID string) bool
+	// ForceDeleteInstance deletes the broker's record of an instance and
+	// its bindings, without deleting their resources.
+	ForceDeleteInstance(instanceID string) error
+	// ForceDeleteBinding deletes the broker's record of a binding, without
+	// deleting its credentials.
+	ForceDeleteBinding(instanceID, bindingID string) error
+	// RetryOperation starts the failed last operation of an instance again
+	// and returns the new operation.
+	RetryOperation(instanceID string) (*state.Operation, error)
+}
+
+// Broker is one of the brokers the admin API serves.
+type Broker struct {
+	// Name is the name of the broker, which only a broker served on its own
+	// may leave empty.
+	Name    string
+	Backend Backend
+	// AuditTrail, if set, returns the records of the audit trail f
+	// selects, which are shown with the instances they act on.
+	AuditTrail func(f *audit.Filter) ([]*audit.Record, error)
+}
+
+// Instance is an instance as the admin API shows it.
+type Instance struct {
+	*state.Instance
+	// Running is set while an operation on the instance is running.
+	Running bool `json:"running"`
+}
+
+// InstanceDetail is an instance with its bindings and, if the broker keeps
+// an audit trail, the requests that acted on it.
+type InstanceDetail struct {
+	Instance
+	Bindings []*state.Binding `json:"bindings"`
+	Audit    []*audit.Record  `json:"audit,omitempty"`
+}
+
+// Operation is an operation on an instance as the admin API shows it.
+type Operation struct {
+	InstanceID string `json:"instance_id"`
+	*state.Operation
+}
+
+// validName matches the names of brokers the admin API can serve.
+var validName = regexp.MustCompile(`^[A-Za-z0-9._~-]+$`)
+
+// NewHandler returns the handler of the admin API for brokers, which serves
+// paths under Path. A broker without a name must be the only one.
+func NewHandler(brokers []Broker) (http.Handler, error) {
+	router := mux.NewRouter()
+	for _, b := range brokers {
+		prefix := Path
+		switch {
+		case b.Name == "" && len(brokers) > 1:
+			return nil, fmt.Errorf("brokers served with other brokers must have a name")
+		case b.Name != "" && !validName.MatchString(b.Name):
+			return nil, fmt.Errorf("broker name %q cannot be used in a path", b.Name)
+		case b.Name != "":
+			prefix = Path + "/brokers/" + b.Name
+		}
+		h := &handler{broker: b}
+		r := router.PathPrefix(prefix).Subrouter()
+		r.HandleFunc("/instances", h.listInstances).Methods(http.MethodGet)
+		r.HandleFunc("/instances/{instance_id}", h.getInstance).Methods(http.MethodGet)
+		r.HandleFunc("/instances/{instance_id}", h.deleteInstance).Methods(http.MethodDelete)
+		r.HandleFunc("/instances/{instance_id}/retry", h.retry).Methods(http.MethodPost)
+		r.HandleFunc("/instances/{instance_id}/bindings/{binding_id}", h.deleteBinding).Methods(http.MethodDelete)
+		r.HandleFunc("/bindings", h.listBindings).Methods(http.MethodGet)
+		r.HandleFunc("/operations", h.listOperations).Methods(http.MethodGet)
+	}
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "No such admin endpoint")
+	})
+	return router, nil
+}
+
+// TokenAuth returns middleware that requires requests to present token as a
+// bearer token.
+func TokenAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="service broker admin"`)
+				writeError(w, http.StatusUnauthorized, "A valid admin token is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type handler struct {
+	broker Broker
+}
+
+// listInstances serves the instances selected by the service_id, plan_id,
+// state, user, namespace and organization query parameters.
+func (h *handler) listInstances(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var instances []*state.Instance
+	err := h.broker.Backend.Store().View(func(tx state.Tx) error {
+		var err error
+		instances, err = tx.ListInstances()
+		return err
+	})
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+
+	selected := []*Instance{}
+	for _, i := range instances {
+		org, _ := quota.OrganizationAndSpace(i)
+		switch {
+		case !matches(q, "service_id", i.ServiceID),
+			!matches(q, "plan_id", i.PlanID),
+			!matches(q, "state", string(i.State)),
+			!matches(q, "user", user(i.Owner)),
+			!matches(q, "namespace", quota.Namespace(i)),
+			!matches(q, "organization", org):
+			continue
+		}
+		selected = append(selected, h.instance(i))
+	}
+	writeJSON(w, http.StatusOK, selected)
+}
+
+// getInstance serves an instance with its bindings and history.
+func (h *handler) getInstance(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["instance_id"]
+	var detail *InstanceDetail
+	err := h.broker.Backend.Store().View(func(tx state.Tx) error {
+		i, err := tx.GetInstance(id)
+		if err != nil {
+			return err
+		}
+		bindings, err := tx.ListBindings(id)
+		if err != nil {
+			return err
+		}
+		detail = &InstanceDetail{
+			Instance: *h.instance(i),
+			Bindings: redactBindings(bindings),
+		}
+		return nil
+	})
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+
+	if h.broker.AuditTrail != nil {
+		records, err := h.broker.AuditTrail(&audit.Filter{InstanceID: id})
+		if err != nil {
+			h.fail(w, fmt.Errorf("unable to read the audit trail: %v", err))
+			return
+		}
+		detail.Audit = records
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// deleteInstance force-deletes an instance.
+func (h *handler) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["instance_id"]
+	if err := h.broker.Backend.ForceDeleteInstance(id); err != nil {
+		h.fail(w, err)
+		return
+	}
+	h.logger(r).With("instance_id", id).Info("force-deleted instance")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteBinding force-deletes a binding.
+func (h *handler) deleteBinding(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.broker.Backend.ForceDeleteBinding(vars["instance_id"], vars["binding_id"]); err != nil {
+		h.fail(w, err)
+		return
+	}
+	h.logger(r).With("instance_id", vars["instance_id"], "binding_id", vars["binding_id"]).Info("force-deleted binding")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// retry retries the failed last operation of an instance and serves the new
+// operation.
+func (h *handler) retry(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["instance_id"]
+	op, err := h.broker.Backend.RetryOperation(id)
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	h.logger(r).With("instance_id", id, "operation", op.Key).Info("retrying operation")
+	writeJSON(w, http.StatusAccepted, &Operation{InstanceID: id, Operation: op})
+}
+
+// listBindings serves the bindings selected by the instance_id, service_id,
+// plan_id and user query parameters.
+func (h *handler) listBindings(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	selected := []*state.Binding{}
+	err := h.broker.Backend.Store().View(func(tx state.Tx) error {
+		instances, err := tx.ListInstances()
+		if err != nil {
+			return err
+		}
+		for _, i := range instances {
+			if !matches(q, "instance_id", i.ID) {
+				continue
+			}
+			bindings, err := tx.ListBindings(i.ID)
+			if err != nil {
+				return err
+			}
+			for _, b := range bindings {
+				if matches(q, "service_id", b.ServiceID) && matches(q, "plan_id", b.PlanID) && matches(q, "user", user(b.Owner)) {
+					selected = append(selected, b)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, redactBindings(selected))
+}
+
+// listOperations serves the operations selected by the instance_id, type and
+// state query parameters, oldest first. Operations are kept with the history
+// of their instance, so those of deleted instances are gone.
+func (h *handler) listOperations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var instances []*state.Instance
+	err := h.broker.Backend.Store().View(func(tx state.Tx) error {
+		var err error
+		instances, err = tx.ListInstances()
+		return err
+	})
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+
+	selected := []*Operation{}
+	for _, i := range instances {
+		if !matches(q, "instance_id", i.ID) {
+			continue
+		}
+		ops := i.History
+		if i.LastOperation != nil {
+			ops = append(ops[:len(ops):len(ops)], i.LastOperation)
+		}
+		for _, op := range ops {
+			if matches(q, "type", op.Type) && matches(q, "state", string(op.State)) {
+				selected = append(selected, &Operation{InstanceID: i.ID, Operation: op})
+			}
+		}
+	}
+	sort.SliceStable(selected, func(a, b int) bool {
+		return selected[a].Started.Before(selected[b].Started)
+	})
+	writeJSON(w, http.StatusOK, selected)
+}
+
+// instance returns how the admin API shows i, whose parameters it redacts.
+func (h *handler) instance(i *state.Instance) *Instance {
+	i.Parameters = audit.Redact(i.Parameters)
+	return &Instance{
+		Instance: i,
+		Running:  h.broker.Backend.Running(i.ID),
+	}
+}
+
+// logger returns the logger for the admin request r.
+func (h *handler) logger(r *http.Request) *log.Logger {
+	l := log.With("remote_addr", r.RemoteAddr)
+	if h.broker.Name != "" {
+		l = l.With("broker", h.broker.Name)
+	}
+	return l
+}
+
+// fail writes the response for err.
+func (h *handler) fail(w http.ResponseWriter, err error) {
+	if e, ok := err.(osb.HTTPStatusCodeError); ok {
+		description := http.StatusText(e.StatusCode)
+		if e.Description != nil {
+			description = *e.Description
+		}
+		writeError(w, e.StatusCode, description)
+		return
+	}
+	if err == state.ErrNotFound {
+		writeError(w, http.StatusNotFound, "No such record")
+		return
+	}
+	log.With("error", err).Error("admin request failed")
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+// redactBindings returns bindings without their credentials and with their
+// parameters redacted.
+func redactBindings(bindings []*state.Binding) []*state.Binding {
+	for _, b := range bindings {
+		b.Parameters = audit.Redact(b.Parameters)
+		b.Credentials = nil
+	}
+	return bindings
+}
+
+// matches reports whether value is selected by the query parameter name,
+// which selects every value if it is not set.
+func matches(q map[string][]string, name, value string) bool {
+	want, ok := q[name]
+	if !ok {
+		return true
+	}
+	for _, w := range want {
+		if w == value {
+			return true
+		}
+	}
+	return false
+}
+
+// user returns the user of an originating identity, or "" if there is none.
+func user(o *osb.OriginatingIdentity) string {
+	if identity := audit.NewIdentity(o); identity != nil {
+		return identity.User
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	body, _ := json.Marshal(map[string]string{"description": description})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,439 @@
+// Package async runs the broker's asynchronous operations in the background
+// and records their progress on the instances they act on, where the broker's
+// last operation endpoint reads it. When the broker shuts down, the Engine
+// waits for running operations to finish and checkpoints the ones that do not
+// finish in time so they can be resumed when the broker starts again. A
+// Reaper cleans up after the provisions and deprovisions that fail.
+package async // import "github.com/pmorie/osb-starter-pack/pkg/async"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// ErrStopped is returned by Run once the Engine has been shut down.
+var ErrStopped = errors.New("the broker is shutting down")
+
+// ErrRunning is returned by Claim, Run and RunSync when another operation on
+// the instance is running.
+var ErrRunning = errors.New("another operation on the instance is in progress")
+
+// Func is the work of an asynchronous operation. It must return promptly once
+// ctx is done, which happens when the broker shuts down before the work
+// finishes.
+type Func func(ctx context.Context) error
+
+// Engine runs asynchronous operations.
+type Engine struct {
+	// StuckAfter is how long an operation may keep running past its
+	// deadline, when its Func should have returned, before Watchdog reports
+	// the Engine as stuck.
+	StuckAfter time.Duration
+
+	store state.Store
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped bool
+	running map[string]string
+	// deadlines holds the deadline of each running operation that has one,
+	// by instance ID.
+	deadlines map[string]time.Time
+}
+
+// NewEngine returns an Engine that records operations in store.
+func NewEngine(store state.Store) *Engine {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Engine{
+		StuckAfter: time.Minute,
+		store:      store,
+		ctx:        ctx,
+		cancel:     cancel,
+		running:    map[string]string{},
+		deadlines:  map[string]time.Time{},
+	}
+}
+
+// NewOperation returns a new in-progress operation of the given type, to be
+// stored on an instance and then passed to Run.
+func NewOperation(opType string) *state.Operation {
+	now := time.Now()
+	return &state.Operation{
+		Key:     opType + "-" + strconv.FormatInt(now.UnixNano(), 36),
+		Type:    opType,
+		State:   osb.StateInProgress,
+		Started: now,
+	}
+}
+
+// Claim claims the instance with the given ID for the operation with the
+// given key, which is then started with Run or RunSync. Claim the instance in
+// the store transaction that stores the operation, so that no other operation
+// can be started on it between the transaction and Run, and Release it if the
+// transaction fails. Claiming an instance again for the same operation does
+// nothing.
+func (e *Engine) Claim(instanceID, key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.claim(instanceID, key)
+}
+
+// Release releases the claim of the operation with the given key on the
+// instance with the given ID, for an operation that is not run after all.
+func (e *Engine) Release(instanceID, key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unclaim(instanceID, key)
+}
+
+// Run runs fn in the background as the operation op on the instance with the
+// given ID, which must already be stored with op as its last operation. It
+// fails if another operation has claimed the instance. When fn returns, the
+// operation is marked succeeded or failed. If fn deletes the instance, as a
+// deprovision does, there is nothing left to mark. If op has a deadline, fn's
+// context is cancelled then and the operation fails.
+func (e *Engine) Run(instanceID string, op *state.Operation, fn Func) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stopped {
+		// The operation resumes when the broker restarts.
+		e.unclaim(instanceID, op.Key)
+		return ErrStopped
+	}
+	if err := e.claim(instanceID, op.Key); err != nil {
+		return err
+	}
+	e.watch(instanceID, op)
+
+	go func() {
+		defer e.wg.Done()
+
+		l := log.With("instance_id", instanceID, "operation", op.Key)
+		err := call(e.ctx, op, fn)
+		if err != nil && e.ctx.Err() != nil {
+			l.Info("checkpointing operation interrupted by shutdown")
+			e.finish(instanceID, op.Key, func(i *state.Instance, o *state.Operation) {
+				o.Interrupted = true
+				o.Description = "Interrupted by broker shutdown; the operation will resume when the broker restarts"
+			})
+			return
+		}
+
+		e.complete(instanceID, op, err)
+	}()
+	return nil
+}
+
+// RunSync runs fn as the operation op, like Run, but in the calling goroutine
+// and with the given context, for requests that do not accept asynchronous
+// operations. It returns the error fn returns.
+func (e *Engine) RunSync(ctx context.Context, instanceID string, op *state.Operation, fn Func) error {
+	e.mu.Lock()
+	if e.stopped {
+		e.unclaim(instanceID, op.Key)
+		e.mu.Unlock()
+		return ErrStopped
+	}
+	if err := e.claim(instanceID, op.Key); err != nil {
+		e.mu.Unlock()
+		return err
+	}
+	e.watch(instanceID, op)
+	e.mu.Unlock()
+	defer e.wg.Done()
+
+	err := call(ctx, op, fn)
+	e.complete(instanceID, op, err)
+	return err
+}
+
+// call calls fn with ctx, cancelled at the deadline of op if it has one.
+func call(ctx context.Context, op *state.Operation, fn Func) error {
+	if op.Deadline == nil {
+		return fn(ctx)
+	}
+	overdue := fmt.Errorf("the operation did not finish within the plan's maximum polling duration of %s", op.Deadline.Sub(op.Started))
+	if !time.Now().Before(*op.Deadline) {
+		// The broker was down until after the deadline.
+		return overdue
+	}
+	ctx, cancel := context.WithDeadline(ctx, *op.Deadline)
+	defer cancel()
+
+	err := fn(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && !time.Now().Before(*op.Deadline) {
+		return overdue
+	}
+	return err
+}
+
+// complete records that the operation op finished with the error err.
+func (e *Engine) complete(instanceID string, op *state.Operation, err error) {
+	l := log.With("instance_id", instanceID, "operation", op.Key)
+	if err != nil {
+		l.With("error", err).Error("operation failed")
+	} else {
+		l.V(4).Info("operation succeeded")
+	}
+	e.finish(instanceID, op.Key, func(i *state.Instance, o *state.Operation) {
+		now := time.Now()
+		o.Finished = &now
+		if err != nil {
+			o.State = osb.StateFailed
+			o.Description = describe(err)
+		} else {
+			o.State = osb.StateSucceeded
+			o.Description = ""
+		}
+		settle(i, o)
+	})
+}
+
+// describe returns the description of a failed operation's error err: the
+// description of an osb.HTTPStatusCodeError, which the platform cannot get
+// once the operation is asynchronous, or else the error's message.
+func describe(err error) string {
+	if e, ok := err.(osb.HTTPStatusCodeError); ok && e.Description != nil {
+		return *e.Description
+	}
+	return err.Error()
+}
+
+// settle moves an instance to the state the outcome of its operation op
+// leaves it in. A successful update moves it to its new plan and maintenance
+// version. A failed provision or deprovision may have left resources behind,
+// so it fails the instance and starts its cleanup afresh.
+func settle(i *state.Instance, op *state.Operation) {
+	if op.Type == "update" && op.State == osb.StateSucceeded {
+		i.Apply(op)
+	}
+	if op.Type != "provision" && op.Type != "deprovision" {
+		return
+	}
+	switch op.State {
+	case osb.StateFailed:
+		i.State = state.InstanceFailed
+		i.Cleanup = nil
+	case osb.StateSucceeded:
+		if op.Type == "provision" {
+			i.State = state.InstanceReady
+		}
+	}
+}
+
+// finish applies update to the operation with the given key and its
+// instance, if it is still the last operation of the instance.
+func (e *Engine) finish(instanceID, key string, update func(i *state.Instance, o *state.Operation)) {
+	e.release(instanceID, key)
+
+	err := e.store.Update(func(tx state.Tx) error {
+		instance, err := tx.GetInstance(instanceID)
+		if err != nil {
+			return err
+		}
+		if instance.LastOperation == nil || instance.LastOperation.Key != key {
+			return nil
+		}
+
+		op := *instance.LastOperation
+		update(instance, &op)
+		instance.LastOperation = &op
+		return tx.PutInstance(instance)
+	})
+	if err != nil && err != state.ErrNotFound {
+		log.With("instance_id", instanceID, "operation", key, "error", err).Error("unable to record the outcome of operation")
+	}
+}
+
+// claim marks the instance with the given ID as having the operation with
+// the given key running, unless it already has. It fails if the instance has
+// another operation running or the Engine has been shut down. It must be
+// called with e.mu held.
+func (e *Engine) claim(instanceID, key string) error {
+	if running, ok := e.running[instanceID]; ok {
+		if running == key {
+			return nil
+		}
+		return ErrRunning
+	}
+	if e.stopped {
+		return ErrStopped
+	}
+	e.running[instanceID] = key
+	e.wg.Add(1)
+	return nil
+}
+
+// watch records the deadline of the operation op on the instance with the
+// given ID, which has just been claimed for it, for Watchdog. It must be
+// called with e.mu held.
+func (e *Engine) watch(instanceID string, op *state.Operation) {
+	if op.Deadline != nil {
+		e.deadlines[instanceID] = *op.Deadline
+	}
+}
+
+// unclaim undoes claim. It must be called with e.mu held.
+func (e *Engine) unclaim(instanceID, key string) {
+	if e.running[instanceID] == key {
+		delete(e.running, instanceID)
+		delete(e.deadlines, instanceID)
+		e.wg.Done()
+	}
+}
+
+// release marks the operation with the given key as no longer running on
+// the instance with the given ID, once it has finished.
+func (e *Engine) release(instanceID, key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running[instanceID] == key {
+		delete(e.running, instanceID)
+		delete(e.deadlines, instanceID)
+	}
+}
+
+// Running reports whether an operation on the instance with the given ID is
+// running.
+func (e *Engine) Running(instanceID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.running[instanceID]
+	return ok
+}
+
+// Resume runs the operations left in progress when the broker last stopped.
+// resume is called with each instance whose last operation is in progress and
+// returns the work that completes it.
+func (e *Engine) Resume(resume func(i *state.Instance) Func) error {
+	var instances []*state.Instance
+	err := e.store.Update(func(tx state.Tx) error {
+		all, err := tx.ListInstances()
+		if err != nil {
+			return err
+		}
+		for _, i := range all {
+			if i.State == "" {
+				// The instance was stored before instances had states.
+				i.State = initialState(i)
+				if err := tx.PutInstance(i); err != nil {
+					return err
+				}
+			}
+			if i.LastOperation == nil || i.LastOperation.State != osb.StateInProgress {
+				continue
+			}
+			op := *i.LastOperation
+			op.Interrupted = false
+			op.Description = ""
+			i.LastOperation = &op
+			if err := tx.PutInstance(i); err != nil {
+				return err
+			}
+			instances = append(instances, i)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, i := range instances {
+		log.With("instance_id", i.ID, "operation", i.LastOperation.Key).Info("resuming operation")
+		if err := e.Run(i.ID, i.LastOperation, resume(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initialState returns the state of an instance stored without one, as its
+// last operation left it.
+func initialState(i *state.Instance) state.InstanceState {
+	op := i.LastOperation
+	if op == nil || (op.Type != "provision" && op.Type != "deprovision") {
+		return state.InstanceReady
+	}
+	switch op.State {
+	case osb.StateInProgress:
+		if op.Type == "provision" {
+			return state.InstanceCreating
+		}
+		return state.InstanceDeleting
+	case osb.StateFailed:
+		return state.InstanceFailed
+	}
+	return state.InstanceReady
+}
+
+// Shutdown stops the Engine from running new operations and waits for running
+// ones to finish. If ctx is done first, running operations are cancelled and
+// checkpointed as interrupted, and Shutdown returns an error once they have
+// been.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	e.stopped = true
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		e.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	e.mu.Lock()
+	interrupted := len(e.running)
+	e.mu.Unlock()
+
+	e.cancel()
+	<-done
+	return fmt.Errorf("%d asynchronous operations were interrupted and will resume on restart", interrupted)
+}
+
+// Check fails once the Engine has been shut down; use it as a readiness check.
+func (e *Engine) Check(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return ErrStopped
+	}
+	return nil
+}
+
+// Watchdog fails if an operation is still running StuckAfter past its
+// deadline, which means its Func ignored the cancellation of its context and
+// holds the instance for good. Use it as a liveness check: after a restart,
+// the operation is resumed past its deadline and fails at once, which frees
+// the instance. Watchdog also blocks, and so fails the check by timing out, if
+// the Engine is deadlocked.
+func (e *Engine) Watchdog(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	for instanceID, deadline := range e.deadlines {
+		if now.Sub(deadline) > e.StuckAfter {
+			return fmt.Errorf("the operation %s on instance %s is still running %s after its deadline", e.running[instanceID], instanceID, now.Sub(deadline)/time.Second*time.Second)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// startOperation stores an instance with a new operation of the given type
+// as its last operation.
+func startOperation(t *testing.T, store state.Store, instanceID, opType string) *state.Operation {
+	op := NewOperation(opType)
+	err := store.Update(func(tx state.Tx) error {
+		return tx.PutInstance(&state.Instance{
+			ID:            instanceID,
+			State:         state.InstanceReady,
+			LastOperation: op,
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func lastOperation(t *testing.T, store state.Store, instanceID string) *state.Operation {
+	var op *state.Operation
+	err := store.View(func(tx state.Tx) error {
+		i, err := tx.GetInstance(instanceID)
+		if err != nil {
+			return err
+		}
+		op = i.LastOperation
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+// waitForState waits for the last operation of an instance to reach s.
+func waitForState(t *testing.T, store state.Store, instanceID string, s osb.LastOperationState) *state.Operation {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		op := lastOperation(t, store, instanceID)
+		if op.State == s {
+			return op
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("operation is %s, want %s", op.State, s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func shutdown(t *testing.T, e *Engine) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	store := state.NewMemory()
+	e := NewEngine(store)
+	defer shutdown(t, e)
+
+	op := startOperation(t, store, "ok", "provision")
+	if err := e.Run("ok", op, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, store, "ok", osb.StateSucceeded)
+
+	op = startOperation(t, store, "failing", "provision")
+	if err := e.Run("failing", op, func(ctx context.Context) error { return errors.New("no capacity") }); err != nil {
+		t.Fatal(err)
+	}
+	if op := waitForState(t, store, "failing", osb.StateFailed); op.Description != "no capacity" {
+		t.Errorf("got description %q, want the error", op.Description)
+	}
+
+	if err := e.RunSync(context.Background(), "ok", startOperation(t, store, "ok", "update"), func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if op := lastOperation(t, store, "ok"); op.State != osb.StateSucceeded {
+		t.Errorf("synchronous operation is %s, want succeeded", op.State)
+	}
+	if e.Running("ok") || e.Running("failing") {
+		t.Error("finished operations are still running")
+	}
+}
+
+func TestClaim(t *testing.T) {
+	store := state.NewMemory()
+	e := NewEngine(store)
+	defer shutdown(t, e)
+
+	if err := e.Claim("instance", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Claim("instance", "first"); err != nil {
+		t.Errorf("claiming an instance again for the same operation returned %v", err)
+	}
+	if err := e.Claim("instance", "second"); err != ErrRunning {
+		t.Errorf("claiming a claimed instance returned %v, want ErrRunning", err)
+	}
+	op := startOperation(t, store, "instance", "update")
+	if err := e.Run("instance", op, func(ctx context.Context) error { return nil }); err != ErrRunning {
+		t.Errorf("running an operation on a claimed instance returned %v, want ErrRunning", err)
+	}
+	if err := e.Claim("other", "second"); err != nil {
+		t.Errorf("claiming another instance returned %v", err)
+	}
+	e.Release("other", "second")
+
+	// Releasing the claim of another operation does nothing.
+	e.Release("instance", "second")
+	if !e.Running("instance") {
+		t.Fatal("releasing another operation's claim released the instance")
+	}
+	e.Release("instance", "first")
+
+	// Run takes over the claim of its own operation.
+	if err := e.Claim("instance", op.Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Run("instance", op, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("running a claimed operation returned %v", err)
+	}
+	waitForState(t, store, "instance", osb.StateSucceeded)
+}
+
+func TestConcurrentClaims(t *testing.T) {
+	e := NewEngine(state.NewMemory())
+	defer shutdown(t, e)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var claimed []string
+	for n := 0; n < 50; n++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if err := e.Claim("instance", key); err == nil {
+				mu.Lock()
+				claimed = append(claimed, key)
+				mu.Unlock()
+			}
+		}(strconv.Itoa(n))
+	}
+	wg.Wait()
+	if len(claimed) != 1 {
+		t.Fatalf("operations %v claimed the instance, want 1", claimed)
+	}
+	e.Release("instance", claimed[0])
+}
+
+func TestRunAfterShutdown(t *testing.T) {
+	store := state.NewMemory()
+	e := NewEngine(store)
+	if err := e.Claim("claimed", "op"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shutdown waits for the claimed operation, which is not run after all.
+	done := make(chan error)
+	go func() {
+		done <- e.Shutdown(context.Background())
+	}()
+	for e.Check(context.Background()) == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	noop := func(ctx context.Context) error { return nil }
+	op := startOperation(t, store, "claimed", "provision")
+	op.Key = "op"
+	if err := e.Run("claimed", op, noop); err != ErrStopped {
+		t.Errorf("Run after Shutdown returned %v, want ErrStopped", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+
+	if err := e.Run("other", startOperation(t, store, "other", "provision"), noop); err != ErrStopped {
+		t.Errorf("Run after Shutdown returned %v, want ErrStopped", err)
+	}
+	if err := e.RunSync(context.Background(), "other", startOperation(t, store, "other", "provision"), noop); err != ErrStopped {
+		t.Errorf("RunSync after Shutdown returned %v, want ErrStopped", err)
+	}
+	if err := e.Claim("other", "op"); err != ErrStopped {
+		t.Errorf("Claim after Shutdown returned %v, want ErrStopped", err)
+	}
+}
+
+func TestShutdownCheckpoints(t *testing.T) {
+	store := state.NewMemory()
+	e := NewEngine(store)
+
+	op := startOperation(t, store, "instance", "provision")
+	started := make(chan struct{})
+	err := e.Run("instance", op, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.Shutdown(ctx); err == nil {
+		t.Error("Shutdown succeeded although an operation was interrupted")
+	}
+	if op := lastOperation(t, store, "instance"); op.State != osb.StateInProgress || !op.Interrupted {
+		t.Fatalf("interrupted operation is %s, interrupted %v; want in progress and interrupted", op.State, op.Interrupted)
+	}
+
+	// A new Engine resumes it.
+	resumed := NewEngine(store)
+	defer shutdown(t, resumed)
+	err = resumed.Resume(func(i *state.Instance) Func {
+		return func(ctx context.Context) error { return nil }
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op := waitForState(t, store, "instance", osb.StateSucceeded); op.Interrupted {
+		t.Error("resumed operation is still marked interrupted")
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	store := state.NewMemory()
+	e := NewEngine(store)
+	e.StuckAfter = 50 * time.Millisecond
+	defer shutdown(t, e)
+
+	op := startOperation(t, store, "instance", "provision")
+	deadline := time.Now().Add(50 * time.Millisecond)
+	op.Deadline = &deadline
+	release := make(chan struct{})
+	err := e.Run("instance", op, func(ctx context.Context) error {
+		// Ignore the cancellation of ctx.
+		<-release
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Watchdog(context.Background()); err != nil {
+		t.Errorf("Watchdog failed before the deadline: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if err := e.Watchdog(context.Background()); err == nil {
+		t.Error("Watchdog passed with an operation stuck past its deadline")
+	}
+
+	close(release)
+	waitForState(t, store, "instance", osb.StateFailed)
+	if err := e.Watchdog(context.Background()); err != nil {
+		t.Errorf("Watchdog failed once the operation finished: %v", err)
+	}
+}
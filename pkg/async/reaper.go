@@ -0,0 +1,205 @@
+package async
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Reaper cleans up the resources of failed instances in the background. A
+// failed provision or deprovision may leave resources behind, and nothing
+// obliges the platform to deprovision the instance to have them removed. The
+// Reaper retries the cleanup of each failed instance with exponential backoff
+// until it succeeds. Once it has failed OrphanAfter times, the instance's
+// resources are reported as orphaned; the Reaper keeps retrying them every
+// MaxBackoff.
+//
+// A cleanup holds the instance like an operation, so requests for other
+// operations on it are refused while it runs, but it is not recorded as the
+// instance's last operation: that stays the operation that failed.
+type Reaper struct {
+	// Interval is how often the Reaper looks for failed instances to clean
+	// up.
+	Interval time.Duration
+	// Timeout bounds each attempt to clean up an instance.
+	Timeout time.Duration
+	// MinBackoff is the delay after the first failed attempt to clean up an
+	// instance. It doubles after every further failure, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OrphanAfter is the number of failed attempts after which an instance's
+	// resources are reported as orphaned.
+	OrphanAfter int
+
+	engine  *Engine
+	cleanup func(i *state.Instance) Func
+}
+
+// NewReaper returns a Reaper for the instances of e. cleanup returns the work
+// of cleaning up an instance, which must delete whatever resources of the
+// instance remain and return once they are gone. The Reaper stops when e is
+// shut down.
+func NewReaper(e *Engine, cleanup func(i *state.Instance) Func) *Reaper {
+	return &Reaper{
+		Interval:    time.Minute,
+		Timeout:     10 * time.Minute,
+		MinBackoff:  time.Minute,
+		MaxBackoff:  time.Hour,
+		OrphanAfter: 10,
+		engine:      e,
+		cleanup:     cleanup,
+	}
+}
+
+// Start starts cleaning up failed instances in the background.
+func (r *Reaper) Start() {
+	go func() {
+		for {
+			r.reap()
+			select {
+			case <-r.engine.ctx.Done():
+				return
+			case <-time.After(r.Interval):
+			}
+		}
+	}()
+}
+
+// reap attempts to clean up every failed instance that is due a cleanup.
+func (r *Reaper) reap() {
+	var due []string
+	now := time.Now()
+	err := r.engine.store.View(func(tx state.Tx) error {
+		instances, err := tx.ListInstances()
+		if err != nil {
+			return err
+		}
+		for _, i := range instances {
+			if i.State != state.InstanceFailed {
+				continue
+			}
+			if c := i.Cleanup; c != nil && (c.Finished != nil || now.Before(c.Next)) {
+				continue
+			}
+			due = append(due, i.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.With("error", err).Error("unable to list failed instances to clean up")
+		return
+	}
+
+	for _, id := range due {
+		r.clean(id)
+	}
+}
+
+// clean attempts to clean up the failed instance with the given ID and
+// records the outcome.
+func (r *Reaper) clean(id string) {
+	key := "cleanup-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if r.engine.Claim(id, key) != nil {
+		// Another operation is running on the instance, or the broker is
+		// shutting down.
+		return
+	}
+	defer r.engine.Release(id, key)
+
+	// The instance may have been deprovisioned since it was listed; once it
+	// is claimed, it cannot be until the cleanup is done.
+	var instance *state.Instance
+	err := r.engine.store.View(func(tx state.Tx) error {
+		var err error
+		instance, err = tx.GetInstance(id)
+		return err
+	})
+	if err == state.ErrNotFound || (err == nil && instance.State != state.InstanceFailed) {
+		return
+	} else if err != nil {
+		log.With("instance_id", id, "error", err).Error("unable to read failed instance to clean up")
+		return
+	}
+
+	l := log.With("instance_id", id)
+	l.V(4).Info("cleaning up failed instance")
+	ctx, cancel := context.WithTimeout(r.engine.ctx, r.Timeout)
+	err = r.cleanup(instance)(ctx)
+	cancel()
+	if r.engine.ctx.Err() != nil {
+		// Interrupted by shutdown, which is not the cleanup's fault.
+		return
+	}
+
+	c := r.record(id, err)
+	switch {
+	case c == nil:
+	case err == nil:
+		l.With("attempts", c.Attempts).Info("cleaned up the resources of failed instance")
+	case c.Orphaned && c.Attempts == r.OrphanAfter:
+		l.With("attempts", c.Attempts, "error", err).Error("unable to clean up the resources of failed instance; they are orphaned and may need to be removed by hand")
+	default:
+		l.With("attempts", c.Attempts, "next", c.Next, "error", err).Warning("unable to clean up the resources of failed instance")
+	}
+}
+
+// record records the outcome of an attempt to clean up the instance with the
+// given ID and returns its updated Cleanup, or nil if the instance is no
+// longer failed.
+func (r *Reaper) record(id string, cleanupErr error) *state.Cleanup {
+	var c *state.Cleanup
+	err := r.engine.store.Update(func(tx state.Tx) error {
+		instance, err := tx.GetInstance(id)
+		if err != nil {
+			return err
+		}
+		if instance.State != state.InstanceFailed {
+			return nil
+		}
+
+		updated := state.Cleanup{}
+		if instance.Cleanup != nil {
+			updated = *instance.Cleanup
+		}
+		updated.Attempts++
+		now := time.Now()
+		if cleanupErr == nil {
+			updated.LastError = ""
+			updated.Finished = &now
+			updated.Orphaned = false
+		} else {
+			updated.LastError = cleanupErr.Error()
+			updated.Next = now.Add(r.backoff(updated.Attempts))
+			if updated.Attempts >= r.OrphanAfter {
+				updated.Orphaned = true
+			}
+		}
+		instance.Cleanup = &updated
+		if err := tx.PutInstance(instance); err != nil {
+			return err
+		}
+		c = &updated
+		return nil
+	})
+	if err != nil && err != state.ErrNotFound {
+		log.With("instance_id", id, "error", err).Error("unable to record cleanup of failed instance")
+		return nil
+	}
+	return c
+}
+
+// backoff returns the delay after the given number of failed attempts to
+// clean up an instance.
+func (r *Reaper) backoff(attempts int) time.Duration {
+	d := r.MinBackoff
+	for n := 1; n < attempts && d < r.MaxBackoff; n++ {
+		d *= 2
+	}
+	if d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+)
+
+// Broker wraps a broker.Interface and writes a Record to its Sink for every
+// provision, update, deprovision, bind and unbind request. Read-only
+// operations are passed through unaudited.
+type Broker struct {
+	broker.Interface
+
+	Sink Sink
+}
+
+var _ broker.Interface = &Broker{}
+
+// NewBroker returns a Broker that wraps b and writes records to sink.
+func NewBroker(b broker.Interface, sink Sink) *Broker {
+	return &Broker{
+		Interface: b,
+		Sink:      sink,
+	}
+}
+
+func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
+	r := &Record{
+		Started:    time.Now(),
+		Action:     "provision",
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Identity:   NewIdentity(request.OriginatingIdentity),
+		Parameters: Redact(request.Parameters),
+	}
+	response, err := b.Interface.Provision(request, c)
+	b.write(c, r, response != nil && response.Async, err)
+	return response, err
+}
+
+func (b *Broker) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
+	r := &Record{
+		Started:    time.Now(),
+		Action:     "update",
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		Identity:   NewIdentity(request.OriginatingIdentity),
+		Parameters: Redact(request.Parameters),
+	}
+	if request.PlanID != nil {
+		r.PlanID = *request.PlanID
+	}
+	response, err := b.Interface.Update(request, c)
+	b.write(c, r, response != nil && response.Async, err)
+	return response, err
+}
+
+func (b *Broker) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
+	r := &Record{
+		Started:    time.Now(),
+		Action:     "deprovision",
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Identity:   NewIdentity(request.OriginatingIdentity),
+	}
+	response, err := b.Interface.Deprovision(request, c)
+	b.write(c, r, response != nil && response.Async, err)
+	return response, err
+}
+
+func (b *Broker) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
+	r := &Record{
+		Started:    time.Now(),
+		Action:     "bind",
+		InstanceID: request.InstanceID,
+		BindingID:  request.BindingID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Identity:   NewIdentity(request.OriginatingIdentity),
+		Parameters: Redact(request.Parameters),
+	}
+	// osb-broker-lib answers bind and unbind requests synchronously, even if
+	// the response is marked asynchronous.
+	response, err := b.Interface.Bind(request, c)
+	b.write(c, r, false, err)
+	return response, err
+}
+
+func (b *Broker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
+	r := &Record{
+		Started:    time.Now(),
+		Action:     "unbind",
+		InstanceID: request.InstanceID,
+		BindingID:  request.BindingID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Identity:   NewIdentity(request.OriginatingIdentity),
+	}
+	response, err := b.Interface.Unbind(request, c)
+	b.write(c, r, false, err)
+	return response, err
+}
+
+// write completes r with the outcome of the request and writes it to the
+// sink. A failure to write the record is logged but does not fail the
+// request, which has already been carried out.
+func (b *Broker) write(c *broker.RequestContext, r *Record, async bool, err error) {
+	r.Finished = time.Now()
+	switch {
+	case err != nil:
+		r.Outcome = OutcomeFailed
+		r.Error = err.Error()
+		if httpErr, ok := osb.IsHTTPError(err); ok {
+			r.StatusCode = httpErr.StatusCode
+		}
+	case async:
+		r.Outcome = OutcomeAccepted
+	default:
+		r.Outcome = OutcomeSucceeded
+	}
+
+	if err := b.Sink.Write(r); err != nil {
+		log.ForRequest(c).With("error", err).Error("unable to write audit record")
+	}
+}
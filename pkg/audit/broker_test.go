@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// memorySink keeps the records written to it.
+type memorySink struct {
+	records []*Record
+}
+
+func (s *memorySink) Write(r *Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+// asyncBroker marks every response it returns asynchronous.
+type asyncBroker struct {
+	broker.Interface
+}
+
+func (asyncBroker) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
+	return &broker.ProvisionResponse{ProvisionResponse: osb.ProvisionResponse{Async: true}}, nil
+}
+
+func (asyncBroker) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
+	return &broker.BindResponse{BindResponse: osb.BindResponse{Async: true}}, nil
+}
+
+func (asyncBroker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
+	return &broker.UnbindResponse{UnbindResponse: osb.UnbindResponse{Async: true}}, nil
+}
+
+func TestBrokerOutcome(t *testing.T) {
+	sink := &memorySink{}
+	b := NewBroker(asyncBroker{}, sink)
+	c := &broker.RequestContext{}
+
+	b.Provision(&osb.ProvisionRequest{InstanceID: "instance"}, c)
+	b.Bind(&osb.BindRequest{InstanceID: "instance", BindingID: "binding"}, c)
+	b.Unbind(&osb.UnbindRequest{InstanceID: "instance", BindingID: "binding"}, c)
+
+	want := map[string]string{
+		"provision": OutcomeAccepted,
+		"bind":      OutcomeSucceeded,
+		"unbind":    OutcomeSucceeded,
+	}
+	if len(sink.records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(sink.records), len(want))
+	}
+	for _, r := range sink.records {
+		if r.Outcome != want[r.Action] {
+			t.Errorf("%s recorded as %q, want %q", r.Action, r.Outcome, want[r.Action])
+		}
+	}
+}
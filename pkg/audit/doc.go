@@ -0,0 +1,6 @@
+// Package audit records an append-only trail of every state-changing OSB
+// request the broker handles: who asked for it, with which (redacted)
+// parameters, and how it turned out. Records are written to a Sink; FileSink
+// writes them as JSON lines to a size-rotated file that can be searched with
+// Query.
+package audit // import "github.com/pmorie/osb-starter-pack/pkg/audit"
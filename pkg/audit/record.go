@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/identity"
+)
+
+// Outcomes of an audited request.
+const (
+	// OutcomeSucceeded means the broker completed the request.
+	OutcomeSucceeded = "succeeded"
+	// OutcomeAccepted means the broker accepted the request and is completing
+	// it asynchronously.
+	OutcomeAccepted = "accepted"
+	// OutcomeFailed means the broker returned an error for the request.
+	OutcomeFailed = "failed"
+)
+
+// Record is a single entry in the audit trail.
+type Record struct {
+	// Started is when the broker received the request.
+	Started time.Time `json:"started"`
+	// Finished is when the broker returned a response for the request.
+	Finished time.Time `json:"finished"`
+	// Action is the OSB operation, for example "provision" or "unbind".
+	Action     string `json:"action"`
+	InstanceID string `json:"instance_id"`
+	BindingID  string `json:"binding_id,omitempty"`
+	ServiceID  string `json:"service_id,omitempty"`
+	PlanID     string `json:"plan_id,omitempty"`
+	// Identity is the originating identity of the user on whose behalf the
+	// platform made the request, if the platform sent one.
+	Identity *Identity `json:"identity,omitempty"`
+	// Parameters are the request's parameters with sensitive values
+	// redacted.
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Outcome is one of OutcomeSucceeded, OutcomeAccepted or OutcomeFailed.
+	Outcome string `json:"outcome"`
+	// StatusCode is the HTTP status code of a failed request, if the broker
+	// returned one.
+	StatusCode int `json:"status_code,omitempty"`
+	// Error is the error a failed request returned.
+	Error string `json:"error,omitempty"`
+}
+
+// Identity is the audited form of an osb.OriginatingIdentity.
+type Identity struct {
+	Platform string `json:"platform"`
+	// User is the Kubernetes username or Cloud Foundry user_id.
+	User string `json:"user,omitempty"`
+	// Groups are the Kubernetes groups of the user.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// NewIdentity returns the Identity for the given originating identity, or nil
+// if there is none.
+func NewIdentity(o *osb.OriginatingIdentity) *Identity {
+	if o == nil {
+		return nil
+	}
+
+	platform, user, groups := identity.Parse(o)
+	return &Identity{Platform: platform, User: user, Groups: groups}
+}
+
+// Redacted is the value that replaces sensitive parameter values.
+const Redacted = "REDACTED"
+
+// SensitiveKeys holds the strings that mark a parameter name as sensitive
+// when it contains one of them, ignoring case, so "db_password",
+// "DBPASSWORD" and "accessToken" are all sensitive.
+var SensitiveKeys = []string{
+	"password",
+	"passwd",
+	"passphrase",
+	"secret",
+	"token",
+	"credential",
+	"apikey",
+	"accesskey",
+	"privatekey",
+	"sshkey",
+	"certificate",
+}
+
+// SensitiveWords holds the words that are too short to look for anywhere in
+// a parameter name, but mark it as sensitive when it contains one of them, or
+// its plural, as a whole word. Names are split into lower-case words at any
+// character other than a letter or digit, such as an underscore or hyphen,
+// and at changes of case, so "tls_key" and "TLSCert" are sensitive while
+// "monkey" and "keyboard_layout" are not.
+var SensitiveWords = []string{
+	"key",
+	"cert",
+}
+
+// Redact returns a deep copy of params with the values of sensitive keys
+// replaced by Redacted.
+func Redact(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+
+	redacted := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if isSensitive(k) {
+			redacted[k] = Redacted
+			continue
+		}
+		redacted[k] = redactValue(v)
+	}
+	return redacted
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return Redact(t)
+	case []interface{}:
+		values := make([]interface{}, len(t))
+		for i := range t {
+			values[i] = redactValue(t[i])
+		}
+		return values
+	}
+	return v
+}
+
+func isSensitive(key string) bool {
+	lower := strings.ToLower(key)
+	for _, s := range SensitiveKeys {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	for _, word := range words(key) {
+		for _, s := range SensitiveWords {
+			if word == s || word == s+"s" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// words splits a parameter name into its lower-case words.
+func words(name string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		// A capital starts a word after a lower-case letter or digit, as in
+		// "apiKey", and so does the last capital of an acronym followed by
+		// a lower-case letter, as in "TLSCert".
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && next {
+				flush()
+			}
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	flush()
+	return words
+}
@@ -0,0 +1,69 @@
+package audit
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		sensitive bool
+	}{
+		{"password", true},
+		{"dbpassword", true},
+		{"DBPASSWORD", true},
+		{"adminPassword", true},
+		{"db_password", true},
+		{"password1", true},
+		{"passwd", true},
+		{"ssh-passphrase", true},
+		{"clientSecret", true},
+		{"accesstoken", true},
+		{"refresh_tokens", true},
+		{"credentials", true},
+		{"apikey", true},
+		{"apiKey", true},
+		{"api_key", true},
+		{"aws-access-key", true},
+		{"privatekey", true},
+		{"sshkey", true},
+		{"keys", true},
+		{"TLSCert", true},
+		{"ca_certs", true},
+		{"clientCertificate", true},
+		{"monkey", false},
+		{"keyboard_layout", false},
+		{"certainty", false},
+		{"color", false},
+		{"size", false},
+	} {
+		params := map[string]interface{}{tc.name: "value"}
+		redacted := Redact(params)[tc.name] == Redacted
+		if redacted != tc.sensitive {
+			t.Errorf("%q: redacted %v, want %v", tc.name, redacted, tc.sensitive)
+		}
+	}
+}
+
+func TestRedactNested(t *testing.T) {
+	params := map[string]interface{}{
+		"database": map[string]interface{}{
+			"user":     "admin",
+			"password": "hunter2",
+		},
+		"users": []interface{}{
+			map[string]interface{}{"name": "a", "token": "t"},
+		},
+	}
+	redacted := Redact(params)
+
+	database := redacted["database"].(map[string]interface{})
+	if database["password"] != Redacted || database["user"] != "admin" {
+		t.Errorf("got database %v, want only its password redacted", database)
+	}
+	user := redacted["users"].([]interface{})[0].(map[string]interface{})
+	if user["token"] != Redacted || user["name"] != "a" {
+		t.Errorf("got user %v, want only its token redacted", user)
+	}
+	if params["database"].(map[string]interface{})["password"] != "hunter2" {
+		t.Error("Redact changed the parameters it was given")
+	}
+}
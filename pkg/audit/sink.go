@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sink receives audit records. Implementations must be safe for concurrent
+// use.
+type Sink interface {
+	// Write appends a record to the audit trail.
+	Write(r *Record) error
+	// Close flushes any buffered records and releases the sink's resources.
+	Close() error
+}
+
+// FileSink is a Sink that appends records as JSON lines to a file. When the
+// file grows past MaxSize bytes it is rotated: path becomes path.1, path.1
+// becomes path.2 and so on, keeping at most MaxBackups rotated files. At least
+// one is kept, so rotating never deletes the records just written.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu sync.Mutex
+	// file is nil if the sink was closed, or if a rotation was unable to
+	// reopen it, in which case the next Write tries again.
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+var _ Sink = &FileSink{}
+
+// NewFileSink opens, or creates, the audit file at path. A maxSize of zero
+// disables rotation; otherwise maxBackups must be at least 1.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize > 0 && maxBackups < 1 {
+		return nil, fmt.Errorf("rotating audit file %q needs at least 1 backup, not %d", path, maxBackups)
+	}
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends r to the file, rotating it first if r would take it past its
+// maximum size.
+func (s *FileSink) Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("audit file %q is closed", s.path)
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	var rotateErr error
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		rotateErr = s.rotate()
+		if s.file == nil {
+			return rotateErr
+		}
+	}
+
+	// A failed rotation still leaves a file open, so the record is written
+	// even though the file is over its maximum size.
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return err
+}
+
+// rotate moves the audit file to the first backup and opens a new one. If
+// the backups cannot be moved, it reopens the file it closed, so the trail
+// continues there.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err == nil {
+		err = s.shiftBackups()
+	}
+	if openErr := s.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+func (s *FileSink) shiftBackups() error {
+	os.Remove(backupPath(s.path, s.maxBackups))
+	for i := s.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(backupPath(s.path, i), backupPath(s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.path, backupPath(s.path, 1))
+}
+
+// Close syncs and closes the audit file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Filter selects records from the audit trail. Empty fields match every
+// record.
+type Filter struct {
+	Action     string
+	InstanceID string
+	BindingID  string
+	User       string
+	Outcome    string
+	Since      time.Time
+	Until      time.Time
+}
+
+// Match reports whether r is selected by f.
+func (f *Filter) Match(r *Record) bool {
+	switch {
+	case f.Action != "" && f.Action != r.Action:
+		return false
+	case f.InstanceID != "" && f.InstanceID != r.InstanceID:
+		return false
+	case f.BindingID != "" && f.BindingID != r.BindingID:
+		return false
+	case f.Outcome != "" && f.Outcome != r.Outcome:
+		return false
+	case f.User != "" && (r.Identity == nil || f.User != r.Identity.User):
+		return false
+	case !f.Since.IsZero() && r.Started.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && r.Started.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Query reads the audit file at path and the backups rotated from it, oldest
+// first, and returns the records selected by f.
+func Query(path string, maxBackups int, f *Filter) ([]*Record, error) {
+	paths := []string{}
+	for i := maxBackups; i > 0; i-- {
+		paths = append(paths, backupPath(path, i))
+	}
+	paths = append(paths, path)
+
+	records := []*Record{}
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			r := &Record{}
+			if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: %v", p, line, err)
+			}
+			if f.Match(r) {
+				records = append(records, r)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeRecords(t *testing.T, s *FileSink, instanceIDs ...string) {
+	for _, id := range instanceIDs {
+		if err := s.Write(&Record{Action: "provision", InstanceID: id}); err != nil {
+			t.Fatalf("Write of %s: %v", id, err)
+		}
+	}
+}
+
+// instanceIDs returns the instance IDs of the records in the audit file at
+// path and its backups, oldest first.
+func instanceIDs(t *testing.T, path string, maxBackups int) []string {
+	records, err := Query(path, maxBackups, &Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var ids []string
+	for _, r := range records {
+		ids = append(ids, r.InstanceID)
+	}
+	return ids
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// Each record is about 100 bytes, so every file holds one.
+	s, err := NewFileSink(path, 150, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	writeRecords(t, s, "a", "b", "c", "d")
+
+	if got := instanceIDs(t, path, 2); len(got) != 3 || got[0] != "b" || got[2] != "d" {
+		t.Errorf("got records %v, want b, c and d", got)
+	}
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("a third backup exists: %v", err)
+	}
+}
+
+func TestFileSinkRotationFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	s, err := NewFileSink(path, 150, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	writeRecords(t, s, "a")
+
+	// The file cannot be renamed over a directory that is not empty.
+	blocker := filepath.Join(backupPath(path, 1), "blocker")
+	if err := os.MkdirAll(blocker, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(&Record{Action: "provision", InstanceID: "b"}); err == nil {
+		t.Error("Write succeeded although the audit file could not be rotated")
+	}
+
+	// The record was kept, and the sink rotates once it can.
+	if err := os.RemoveAll(backupPath(path, 1)); err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, s, "c")
+	if got := instanceIDs(t, path, 1); len(got) != 3 || got[1] != "b" || got[2] != "c" {
+		t.Errorf("got records %v, want a, b and c", got)
+	}
+}
+
+func TestFileSinkClosed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileSink(filepath.Join(dir, "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(&Record{Action: "provision"}); err == nil {
+		t.Error("Write to a closed sink succeeded")
+	}
+}
@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/admin"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// The skeleton serves the admin API from the BusinessLogic's store and
+// operations.
+var _ admin.Backend = &BusinessLogic{}
+
+// Store returns the store the broker keeps its instances and bindings in.
+func (b *BusinessLogic) Store() state.Store {
+	return b.store
+}
+
+// Running reports whether an operation on the instance with the given ID is
+// running.
+func (b *BusinessLogic) Running(instanceID string) bool {
+	return b.engine.Running(instanceID)
+}
+
+// ForceDeleteInstance deletes the broker's record of an instance and its
+// bindings without asking the driver to delete their resources, for an
+// operator to forget an instance that cannot be deprovisioned. It fails while
+// an operation on the instance is running.
+func (b *BusinessLogic) ForceDeleteInstance(instanceID string) error {
+	// The instance is claimed until the deletion commits, so that no
+	// operation can start on it in the meantime.
+	key := "force-delete-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	defer b.engine.Release(instanceID, key)
+	return b.store.Update(func(tx state.Tx) error {
+		if _, err := tx.GetInstance(instanceID); err != nil {
+			return err
+		}
+		if err := b.claim(instanceID, key); err != nil {
+			return err
+		}
+		return tx.DeleteInstance(instanceID)
+	})
+}
+
+// ForceDeleteBinding deletes the broker's record of a binding without asking
+// the driver to delete its credentials.
+func (b *BusinessLogic) ForceDeleteBinding(instanceID, bindingID string) error {
+	return b.store.Update(func(tx state.Tx) error {
+		if _, err := tx.GetBinding(instanceID, bindingID); err != nil {
+			return err
+		}
+		return tx.DeleteBinding(instanceID, bindingID)
+	})
+}
+
+// RetryOperation starts a new operation of the same type as the failed last
+// operation of an instance, as if the platform had sent the request again,
+// and runs it in the background. A retried provision or deprovision moves
+// the instance out of the failed state while it runs.
+func (b *BusinessLogic) RetryOperation(instanceID string) (*state.Operation, error) {
+	var instance *state.Instance
+	var op *state.Operation
+	err := b.store.Update(func(tx state.Tx) error {
+		var err error
+		instance, err = tx.GetInstance(instanceID)
+		if err != nil {
+			return err
+		}
+		last := instance.LastOperation
+		if last == nil || last.State != osb.StateFailed {
+			description := "The last operation on the instance did not fail"
+			return osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusConflict,
+				Description: &description,
+			}
+		}
+
+		next := b.newOperation(last.Type, instance.PlanID)
+		if err := b.claim(instanceID, next.Key); err != nil {
+			return err
+		}
+		op = next
+		switch last.Type {
+		case "provision":
+			instance.State = state.InstanceCreating
+		case "deprovision":
+			instance.State = state.InstanceDeleting
+		}
+		instance.Cleanup = nil
+		instance.StartOperation(op)
+		return tx.PutInstance(instance)
+	})
+	if err != nil {
+		if op != nil {
+			b.engine.Release(instanceID, op.Key)
+		}
+		return nil, err
+	}
+
+	if err := b.engine.Run(instance.ID, op, b.resume(instance)); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
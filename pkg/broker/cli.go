@@ -2,20 +2,53 @@ package broker
 
 import (
 	"flag"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver/exec"
 )
 
 // Options holds the options specified by the broker's code on the command
 // line. Users should add their own options here and add flags for them in
 // AddFlags.
 type Options struct {
-	CatalogPath string
-	Async       bool
+	CatalogPath            string
+	CatalogReloadInterval  time.Duration
+	Async                  bool
+	WorkloadDir            string
+	ExecDir                string
+	ExecTimeout            time.Duration
+	NamespaceService       bool
+	NamespaceAPIServer     string
+	CredentialsSecrets     bool
+	CleanupInterval        time.Duration
+	MaximumPollingDuration time.Duration
+
+	// KubernetesConfig returns the configuration of a client for the
+	// cluster the broker runs in, as set by the skeleton's --kube-config
+	// flag. It is set by the skeleton rather than by a flag.
+	KubernetesConfig func() (*rest.Config, error)
 }
 
-// AddFlags is a hook called to initialize the CLI flags for broker options.
-// It is called after the flags are added for the skeleton and before flag
-// parse is called.
-func AddFlags(o *Options) {
-	flag.StringVar(&o.CatalogPath, "catalogPath", "", "The path to the catalog")
-	flag.BoolVar(&o.Async, "async", false, "Indicates whether the broker is handling the requests asynchronously.")
+// AddFlags is a hook called to initialize the CLI flags for broker options
+// on fs. It is called for the skeleton's command line after the flags are
+// added for the skeleton and before flag parse is called. Like the skeleton's
+// flags, they can also be set in the file named by --config or by OSB_
+// environment variables, for example OSB_CATALOG_PATH for --catalogPath. It
+// is also called for each broker in the file named by --brokers-file, which
+// sets their options by flag name. Pass the names of flags that hold secrets
+// to config.MarkSecret so `servicebroker config` redacts them.
+func AddFlags(fs *flag.FlagSet, o *Options) {
+	fs.StringVar(&o.CatalogPath, "catalogPath", "", "path of a YAML or JSON catalog file that redefines services the broker offers, such as their descriptions, plans and plans' maintenance_info")
+	fs.DurationVar(&o.CatalogReloadInterval, "catalog-reload-interval", 10*time.Second, "how often to check the catalog file, workload directory and exec directory for changes, and reload the catalog if they changed; 0 only reloads it on SIGHUP")
+	fs.BoolVar(&o.Async, "async", false, "Indicates whether the broker is handling the requests asynchronously.")
+	fs.StringVar(&o.WorkloadDir, "workload-dir", "", "directory of services whose plans deploy Kubernetes manifests into the namespace they are provisioned from")
+	fs.StringVar(&o.ExecDir, "exec-dir", "", "directory of services whose plans run scripts to provision, bind to and report the status of their instances")
+	fs.DurationVar(&o.ExecTimeout, "exec-timeout", exec.DefaultTimeout, "how long each run of a script of the services in --exec-dir may take before it is killed; 0 lets scripts run for as long as their operation may")
+	fs.BoolVar(&o.NamespaceService, "namespace-service", false, "offer a service whose instances are Kubernetes namespaces with a quota")
+	fs.StringVar(&o.NamespaceAPIServer, "namespace-api-server", "", "URL of the Kubernetes API server in the kubeconfigs the namespace service returns; defaults to the one the broker uses")
+	fs.DurationVar(&o.CleanupInterval, "cleanup-interval", time.Minute, "how often to look for failed instances whose leftover resources are due to be cleaned up; 0 disables the cleanup")
+	fs.DurationVar(&o.MaximumPollingDuration, "maximum-polling-duration", time.Hour, "how long operations on instances of plans without a maximum_polling_duration of their own may run before they fail; 0 lets them run forever")
+	fs.BoolVar(&o.CredentialsSecrets, "credentials-secrets", false, "let bindings have their credentials written to a Kubernetes Secret, with the credentials_secret parameter or the credentialsSecret plan metadata")
 }
@@ -3,8 +3,15 @@
 //
 // - The Options type, which holds options for the broker
 // - The AddFlags function, which adds CLI flags for an Options
+// - A driver.Driver for each service the broker offers, which creates,
+//   updates, deletes and binds to the service's resources; the example
+//   service's driver is in example.go
 // - The methods of the BusinessLogic type, which implements the broker's
-//   business logic
+//   business logic. Its methods handle the OSB API around the drivers, so
+//   most brokers only need to add their services to its catalog
 // - The NewBusinessLogic function, which creates a BusinessLogic from the
-//   Options the program is run with
+//   Options the program is run with and the Store that holds the broker's
+//   instances and bindings
+// - The RegisterHealthChecks method, which adds the BusinessLogic's checks to
+//   the broker's readiness and liveness endpoints
 package broker // import "github.com/pmorie/osb-starter-pack/pkg/broker"
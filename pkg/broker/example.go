@@ -0,0 +1,116 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/maintenance"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+func truePtr() *bool {
+	b := true
+	return &b
+}
+
+// exampleService is the service the example driver provisions. Replace it with
+// your own services, or add them alongside it in newCatalog.
+var exampleService = osb.Service{
+	Name:          "example-starter-pack-service",
+	ID:            "4f6e6cf6-ffdd-425f-a2c7-3c9258ad246a",
+	Description:   "The example service from the osb starter pack!",
+	Bindable:      true,
+	PlanUpdatable: truePtr(),
+	Metadata: map[string]interface{}{
+		"displayName": "Example starter pack service",
+		"imageUrl":    "https://avatars2.githubusercontent.com/u/19862012?s=200&v=4",
+	},
+	Plans: []osb.Plan{
+		{
+			Name:        "default",
+			ID:          "86064792-7ea2-467b-af93-ac9694d96d5b",
+			Description: "The default plan for the starter pack example service",
+			Free:        truePtr(),
+			Schemas: &osb.Schemas{
+				ServiceInstance: &osb.ServiceInstanceSchema{
+					Create: &osb.InputParametersSchema{
+						Parameters: map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"color": map[string]interface{}{
+									"type":    "string",
+									"default": "Clear",
+									"enum": []string{
+										"Clear",
+										"Beige",
+										"Grey",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+// exampleMaintenanceInfo is the maintenance info of the example service's plan.
+var exampleMaintenanceInfo = maintenance.Info{
+	Version:     "1.0.0",
+	Description: "The first version of the example service",
+}
+
+// exampleDriver is the driver.Driver of the example service. Its resources
+// are entries in a map; a real driver would create a database, a namespace or
+// whatever its service offers.
+type exampleDriver struct {
+	mu        sync.Mutex
+	instances map[string]struct{}
+}
+
+var _ driver.Driver = &exampleDriver{}
+
+func newExampleDriver() *exampleDriver {
+	return &exampleDriver{instances: map[string]struct{}{}}
+}
+
+func (d *exampleDriver) Create(ctx context.Context, i *state.Instance) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.instances[i.ID] = struct{}{}
+	return nil
+}
+
+func (d *exampleDriver) Update(ctx context.Context, i *state.Instance) error {
+	// The map does not survive a restart of the broker, so updating an
+	// instance recreates it if needed.
+	return d.Create(ctx, i)
+}
+
+func (d *exampleDriver) Delete(ctx context.Context, i *state.Instance) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.instances, i.ID)
+	return nil
+}
+
+func (d *exampleDriver) Bind(ctx context.Context, i *state.Instance, b *state.Binding) (map[string]interface{}, error) {
+	// The example's credentials are the instance's parameters.
+	return i.Parameters, nil
+}
+
+func (d *exampleDriver) Unbind(ctx context.Context, i *state.Instance, b *state.Binding) error {
+	return nil
+}
+
+func (d *exampleDriver) Status(ctx context.Context, i *state.Instance) (*driver.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.instances[i.ID]; ok {
+		return &driver.Status{State: driver.Ready}, nil
+	}
+	return &driver.Status{State: driver.Gone}, nil
+}
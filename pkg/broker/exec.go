@@ -0,0 +1,32 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/driver/exec"
+)
+
+// addExecServices adds the services in o.ExecDir, whose plans run scripts,
+// to c. See package exec for how the directory is laid out.
+func addExecServices(c *driver.Catalog, o Options) error {
+	dirs, err := exec.Services(o.ExecDir)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("%s has no services", o.ExecDir)
+	}
+
+	for _, dir := range dirs {
+		service, d, err := exec.Load(dir)
+		if err != nil {
+			return err
+		}
+		d.Timeout = o.ExecTimeout
+		if err := c.Add(*service, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"fmt"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/driver/namespaces"
+	"github.com/pmorie/osb-starter-pack/pkg/driver/secrets"
+	"github.com/pmorie/osb-starter-pack/pkg/driver/workload"
+)
+
+// addWorkloads adds the services in o.WorkloadDir, whose plans deploy
+// Kubernetes manifests, to c. See package workload for how the directory is
+// laid out.
+func addWorkloads(c *driver.Catalog, o Options) error {
+	dirs, err := workload.Services(o.WorkloadDir)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("%s has no services", o.WorkloadDir)
+	}
+	client, _, err := kubernetesClient(o)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		service, d, err := workload.Load(client, dir)
+		if err != nil {
+			return err
+		}
+		if err := c.Add(*service, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNamespaces adds the namespace service, whose instances are Kubernetes
+// namespaces, to c.
+func addNamespaces(c *driver.Catalog, o Options) error {
+	client, config, err := kubernetesClient(o)
+	if err != nil {
+		return err
+	}
+	server := o.NamespaceAPIServer
+	if server == "" {
+		server = config.Host
+	}
+	return c.Add(namespaces.Service(), namespaces.New(client, server))
+}
+
+// addCredentialsSecrets lets the bindings of every service in the catalog
+// have their credentials written to Kubernetes Secrets. See package secrets
+// for how they ask for it.
+func addCredentialsSecrets(c *driver.Catalog, o Options) error {
+	client, _, err := kubernetesClient(o)
+	if err != nil {
+		return err
+	}
+	c.Wrap(func(s osb.Service, d driver.Driver) driver.Driver {
+		return secrets.Wrap(d, client, s)
+	})
+	return nil
+}
+
+// kubernetesClient returns a client for the cluster the broker runs in and
+// its configuration.
+func kubernetesClient(o Options) (kubernetes.Interface, *rest.Config, error) {
+	if o.KubernetesConfig == nil {
+		return nil, nil, fmt.Errorf("no Kubernetes client is configured")
+	}
+	config, err := o.KubernetesConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, config, nil
+}
@@ -1,27 +1,189 @@
 package broker
 
 import (
+	"context"
+	"fmt"
 	"net/http"
-	"sync"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
 
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
-	"reflect"
+	"github.com/pmorie/osb-starter-pack/pkg/async"
+	"github.com/pmorie/osb-starter-pack/pkg/catalog"
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/health"
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+	"github.com/pmorie/osb-starter-pack/pkg/maintenance"
+	"github.com/pmorie/osb-starter-pack/pkg/quota"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+	"github.com/pmorie/osb-starter-pack/pkg/visibility"
 )
 
 // NewBusinessLogic is a hook that is called with the Options the program is run
-// with. NewBusinessLogic is the place where you will initialize your
-// BusinessLogic the parameters passed in.
-func NewBusinessLogic(o Options) (*BusinessLogic, error) {
+// with and the Store the broker keeps its instances and bindings in.
+// NewBusinessLogic is the place where you will initialize your BusinessLogic
+// the parameters passed in.
+func NewBusinessLogic(o Options, store state.Store) (*BusinessLogic, error) {
 	// For example, if your BusinessLogic requires a parameter from the command
 	// line, you would unpack it from the Options and set it on the
 	// BusinessLogic here.
-	return &BusinessLogic{
-		async:     o.Async,
-		instances: make(map[string]*exampleInstance, 10),
-	}, nil
+	b := &BusinessLogic{
+		async:         o.Async,
+		options:       o,
+		store:         store,
+		engine:        async.NewEngine(store),
+		exampleDriver: newExampleDriver(),
+	}
+	c, err := b.newCatalog()
+	if err != nil {
+		return nil, err
+	}
+	b.catalog = c
+
+	// Pick up the asynchronous operations that were still running when the
+	// broker last shut down.
+	if err := b.engine.Resume(b.resume); err != nil {
+		return nil, err
+	}
+
+	// Reload the catalog when the files it is built from change.
+	if o.CatalogReloadInterval > 0 && (o.CatalogPath != "" || o.WorkloadDir != "" || o.ExecDir != "") {
+		b.watcher = catalog.NewWatcher(func() {
+			if err := b.ReloadCatalog(); err != nil {
+				log.With("error", err).Error("unable to reload the catalog")
+			}
+		}, catalogPaths(o)...)
+		b.watcher.Interval = o.CatalogReloadInterval
+		b.watcher.Start()
+	}
+
+	// Clean up the resources failed provisions and deprovisions leave
+	// behind.
+	if o.CleanupInterval > 0 {
+		reaper := async.NewReaper(b.engine, b.cleanup)
+		reaper.Interval = o.CleanupInterval
+		reaper.Start()
+	}
+
+	return b, nil
+}
+
+// newCatalog builds the catalog of the services the broker offers. It is
+// called again to reload the catalog, so it must only read files and not
+// change anything else.
+func (b *BusinessLogic) newCatalog() (*driver.Catalog, error) {
+	o := b.options
+	c := driver.NewCatalog()
+
+	// Add the services your broker offers, each with the driver.Driver that
+	// provisions it.
+	if err := c.Add(exampleService, b.exampleDriver); err != nil {
+		return nil, err
+	}
+	// Give plans maintenance info, and change its version in releases that
+	// change the plan's instances, to have platforms offer to upgrade them.
+	if err := c.SetMaintenanceInfo(exampleService.Plans[0].ID, exampleMaintenanceInfo); err != nil {
+		return nil, err
+	}
+	if o.WorkloadDir != "" {
+		if err := addWorkloads(c, o); err != nil {
+			return nil, err
+		}
+	}
+	if o.ExecDir != "" {
+		if err := addExecServices(c, o); err != nil {
+			return nil, err
+		}
+	}
+	if o.NamespaceService {
+		if err := addNamespaces(c, o); err != nil {
+			return nil, err
+		}
+	}
+	if o.CatalogPath != "" {
+		if err := c.ApplyFile(o.CatalogPath); err != nil {
+			return nil, err
+		}
+	}
+	if o.CredentialsSecrets {
+		if err := addCredentialsSecrets(c, o); err != nil {
+			return nil, err
+		}
+	}
+
+	// Plans without a maximum polling duration of their own get the default.
+	if o.MaximumPollingDuration > 0 {
+		for _, s := range c.Services() {
+			for _, p := range s.Plans {
+				if c.MaximumPollingDuration(p.ID) != 0 {
+					continue
+				}
+				if err := c.SetMaximumPollingDuration(p.ID, o.MaximumPollingDuration); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// catalogPaths returns the files the catalog is built from.
+func catalogPaths(o Options) []string {
+	var paths []string
+	if o.CatalogPath != "" {
+		paths = append(paths, o.CatalogPath)
+	}
+	if o.WorkloadDir != "" {
+		paths = append(paths, o.WorkloadDir)
+	}
+	if o.ExecDir != "" {
+		paths = append(paths, o.ExecDir)
+	}
+	return paths
+}
+
+// ReloadCatalog builds the catalog again from the files it is built from, and
+// serves it in place of the current one. The current one is kept if the new
+// one is invalid or no longer has the plan of an existing instance.
+func (b *BusinessLogic) ReloadCatalog() error {
+	c, err := b.newCatalog()
+	if err != nil {
+		return err
+	}
+	// Swap the catalog in a transaction, so that no instance of a removed
+	// plan can be created in the meantime.
+	err = b.store.Update(func(tx state.Tx) error {
+		instances, err := tx.ListInstances()
+		if err != nil {
+			return err
+		}
+		for _, i := range instances {
+			plans := []string{i.PlanID}
+			if op := i.LastOperation; op != nil && op.State == osb.StateInProgress && op.PlanID != "" {
+				// The instance is being moved to op's plan.
+				plans = append(plans, op.PlanID)
+			}
+			for _, plan := range plans {
+				if _, _, _, err := c.Lookup(i.ServiceID, plan); err != nil {
+					return fmt.Errorf("plan %q of service %q is still used by instance %q", plan, i.ServiceID, i.ID)
+				}
+			}
+		}
+		b.catalog.Set(c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.With("services", len(b.catalog.Services()), "revision", b.catalog.Revision()).Info("reloaded the catalog")
+	return nil
 }
 
 // BusinessLogic provides an implementation of the broker.BusinessLogic
@@ -29,191 +191,787 @@ func NewBusinessLogic(o Options) (*BusinessLogic, error) {
 type BusinessLogic struct {
 	// Indicates if the broker should handle the requests asynchronously.
 	async bool
-	// Synchronize go routines.
-	sync.RWMutex
-	// Add fields here! These fields are provided purely as an example
-	instances map[string]*exampleInstance
+	// The options the broker was created with, which the catalog is built
+	// from.
+	options Options
+	// Holds the broker's instances and bindings. Update transactions on the
+	// store are serialized, so they also synchronize go routines.
+	store state.Store
+	// Runs asynchronous operations and records their progress in the store.
+	engine *async.Engine
+	// Holds the services the broker offers and the drivers that provision
+	// them.
+	catalog *driver.Catalog
+	// Reloads the catalog when the files it is built from change.
+	watcher *catalog.Watcher
+	// The driver of the example service, which keeps its resources in
+	// memory, so it is kept when the catalog is reloaded.
+	exampleDriver *exampleDriver
+	// Add fields here!
 }
 
 var _ broker.Interface = &BusinessLogic{}
 
-func truePtr() *bool {
-	b := true
-	return &b
+// statusInterval is how often the broker polls a driver for the status of an
+// instance's resources while it waits for them to become ready or gone.
+const statusInterval = 2 * time.Second
+
+// maxRetryAfter is the longest the broker asks platforms to wait before
+// polling an operation again.
+const maxRetryAfter = time.Minute
+
+// RegisterHealthChecks is a hook that is called with the registry behind the
+// broker's /readyz and /livez endpoints. Register checks here for anything
+// your BusinessLogic needs in order to serve requests.
+func (b *BusinessLogic) RegisterHealthChecks(r *health.Registry) {
+	// For example, this BusinessLogic is ready when it can read its store.
+	r.AddReadinessCheck("state", health.CheckerFunc(func(ctx context.Context) error {
+		return b.store.View(func(tx state.Tx) error {
+			_, err := tx.ListInstances()
+			return err
+		})
+	}))
+	r.AddReadinessCheck("async", health.CheckerFunc(b.engine.Check))
+	// It is alive unless an asynchronous operation is stuck, which a restart
+	// fixes.
+	r.AddLivenessCheck("async", health.CheckerFunc(b.engine.Watchdog))
+}
+
+// Shutdown is a hook that is called once the broker has stopped accepting
+// requests and finished the ones in flight. Finish or checkpoint any work your
+// BusinessLogic still has running before ctx is done.
+func (b *BusinessLogic) Shutdown(ctx context.Context) error {
+	if b.watcher != nil {
+		b.watcher.Stop()
+	}
+	return b.engine.Shutdown(ctx)
+}
+
+// Catalog returns the catalog of the services the broker offers, which the
+// skeleton serves with catalog.Middleware.
+func (b *BusinessLogic) Catalog() catalog.Source {
+	return b.catalog
 }
 
 func (b *BusinessLogic) GetCatalog(c *broker.RequestContext) (*broker.CatalogResponse, error) {
-	// Your catalog business logic goes here
 	response := &broker.CatalogResponse{}
-	osbResponse := &osb.CatalogResponse{
-		Services: []osb.Service{
-			{
-				Name:          "example-starter-pack-service",
-				ID:            "4f6e6cf6-ffdd-425f-a2c7-3c9258ad246a",
-				Description:   "The example service from the osb starter pack!",
-				Bindable:      true,
-				PlanUpdatable: truePtr(),
-				Metadata: map[string]interface{}{
-					"displayName": "Example starter pack service",
-					"imageUrl":    "https://avatars2.githubusercontent.com/u/19862012?s=200&v=4",
-				},
-				Plans: []osb.Plan{
-					{
-						Name:        "default",
-						ID:          "86064792-7ea2-467b-af93-ac9694d96d5b",
-						Description: "The default plan for the starter pack example service",
-						Free:        truePtr(),
-						Schemas: &osb.Schemas{
-							ServiceInstance: &osb.ServiceInstanceSchema{
-								Create: &osb.InputParametersSchema{
-									Parameters: map[string]interface{}{
-										"type": "object",
-										"properties": map[string]interface{}{
-											"color": map[string]interface{}{
-												"type":    "string",
-												"default": "Clear",
-												"enum": []string{
-													"Clear",
-													"Beige",
-													"Grey",
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+	if c != nil && catalog.Served(c.Request) {
+		// catalog.Middleware serves the catalog it has cached.
+		return response, nil
 	}
+	var r *http.Request
+	if c != nil {
+		r = c.Request
+	}
+	response.Services = b.catalog.VisibleServices(r)
 
-	glog.Infof("catalog response: %#+v", osbResponse)
-
-	response.CatalogResponse = *osbResponse
+	log.ForRequest(c).V(5).With("services", len(response.Services)).Info("catalog response")
 
 	return response, nil
 }
 
 func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
-	// Your provision business logic goes here
-
-	// example implementation:
-	b.Lock()
-	defer b.Unlock()
+	_, _, d, err := b.catalog.Lookup(request.ServiceID, request.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	viewer := visibility.NewViewer(request.OriginatingIdentity, request.Context, request.OrganizationGUID)
+	if err := b.catalog.CheckVisible(viewer, request.ServiceID, request.PlanID); err != nil {
+		return nil, err
+	}
+	requested, err := requestMaintenanceInfo(c)
+	if err != nil {
+		return nil, err
+	}
+	current := b.catalog.MaintenanceInfo(request.PlanID)
+	if err := maintenance.Check(requested, current); err != nil {
+		return nil, err
+	}
 
 	response := broker.ProvisionResponse{}
 
-	exampleInstance := &exampleInstance{
-		ID:        request.InstanceID,
-		ServiceID: request.ServiceID,
-		PlanID:    request.PlanID,
-		Params:    request.Parameters,
+	now := time.Now()
+	instance := &state.Instance{
+		ID:                 request.InstanceID,
+		ServiceID:          request.ServiceID,
+		PlanID:             request.PlanID,
+		Parameters:         request.Parameters,
+		Context:            request.Context,
+		OrganizationGUID:   request.OrganizationGUID,
+		SpaceGUID:          request.SpaceGUID,
+		Owner:              request.OriginatingIdentity,
+		MaintenanceVersion: maintenanceVersion(current),
+		Created:            now,
+		Updated:            now,
+		State:              state.InstanceCreating,
+		LastOperation:      b.newOperation("provision", request.PlanID),
 	}
 
-	// Check to see if this is the same instance
-	if i := b.instances[request.InstanceID]; i != nil {
-		if i.Match(exampleInstance) {
+	claimed := false
+	err = b.store.Update(func(tx state.Tx) error {
+		// The catalog may have been reloaded without the plan since it was
+		// looked up.
+		var err error
+		if _, _, d, err = b.catalog.Lookup(request.ServiceID, request.PlanID); err != nil {
+			return err
+		}
+		// Check to see if this is the same instance
+		existing, err := tx.GetInstance(request.InstanceID)
+		if err == nil {
+			if !sameInstance(existing, instance) {
+				// Instance ID in use, this is a conflict.
+				description := "InstanceID in use"
+				return osb.HTTPStatusCodeError{
+					StatusCode:  http.StatusConflict,
+					Description: &description,
+				}
+			}
+			switch existing.State {
+			case state.InstanceCreating:
+				// The platform is repeating a provision request that is
+				// still running.
+				if !request.AcceptsIncomplete {
+					return notReady(existing)
+				}
+				response.Async = true
+				key := osb.OperationKey(existing.LastOperation.Key)
+				response.OperationKey = &key
+				return nil
+			case state.InstanceFailed:
+				description := "The instance failed; deprovision it before provisioning it again"
+				return osb.HTTPStatusCodeError{
+					StatusCode:  http.StatusConflict,
+					Description: &description,
+				}
+			case state.InstanceDeleting:
+				return notReady(existing)
+			}
 			response.Exists = true
-			return &response, nil
-		} else {
-			// Instance ID in use, this is a conflict.
-			description := "InstanceID in use"
-			return nil, osb.HTTPStatusCodeError{
-				StatusCode: http.StatusConflict,
-				Description: &description,
+			if existing.DashboardURL != "" {
+				dashboardURL := existing.DashboardURL
+				response.DashboardURL = &dashboardURL
 			}
+			return nil
+		} else if err != state.ErrNotFound {
+			return err
+		}
+
+		if err := b.claim(instance.ID, instance.LastOperation.Key); err != nil {
+			return err
 		}
+		claimed = true
+		return tx.PutInstance(instance)
+	})
+	if err != nil {
+		if claimed {
+			b.engine.Release(instance.ID, instance.LastOperation.Key)
+		}
+		return &response, err
+	}
+	if response.Exists || response.Async {
+		return &response, nil
 	}
-	b.instances[request.InstanceID] = exampleInstance
 
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+	op := instance.LastOperation
+	if request.AcceptsIncomplete && b.async {
+		if err := b.engine.Run(instance.ID, op, b.provision(d, instance)); err != nil {
+			return nil, err
+		}
+		response.Async = true
+		key := osb.OperationKey(op.Key)
+		response.OperationKey = &key
+		return &response, nil
 	}
 
+	if err := b.engine.RunSync(requestContext(c), instance.ID, op, b.provision(d, instance)); err != nil {
+		return nil, err
+	}
+	if instance.DashboardURL != "" {
+		response.DashboardURL = &instance.DashboardURL
+	}
 	return &response, nil
 }
 
 func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
-	// Your deprovision business logic goes here
-
-	// example implementation:
-	b.Lock()
-	defer b.Unlock()
-
 	response := broker.DeprovisionResponse{}
 
-	delete(b.instances, request.InstanceID)
+	var instance *state.Instance
+	var d driver.Driver
+	var op *state.Operation
+	err := b.store.Update(func(tx state.Tx) error {
+		var err error
+		instance, err = tx.GetInstance(request.InstanceID)
+		if err == state.ErrNotFound {
+			// The instance does not exist, or was already deprovisioned.
+			return osb.HTTPStatusCodeError{
+				StatusCode: http.StatusGone,
+			}
+		} else if err != nil {
+			return err
+		}
+		if instance.State == state.InstanceDeleting {
+			// The platform is repeating a deprovision request that is
+			// still running.
+			if !request.AcceptsIncomplete {
+				return notReady(instance)
+			}
+			response.Async = true
+			key := osb.OperationKey(instance.LastOperation.Key)
+			response.OperationKey = &key
+			return nil
+		}
+		d, err = b.catalog.Driver(instance.ServiceID)
+		if err != nil {
+			return err
+		}
 
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+		next := b.newOperation("deprovision", instance.PlanID)
+		if err := b.claim(instance.ID, next.Key); err != nil {
+			return err
+		}
+		op = next
+		instance.State = state.InstanceDeleting
+		instance.StartOperation(op)
+		return tx.PutInstance(instance)
+	})
+	if err != nil {
+		if op != nil {
+			b.engine.Release(request.InstanceID, op.Key)
+		}
+		return nil, err
+	}
+	if response.Async {
+		return &response, nil
 	}
 
+	if request.AcceptsIncomplete && b.async {
+		if err := b.engine.Run(instance.ID, op, b.deprovision(d, instance)); err != nil {
+			return nil, err
+		}
+		response.Async = true
+		key := osb.OperationKey(op.Key)
+		response.OperationKey = &key
+		return &response, nil
+	}
+
+	if err := b.engine.RunSync(requestContext(c), instance.ID, op, b.deprovision(d, instance)); err != nil {
+		return nil, err
+	}
 	return &response, nil
 }
 
 func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
-	// Your last-operation business logic goes here
+	var instance *state.Instance
+	err := b.store.View(func(tx state.Tx) error {
+		var err error
+		instance, err = tx.GetInstance(request.InstanceID)
+		return err
+	})
+	if err == state.ErrNotFound {
+		// The instance is gone, which is what a deprovision operation
+		// succeeding looks like.
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode: http.StatusGone,
+		}
+	} else if err != nil {
+		return nil, err
+	}
 
-	return nil, nil
+	response := broker.LastOperationResponse{
+		LastOperationResponse: osb.LastOperationResponse{
+			State: osb.StateSucceeded,
+		},
+	}
+	if op := instance.LastOperation; op != nil {
+		response.State = op.State
+		if op.Description != "" {
+			description := op.Description
+			response.Description = &description
+		}
+		if op.State == osb.StateInProgress && c != nil && c.Writer != nil {
+			c.Writer.Header().Set("Retry-After", strconv.Itoa(retryAfter(op, time.Now())))
+		}
+	}
+
+	return &response, nil
 }
 
 func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
-	// Your bind business logic goes here
+	binding := &state.Binding{
+		ID:         request.BindingID,
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Parameters: request.Parameters,
+		Context:    request.Context,
+		Owner:      request.OriginatingIdentity,
+		Created:    time.Now(),
+	}
 
-	// example implementation:
-	b.Lock()
-	defer b.Unlock()
+	response := broker.BindResponse{}
+	var instance *state.Instance
+	err := b.store.View(func(tx state.Tx) error {
+		var err error
+		instance, err = tx.GetInstance(request.InstanceID)
+		if err == state.ErrNotFound {
+			return osb.HTTPStatusCodeError{
+				StatusCode: http.StatusNotFound,
+			}
+		} else if err != nil {
+			return err
+		}
+		if instance.State != state.InstanceReady {
+			return notReady(instance)
+		}
+		viewer := visibility.NewViewer(request.OriginatingIdentity, request.Context, instanceOrganization(instance))
+		if err := b.catalog.CheckVisible(viewer, instance.ServiceID, instance.PlanID); err != nil {
+			return err
+		}
 
-	instance, ok := b.instances[request.InstanceID]
-	if !ok {
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode: http.StatusNotFound,
+		// Check to see if this is the same binding
+		existing, err := tx.GetBinding(request.InstanceID, request.BindingID)
+		if err == nil {
+			if !sameBinding(existing, binding) {
+				return bindingInUse()
+			}
+			response.Exists = true
+			response.Credentials = existing.Credentials
+			return nil
+		} else if err != state.ErrNotFound {
+			return err
 		}
+		return nil
+	})
+	if err != nil || response.Exists {
+		return &response, err
 	}
 
-	response := broker.BindResponse{
-		BindResponse: osb.BindResponse{
-			Credentials: instance.Params,
-		},
+	d, err := b.catalog.Driver(instance.ServiceID)
+	if err != nil {
+		return nil, err
 	}
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+	credentials, err := d.Bind(requestContext(c), instance, binding)
+	if err != nil {
+		return nil, err
+	}
+	binding.Credentials = credentials
+
+	err = b.store.Update(func(tx state.Tx) error {
+		// Another request may have created the binding while the driver
+		// was creating this one.
+		if _, err := tx.GetBinding(request.InstanceID, request.BindingID); err == nil {
+			return bindingInUse()
+		} else if err != state.ErrNotFound {
+			return err
+		}
+		return tx.PutBinding(binding)
+	})
+	if err != nil {
+		if err := d.Unbind(requestContext(c), instance, binding); err != nil {
+			log.ForRequest(c).With("binding_id", binding.ID, "error", err).Error("unable to delete binding that was not recorded")
+		}
+		return nil, err
 	}
 
+	// The binding is complete, so the response is never asynchronous.
+	response.Credentials = credentials
 	return &response, nil
 }
 
 func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
-	// Your unbind business logic goes here
+	var instance *state.Instance
+	var binding *state.Binding
+	err := b.store.View(func(tx state.Tx) error {
+		var err error
+		binding, err = tx.GetBinding(request.InstanceID, request.BindingID)
+		if err == state.ErrNotFound {
+			// The binding does not exist, or was already deleted.
+			return osb.HTTPStatusCodeError{
+				StatusCode: http.StatusGone,
+			}
+		} else if err != nil {
+			return err
+		}
+		instance, err = tx.GetInstance(request.InstanceID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := b.catalog.Driver(instance.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Unbind(requestContext(c), instance, binding); err != nil {
+		return nil, err
+	}
+
+	err = b.store.Update(func(tx state.Tx) error {
+		err := tx.DeleteBinding(request.InstanceID, request.BindingID)
+		if err == state.ErrNotFound {
+			// A concurrent request already deleted it.
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return &broker.UnbindResponse{}, nil
 }
 
 func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
-	// Your logic for updating a service goes here.
 	response := broker.UpdateInstanceResponse{}
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+	requested, err := requestMaintenanceInfo(c)
+	if err != nil {
+		return nil, err
 	}
 
+	var instance *state.Instance
+	var d driver.Driver
+	var op *state.Operation
+	err = b.store.Update(func(tx state.Tx) error {
+		var err error
+		instance, err = tx.GetInstance(request.InstanceID)
+		if err == state.ErrNotFound {
+			description := "Instance does not exist"
+			return osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusNotFound,
+				Description: &description,
+			}
+		} else if err != nil {
+			return err
+		}
+		if instance.State != state.InstanceReady {
+			return notReady(instance)
+		}
+
+		planID := instance.PlanID
+		if request.PlanID != nil {
+			planID = *request.PlanID
+		}
+		planChanged := planID != instance.PlanID
+		_, _, d, err = b.catalog.Lookup(instance.ServiceID, planID)
+		if err != nil {
+			return err
+		}
+		if planChanged {
+			viewer := visibility.NewViewer(request.OriginatingIdentity, request.Context, instanceOrganization(instance))
+			if err := b.catalog.CheckVisible(viewer, instance.ServiceID, planID); err != nil {
+				return err
+			}
+		}
+		current := b.catalog.MaintenanceInfo(planID)
+		if err := maintenance.Check(requested, current); err != nil {
+			return err
+		}
+		if request.Parameters != nil {
+			instance.Parameters = request.Parameters
+		}
+		next := b.newOperation("update", planID)
+		if planChanged {
+			next.PlanID = planID
+		}
+		// Asking for the plan's maintenance info, or moving to another
+		// plan, brings the instance to the plan's current version.
+		if version := maintenanceVersion(current); (requested != nil || planChanged) && version != instance.MaintenanceVersion {
+			next.MaintenanceVersion = &version
+		}
+		if err := b.claim(instance.ID, next.Key); err != nil {
+			return err
+		}
+		op = next
+		instance.Updated = time.Now()
+		instance.StartOperation(op)
+		return tx.PutInstance(instance)
+	})
+	if err != nil {
+		if op != nil {
+			b.engine.Release(request.InstanceID, op.Key)
+		}
+		return nil, err
+	}
+	if op.MaintenanceVersion != nil {
+		log.ForRequest(c).With("instance_id", instance.ID, "from", instance.MaintenanceVersion, "to", *op.MaintenanceVersion).Info("upgrading instance")
+	}
+
+	if request.AcceptsIncomplete && b.async {
+		if err := b.engine.Run(instance.ID, op, b.update(d, instance)); err != nil {
+			return nil, err
+		}
+		response.Async = true
+		key := osb.OperationKey(op.Key)
+		response.OperationKey = &key
+		return &response, nil
+	}
+
+	if err := b.engine.RunSync(requestContext(c), instance.ID, op, b.update(d, instance)); err != nil {
+		return nil, err
+	}
 	return &response, nil
 }
 
 func (b *BusinessLogic) ValidateBrokerAPIVersion(version string) error {
+	// Platforms must send the version of the API they use; this broker
+	// supports every 2.x version.
+	if version == "" || !strings.HasPrefix(version, "2.") {
+		errorMessage := "UnsupportedAPIVersion"
+		description := fmt.Sprintf("The %s header must name a 2.x API version, got %q", osb.APIVersionHeader, version)
+		return osb.HTTPStatusCodeError{
+			StatusCode:   http.StatusPreconditionFailed,
+			ErrorMessage: &errorMessage,
+			Description:  &description,
+		}
+	}
 	return nil
 }
 
-// example types
+// provision returns the work of provisioning an instance with the driver d.
+func (b *BusinessLogic) provision(d driver.Driver, i *state.Instance) async.Func {
+	return func(ctx context.Context) error {
+		if err := d.Create(ctx, i); err != nil {
+			return err
+		}
+		if i.DashboardURL != "" {
+			err := b.store.Update(func(tx state.Tx) error {
+				instance, err := tx.GetInstance(i.ID)
+				if err != nil {
+					return err
+				}
+				instance.DashboardURL = i.DashboardURL
+				return tx.PutInstance(instance)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return driver.Wait(ctx, d, i, driver.Ready, statusInterval, b.progress(i))
+	}
+}
+
+// update returns the work of updating an instance with the driver d. The
+// driver sees the instance with the plan and maintenance version of its
+// update operation, which are only stored on the instance once it succeeds.
+func (b *BusinessLogic) update(d driver.Driver, i *state.Instance) async.Func {
+	target := *i
+	target.Apply(i.LastOperation)
+	return func(ctx context.Context) error {
+		if err := d.Update(ctx, &target); err != nil {
+			return err
+		}
+		return driver.Wait(ctx, d, &target, driver.Ready, statusInterval, b.progress(i))
+	}
+}
+
+// deprovision returns the work of deprovisioning an instance with the driver
+// d, which ends by deleting the broker's record of it and its bindings.
+func (b *BusinessLogic) deprovision(d driver.Driver, i *state.Instance) async.Func {
+	return func(ctx context.Context) error {
+		if err := d.Delete(ctx, i); err != nil {
+			return err
+		}
+		if err := driver.Wait(ctx, d, i, driver.Gone, statusInterval, b.progress(i)); err != nil {
+			return err
+		}
+		return b.store.Update(func(tx state.Tx) error {
+			return tx.DeleteInstance(i.ID)
+		})
+	}
+}
+
+// cleanup returns the work of cleaning up whatever resources a failed provision
+// or deprovision left behind. The broker's record of the instance stays until
+// the platform deprovisions it.
+func (b *BusinessLogic) cleanup(i *state.Instance) async.Func {
+	return func(ctx context.Context) error {
+		d, err := b.catalog.Driver(i.ServiceID)
+		if err != nil {
+			return err
+		}
+		if err := d.Delete(ctx, i); err != nil {
+			return err
+		}
+		return driver.Wait(ctx, d, i, driver.Gone, statusInterval, nil)
+	}
+}
+
+// newOperation returns a new operation of the given type on an instance of
+// the plan with the given ID, which must finish within the plan's maximum
+// polling duration.
+func (b *BusinessLogic) newOperation(opType, planID string) *state.Operation {
+	op := async.NewOperation(opType)
+	if d := b.catalog.MaximumPollingDuration(planID); d > 0 {
+		deadline := op.Started.Add(d)
+		op.Deadline = &deadline
+	}
+	return op
+}
+
+// progress returns a func that records the status of the resources of an
+// instance as the description of its operation while the operation runs.
+func (b *BusinessLogic) progress(i *state.Instance) func(s *driver.Status) {
+	key := i.LastOperation.Key
+	return func(s *driver.Status) {
+		err := b.store.Update(func(tx state.Tx) error {
+			instance, err := tx.GetInstance(i.ID)
+			if err != nil {
+				return err
+			}
+			op := instance.LastOperation
+			if op == nil || op.Key != key || op.State != osb.StateInProgress || op.Description == s.Description {
+				return nil
+			}
+			updated := *op
+			updated.Description = s.Description
+			instance.LastOperation = &updated
+			return tx.PutInstance(instance)
+		})
+		if err != nil && err != state.ErrNotFound {
+			log.With("instance_id", i.ID, "operation", key, "error", err).Error("unable to record progress of operation")
+		}
+	}
+}
+
+// resume returns the work that completes an operation that was interrupted
+// when the broker last shut down.
+func (b *BusinessLogic) resume(i *state.Instance) async.Func {
+	d, err := b.catalog.Driver(i.ServiceID)
+	if err != nil {
+		return func(ctx context.Context) error {
+			return err
+		}
+	}
+	switch i.LastOperation.Type {
+	case "provision":
+		return b.provision(d, i)
+	case "update":
+		return b.update(d, i)
+	case "deprovision":
+		return b.deprovision(d, i)
+	}
+	return func(ctx context.Context) error {
+		return fmt.Errorf("unable to resume unknown operation %q", i.LastOperation.Type)
+	}
+}
+
+// requestContext returns the context of the request c, which synchronous
+// operations run with.
+func requestContext(c *broker.RequestContext) context.Context {
+	if c == nil || c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
+
+// retryAfter returns the number of seconds a platform should wait before
+// polling the in-progress operation op again: a quarter of the time it has
+// been running, between statusInterval and maxRetryAfter, but no later than
+// its deadline.
+func retryAfter(op *state.Operation, now time.Time) int {
+	after := now.Sub(op.Started) / 4
+	if after < statusInterval {
+		after = statusInterval
+	}
+	if after > maxRetryAfter {
+		after = maxRetryAfter
+	}
+	if op.Deadline != nil {
+		if left := op.Deadline.Sub(now); left < after {
+			after = left
+		}
+	}
+	seconds := int((after + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// requestMaintenanceInfo returns the maintenance_info of the request c, if it
+// has one.
+func requestMaintenanceInfo(c *broker.RequestContext) (*maintenance.Info, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return maintenance.FromRequest(c.Request)
+}
+
+// maintenanceVersion returns the version of the maintenance info of a plan, or
+// "" if it has none.
+func maintenanceVersion(info *maintenance.Info) string {
+	if info == nil {
+		return ""
+	}
+	return info.Version
+}
+
+// claim claims the instance with the given ID for the operation with the
+// given key in the store transaction that starts the operation, so that no
+// other operation can start on the instance until it finishes.
+func (b *BusinessLogic) claim(instanceID, key string) error {
+	err := b.engine.Claim(instanceID, key)
+	if err == async.ErrRunning {
+		return concurrencyError("Another operation on the instance is in progress")
+	}
+	return err
+}
+
+// concurrencyError returns the error the OSB API specifies for a request on an
+// instance that another operation is still acting on.
+func concurrencyError(description string) error {
+	errorMessage := "ConcurrencyError"
+	return osb.HTTPStatusCodeError{
+		StatusCode:   http.StatusUnprocessableEntity,
+		ErrorMessage: &errorMessage,
+		Description:  &description,
+	}
+}
+
+// instanceOrganization returns the Cloud Foundry organization an instance
+// was provisioned into.
+func instanceOrganization(i *state.Instance) string {
+	org, _ := quota.OrganizationAndSpace(i)
+	return org
+}
+
+// notReady returns the error for a request that needs the instance i to be
+// ready when it is not.
+func notReady(i *state.Instance) error {
+	switch i.State {
+	case state.InstanceCreating:
+		return concurrencyError("The instance is still being provisioned")
+	case state.InstanceDeleting:
+		return concurrencyError("The instance is being deprovisioned")
+	}
+	description := "The instance failed; deprovision it"
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusUnprocessableEntity,
+		Description: &description,
+	}
+}
+
+func bindingInUse() error {
+	description := "BindingID in use"
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusConflict,
+		Description: &description,
+	}
+}
 
-// exampleInstance is intended as an example of a type that holds information about a service instance
-type exampleInstance struct {
-	ID        string
-	ServiceID string
-	PlanID    string
-	Params    map[string]interface{}
+// sameInstance reports whether a provision request for other would create the
+// same instance as the existing instance i.
+func sameInstance(i, other *state.Instance) bool {
+	return i.ServiceID == other.ServiceID &&
+		i.PlanID == other.PlanID &&
+		reflect.DeepEqual(i.Parameters, other.Parameters)
 }
 
-func (i *exampleInstance) Match(other *exampleInstance) bool {
-	return reflect.DeepEqual(i, other)
+// sameBinding reports whether a bind request for other would create the same
+// binding as the existing binding b.
+func sameBinding(b, other *state.Binding) bool {
+	return b.ServiceID == other.ServiceID &&
+		b.PlanID == other.PlanID &&
+		reflect.DeepEqual(b.Parameters, other.Parameters)
 }
@@ -0,0 +1,394 @@
+package broker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/broker"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+	brokertesting "github.com/pmorie/osb-starter-pack/pkg/testing"
+)
+
+// The example service the BusinessLogic always offers.
+const (
+	exampleServiceID = "4f6e6cf6-ffdd-425f-a2c7-3c9258ad246a"
+	examplePlanID    = "86064792-7ea2-467b-af93-ac9694d96d5b"
+)
+
+// The service in the exec directory newBroker writes.
+const (
+	scriptServiceID = "0f8e4a0c-8d7b-4b8e-9d55-6c1fd7b2a3e1"
+	smallPlanID     = "5a1d7c4e-2f3b-4e6a-8c9d-0b1e2f3a4b5c"
+	largePlanID     = "9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b"
+)
+
+// scriptService is the definition of the exec service. Its large plan gets
+// maintenance info from the catalog file.
+const scriptService = `
+name: scripted
+id: ` + scriptServiceID + `
+description: A service run by scripts
+bindable: true
+plan_updateable: true
+plans:
+- name: small
+  id: ` + smallPlanID + `
+  description: The small plan
+- name: large
+  id: ` + largePlanID + `
+  description: The large plan
+`
+
+const catalogFile = `
+services:
+- name: scripted
+  id: ` + scriptServiceID + `
+  description: A service run by scripts
+  bindable: true
+  plan_updateable: true
+  plans:
+  - name: small
+    id: ` + smallPlanID + `
+    description: The small plan
+  - name: large
+    id: ` + largePlanID + `
+    description: The large plan
+    maintenance_info:
+      version: 2.0.0
+`
+
+// scripts are the scripts of the exec service. Its resources stay pending
+// while the service directory has a file named pending, and updates fail
+// while it has one named fail-update.
+var scripts = map[string]string{
+	"provision":   "#!/bin/sh\nexit 0\n",
+	"deprovision": "#!/bin/sh\nexit 0\n",
+	"update":      "#!/bin/sh\n[ -e fail-update ] && exit 1\nexit 0\n",
+	"bind":        "#!/bin/sh\necho '{\"credentials\":{\"user\":\"scripted\"}}'\n",
+	"status": `#!/bin/sh
+if [ -e pending ]; then
+	echo '{"state":"pending","description":"waiting"}'
+elif [ "$OSB_INSTANCE_STATE" = deleting ]; then
+	echo '{"state":"gone"}'
+else
+	echo '{"state":"ready"}'
+fi
+`,
+}
+
+// testBroker is a BusinessLogic served by the test harness, with the exec
+// service in dir.
+type testBroker struct {
+	*brokertesting.Server
+	logic *broker.BusinessLogic
+	dir   string
+}
+
+func newBroker(t *testing.T, async bool) *testBroker {
+	root, err := ioutil.TempDir("", "broker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "exec", "scripted")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(dir, "service.yaml"), scriptService, 0644)
+	for name, script := range scripts {
+		write(t, filepath.Join(dir, name), script, 0755)
+	}
+	catalog := filepath.Join(root, "catalog.yaml")
+	write(t, catalog, catalogFile, 0644)
+
+	logic, err := broker.NewBusinessLogic(broker.Options{
+		Async:       async,
+		ExecDir:     filepath.Join(root, "exec"),
+		CatalogPath: catalog,
+	}, state.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testBroker{
+		Server: brokertesting.NewServer(t, logic),
+		logic:  logic,
+		dir:    dir,
+	}
+}
+
+// close stops the broker and deletes its files.
+func (b *testBroker) close(t *testing.T) {
+	b.Server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.logic.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+	os.RemoveAll(filepath.Dir(filepath.Dir(b.dir)))
+}
+
+// set creates or removes a file in the exec service's directory that
+// changes what its scripts do.
+func (b *testBroker) set(t *testing.T, name string, on bool) {
+	path := filepath.Join(b.dir, name)
+	if on {
+		write(t, path, "", 0644)
+	} else if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// instance returns the broker's record of an instance.
+func (b *testBroker) instance(t *testing.T, id string) *state.Instance {
+	var instance *state.Instance
+	err := b.logic.Store().View(func(tx state.Tx) error {
+		var err error
+		instance, err = tx.GetInstance(id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unable to get instance %q: %v", id, err)
+	}
+	return instance
+}
+
+func write(t *testing.T, path, content string, mode os.FileMode) {
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wantStatus fails t unless err is an osb.HTTPStatusCodeError with the
+// given status code.
+func wantStatus(t *testing.T, what string, err error, code int) {
+	e, ok := err.(osb.HTTPStatusCodeError)
+	if !ok || e.StatusCode != code {
+		t.Fatalf("%s returned %v, want status %d", what, err, code)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		b := newBroker(t, async)
+
+		instance := b.Provision(t, &osb.ProvisionRequest{
+			ServiceID:  exampleServiceID,
+			PlanID:     examplePlanID,
+			Parameters: map[string]interface{}{"color": "Grey"},
+		})
+		binding := b.Bind(t, &osb.BindRequest{
+			InstanceID: instance.InstanceID,
+			ServiceID:  instance.ServiceID,
+			PlanID:     instance.PlanID,
+		})
+		if color := binding.Response.Credentials["color"]; color != "Grey" {
+			t.Errorf("async %v: got credentials %v, want the instance's parameters", async, binding.Response.Credentials)
+		}
+		b.Unbind(t, &osb.UnbindRequest{
+			InstanceID: instance.InstanceID,
+			BindingID:  binding.BindingID,
+			ServiceID:  instance.ServiceID,
+			PlanID:     instance.PlanID,
+		})
+		b.Deprovision(t, &osb.DeprovisionRequest{
+			InstanceID: instance.InstanceID,
+			ServiceID:  instance.ServiceID,
+			PlanID:     instance.PlanID,
+		})
+
+		_, err := b.Client.PollLastOperation(&osb.LastOperationRequest{InstanceID: instance.InstanceID})
+		wantStatus(t, "polling a deprovisioned instance", err, http.StatusGone)
+		b.close(t)
+	}
+}
+
+func TestAsyncOperation(t *testing.T) {
+	b := newBroker(t, true)
+	defer b.close(t)
+
+	b.set(t, "pending", true)
+	request := &osb.ProvisionRequest{
+		InstanceID:        "pending",
+		ServiceID:         scriptServiceID,
+		PlanID:            smallPlanID,
+		OrganizationGUID:  "org",
+		SpaceGUID:         "space",
+		AcceptsIncomplete: true,
+	}
+	response, err := b.Client.ProvisionInstance(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Async || response.OperationKey == nil {
+		t.Fatalf("provision returned %+v, want an asynchronous operation", response)
+	}
+
+	// Repeating the request returns the operation that is running.
+	again, err := b.Client.ProvisionInstance(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.OperationKey == nil || *again.OperationKey != *response.OperationKey {
+		t.Errorf("repeated provision returned %+v, want operation %q", again, *response.OperationKey)
+	}
+	last, err := b.Client.PollLastOperation(&osb.LastOperationRequest{InstanceID: request.InstanceID, OperationKey: response.OperationKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.State != osb.StateInProgress {
+		t.Errorf("got operation state %q while the resources are pending", last.State)
+	}
+
+	b.set(t, "pending", false)
+	if last := b.WaitForOperation(t, request.InstanceID, response.OperationKey); last.State != osb.StateSucceeded {
+		t.Fatalf("provision %s", last.State)
+	}
+}
+
+func TestRepeatedDeprovision(t *testing.T) {
+	b := newBroker(t, true)
+	defer b.close(t)
+
+	instance := b.Provision(t, &osb.ProvisionRequest{ServiceID: scriptServiceID, PlanID: smallPlanID})
+	b.set(t, "pending", true)
+	request := &osb.DeprovisionRequest{
+		InstanceID:        instance.InstanceID,
+		ServiceID:         instance.ServiceID,
+		PlanID:            instance.PlanID,
+		AcceptsIncomplete: true,
+	}
+	first, err := b.Client.DeprovisionInstance(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.Client.DeprovisionInstance(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Async || second.OperationKey == nil || *second.OperationKey != *first.OperationKey {
+		t.Errorf("repeated deprovision returned %+v, want operation %q", second, *first.OperationKey)
+	}
+
+	// No other operation can start while the deprovision runs.
+	_, err = b.Client.UpdateInstance(&osb.UpdateInstanceRequest{
+		InstanceID:        instance.InstanceID,
+		ServiceID:         instance.ServiceID,
+		AcceptsIncomplete: true,
+	})
+	wantStatus(t, "updating an instance being deprovisioned", err, http.StatusUnprocessableEntity)
+
+	b.set(t, "pending", false)
+	b.Deprovision(t, request)
+}
+
+func TestUpdatePlan(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		b := newBroker(t, async)
+
+		instance := b.Provision(t, &osb.ProvisionRequest{ServiceID: scriptServiceID, PlanID: smallPlanID})
+		large := largePlanID
+		request := &osb.UpdateInstanceRequest{
+			InstanceID:        instance.InstanceID,
+			ServiceID:         instance.ServiceID,
+			PlanID:            &large,
+			AcceptsIncomplete: true,
+		}
+
+		// A failed update leaves the instance on its plan and version.
+		b.set(t, "fail-update", true)
+		response, err := b.Client.UpdateInstance(request)
+		if async {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := b.WaitForOperation(t, instance.InstanceID, response.OperationKey); last.State != osb.StateFailed {
+				t.Fatalf("async %v: update %s, want failed", async, last.State)
+			}
+		} else if err == nil {
+			t.Fatalf("async %v: update succeeded, want it to fail", async)
+		}
+		if i := b.instance(t, instance.InstanceID); i.PlanID != smallPlanID || i.MaintenanceVersion != "" {
+			t.Errorf("async %v: after a failed update, got plan %q and version %q, want %q and none", async, i.PlanID, i.MaintenanceVersion, smallPlanID)
+		}
+
+		// A successful one moves it to the new plan and its version.
+		b.set(t, "fail-update", false)
+		b.Update(t, request)
+		if i := b.instance(t, instance.InstanceID); i.PlanID != largePlanID || i.MaintenanceVersion != "2.0.0" {
+			t.Errorf("async %v: after the update, got plan %q and version %q, want %q and 2.0.0", async, i.PlanID, i.MaintenanceVersion, largePlanID)
+		}
+		b.close(t)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	b := newBroker(t, false)
+	defer b.close(t)
+
+	instance := b.Provision(t, &osb.ProvisionRequest{ServiceID: exampleServiceID, PlanID: examplePlanID})
+
+	// Provisioning the same instance again succeeds; provisioning another
+	// one with its ID conflicts.
+	if _, err := b.Client.ProvisionInstance(instance.ProvisionRequest); err != nil {
+		t.Errorf("repeating a provision returned %v", err)
+	}
+	other := *instance.ProvisionRequest
+	other.Parameters = map[string]interface{}{"color": "Beige"}
+	_, err := b.Client.ProvisionInstance(&other)
+	wantStatus(t, "provisioning another instance with the same ID", err, http.StatusConflict)
+
+	binding := b.Bind(t, &osb.BindRequest{
+		InstanceID: instance.InstanceID,
+		ServiceID:  instance.ServiceID,
+		PlanID:     instance.PlanID,
+	})
+	if _, err := b.Client.Bind(binding.BindRequest); err != nil {
+		t.Errorf("repeating a bind returned %v", err)
+	}
+	otherBinding := *binding.BindRequest
+	otherBinding.Parameters = map[string]interface{}{"role": "admin"}
+	_, err = b.Client.Bind(&otherBinding)
+	wantStatus(t, "creating another binding with the same ID", err, http.StatusConflict)
+}
+
+func TestGone(t *testing.T) {
+	b := newBroker(t, false)
+	defer b.close(t)
+
+	// The OSB client reports 410 Gone to deprovision and unbind requests as
+	// success, so these are sent directly.
+	query := "?service_id=" + exampleServiceID + "&plan_id=" + examplePlanID
+	if code := b.delete(t, "/v2/service_instances/missing"+query); code != http.StatusGone {
+		t.Errorf("deprovisioning an unknown instance returned %d, want 410", code)
+	}
+
+	instance := b.Provision(t, &osb.ProvisionRequest{ServiceID: exampleServiceID, PlanID: examplePlanID})
+	if code := b.delete(t, "/v2/service_instances/"+instance.InstanceID+"/service_bindings/missing"+query); code != http.StatusGone {
+		t.Errorf("unbinding an unknown binding returned %d, want 410", code)
+	}
+
+	_, err := b.Client.PollLastOperation(&osb.LastOperationRequest{InstanceID: "missing"})
+	wantStatus(t, "polling an unknown instance", err, http.StatusGone)
+}
+
+// delete sends a DELETE request for path to the broker and returns the
+// status code of the response.
+func (b *testBroker) delete(t *testing.T, path string) int {
+	req, err := http.NewRequest(http.MethodDelete, b.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(osb.APIVersionHeader, osb.LatestAPIVersion().HeaderValue())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
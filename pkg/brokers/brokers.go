@@ -0,0 +1,158 @@
+// Package brokers serves several brokers from one process. Each is served
+// under its own path prefix, for example /team-a/v2/catalog, with its own
+// middleware, such as authentication, and its own value of the broker label
+// on the osb_actions_total metric. One deployment can then be registered with
+// a platform as several brokers, for example as several Kubernetes
+// ClusterServiceBrokers.
+package brokers // import "github.com/pmorie/osb-starter-pack/pkg/brokers"
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"github.com/pmorie/osb-broker-lib/pkg/metrics"
+	"github.com/pmorie/osb-broker-lib/pkg/rest"
+	"github.com/pmorie/osb-broker-lib/pkg/server"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Broker is one of the brokers served by Mount.
+type Broker struct {
+	// Name is the broker's value of the broker label on metrics. Only a
+	// broker served on its own may have no name, and then its metrics have
+	// no broker label.
+	Name string
+	// Prefix is the path the broker's OSB API is served under, such as
+	// "/team-a". A broker with an empty prefix is served from the root, and
+	// must be the only broker.
+	Prefix string
+	// Interface is the broker's business logic.
+	Interface broker.Interface
+	// Middleware wraps the broker's handler, outermost first, for example to
+	// authenticate its requests. It sees paths with the prefix removed.
+	Middleware []func(http.Handler) http.Handler
+}
+
+// validPrefix matches the prefixes brokers can be served under.
+var validPrefix = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// Mount serves each broker's OSB API from router under its prefix and
+// registers the metrics of its requests with reg. Register any other routes,
+// such as /metrics, before mounting a broker with an empty prefix.
+func Mount(router *mux.Router, reg prom.Registerer, brokers []Broker) error {
+	prefixes := map[string]bool{}
+	names := map[string]bool{}
+	for _, b := range brokers {
+		switch {
+		case b.Name == "" && len(brokers) > 1:
+			return fmt.Errorf("brokers served with other brokers must have a name")
+		case b.Prefix == "" && len(brokers) > 1:
+			return fmt.Errorf("broker %q must have a prefix to be served with other brokers", b.Name)
+		case b.Prefix != "" && !validPrefix.MatchString(b.Prefix):
+			return fmt.Errorf("prefix %q of broker %q must be a path like /team-a", b.Prefix, b.Name)
+		case prefixes[b.Prefix]:
+			return fmt.Errorf("more than one broker is served under prefix %q", b.Prefix)
+		case names[b.Name]:
+			return fmt.Errorf("more than one broker is named %q", b.Name)
+		}
+		prefixes[b.Prefix] = true
+		names[b.Name] = true
+	}
+
+	for _, b := range brokers {
+		m := newMetrics(b.Name)
+		if err := reg.Register(m); err != nil {
+			return fmt.Errorf("unable to register metrics of broker %q: %v", b.Name, err)
+		}
+		api, err := rest.NewAPISurface(b.Interface, m)
+		if err != nil {
+			return err
+		}
+		handler := server.NewHTTPHandler(api)
+		for i := len(b.Middleware) - 1; i >= 0; i-- {
+			handler = b.Middleware[i](handler)
+		}
+
+		if b.Prefix == "" {
+			router.PathPrefix("/").Handler(handler)
+			continue
+		}
+		router.PathPrefix(b.Prefix + "/").Handler(http.StripPrefix(b.Prefix, handler))
+	}
+	return nil
+}
+
+// newMetrics returns the metrics of the broker with the given name.
+func newMetrics(name string) *metrics.OSBMetricsCollector {
+	m := metrics.New()
+	if name == "" {
+		return m
+	}
+	// The library has no way to label its metrics, so build the same ones
+	// with the broker's name as a constant label.
+	return &metrics.OSBMetricsCollector{
+		Actions: prom.NewCounterVec(prom.CounterOpts{
+			Name:        "osb_actions_total",
+			Help:        "Total amount of actions requested.",
+			ConstLabels: prom.Labels{"broker": name},
+		}, []string{"action"}),
+	}
+}
+
+// BasicAuth returns middleware that requires requests to authenticate with
+// HTTP basic authentication as the given user. The requests it lets through
+// have the user as their Client.
+func BasicAuth(username, password string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			if !ok ||
+				subtle.ConstantTimeCompare([]byte(u), []byte(username)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="service broker"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, withClient(r, "user:"+u))
+		})
+	}
+}
+
+// IdentifyToken wraps middleware that authenticates requests by their bearer
+// token, such as the TokenReviewMiddleware of osb-broker-k8s-lib, so that the
+// requests it lets through have a hash of the token as their Client.
+func IdentifyToken(middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parts := strings.Fields(r.Header.Get("Authorization"))
+			if len(parts) != 2 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sum := sha256.Sum256([]byte(parts[1]))
+			next.ServeHTTP(w, withClient(r, "token:"+hex.EncodeToString(sum[:8])))
+		}))
+	}
+}
+
+// clientKey is the key of the Client of a request in its context.
+type clientKey struct{}
+
+func withClient(r *http.Request, client string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientKey{}, client))
+}
+
+// Client returns the client the authentication middleware of a broker
+// authenticated r as, or "" if none did.
+func Client(r *http.Request) string {
+	client, _ := r.Context().Value(clientKey{}).(string)
+	return client
+}
@@ -0,0 +1,8 @@
+// Package catalog serves the broker's catalog over HTTP and watches the files
+// it is built from. The OSB libraries the broker is built on predate some
+// fields of plans, such as maintenance_info and maximum_polling_duration, so
+// Middleware adds them to the catalog it serves. It serializes the catalog
+// once for each change to it, and serves it with an ETag. A Watcher tells
+// the broker when the files it builds its catalog from change, so it can
+// reload it.
+package catalog // import "github.com/pmorie/osb-starter-pack/pkg/catalog"
@@ -0,0 +1,212 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+)
+
+// Source holds the catalog Middleware serves.
+type Source interface {
+	// VisibleServices returns the services in the catalog with the plans
+	// the viewer of r may see.
+	VisibleServices(r *http.Request) []osb.Service
+	// PlanFields returns the fields to add to the plan with the given ID
+	// that the OSB libraries do not know about, by their JSON names.
+	PlanFields(planID string) map[string]interface{}
+	// Revision returns a number that changes whenever the services or the
+	// fields of their plans do.
+	Revision() uint64
+}
+
+type contextKey struct{}
+
+// Served reports whether Middleware serves the catalog in response to r. The
+// broker's GetCatalog then need not return any services, since Middleware
+// replaces its response with the catalog it has cached.
+func Served(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	served, _ := r.Context().Value(contextKey{}).(bool)
+	return served
+}
+
+// Middleware returns middleware for the broker's HTTP API that serves the
+// catalog in s. The catalog is serialized, with the fields of its plans the
+// OSB libraries do not know about, once for each revision of s and set of
+// plans visible to a request, and served with an ETag so that platforms can
+// skip downloading it again when it has not changed. Requests for the catalog
+// still go through the broker's handler, which checks their API version and
+// counts them, but its response is only used if it is an error.
+func Middleware(s Source) func(http.Handler) http.Handler {
+	c := &cache{source: s}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/v2/catalog") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &recorder{header: http.Header{}, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), contextKey{}, true)))
+
+			body := rec.body.Bytes()
+			var etag string
+			if rec.status == http.StatusOK {
+				var err error
+				body, etag, err = c.get(r)
+				if err != nil {
+					log.With("error", err).Error("unable to serialize the catalog")
+					rec.status = http.StatusInternalServerError
+					body = []byte(`{"description":"The catalog could not be served"}`)
+				}
+			}
+
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+				if matches(r.Header.Get("If-None-Match"), etag) {
+					w.Header().Del("Content-Type")
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(rec.status)
+			w.Write(body)
+		})
+	}
+}
+
+// maxCached is the most serialized catalogs a cache keeps. Catalogs with
+// different plans visible to different viewers are serialized separately.
+const maxCached = 64
+
+// cache holds the serialized catalogs of a revision of its source.
+type cache struct {
+	source Source
+
+	mu       sync.Mutex
+	revision uint64
+	// entries are the serialized catalogs by the IDs of their plans.
+	entries map[string]*entry
+}
+
+// entry is a serialized catalog.
+type entry struct {
+	body []byte
+	etag string
+}
+
+// get returns the serialized catalog visible to r and its ETag, serializing
+// it if the source has changed since it last was.
+func (c *cache) get(r *http.Request) ([]byte, string, error) {
+	// Read the revision first, so that a change to the catalog while it is
+	// read leaves the entry stale rather than the new revision wrong.
+	revision := c.source.Revision()
+	services := c.source.VisibleServices(r)
+	var plans []string
+	for _, s := range services {
+		for _, p := range s.Plans {
+			plans = append(plans, p.ID)
+		}
+	}
+	key := strings.Join(plans, ",")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil || c.revision != revision || len(c.entries) >= maxCached {
+		c.revision = revision
+		c.entries = map[string]*entry{}
+	}
+	if e, ok := c.entries[key]; ok {
+		return e.body, e.etag, nil
+	}
+
+	if services == nil {
+		services = []osb.Service{}
+	}
+	body, err := json.Marshal(&osb.CatalogResponse{Services: services})
+	if err != nil {
+		return nil, "", err
+	}
+	body, err = addPlanFields(body, c.source)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	e := &entry{body: body, etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+	c.entries[key] = e
+	return e.body, e.etag, nil
+}
+
+// matches reports whether the value of an If-None-Match header matches etag.
+func matches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// addPlanFields returns the catalog response body with the fields in s added
+// to its plans.
+func addPlanFields(body []byte, s Source) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	// Keep numbers in metadata as they were written.
+	d.UseNumber()
+	var catalog map[string]interface{}
+	if err := d.Decode(&catalog); err != nil {
+		return nil, err
+	}
+
+	services, _ := catalog["services"].([]interface{})
+	for _, v := range services {
+		service, _ := v.(map[string]interface{})
+		plans, _ := service["plans"].([]interface{})
+		for _, v := range plans {
+			plan, _ := v.(map[string]interface{})
+			id, _ := plan["id"].(string)
+			for k, field := range s.PlanFields(id) {
+				plan[k] = field
+			}
+		}
+	}
+	return json.Marshal(catalog)
+}
+
+// recorder is an http.ResponseWriter that keeps the response for Middleware
+// to replace.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
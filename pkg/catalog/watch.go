@@ -0,0 +1,80 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Watcher calls a function whenever files or directories change. It polls
+// their sizes and modification times, and those of everything in the
+// directories, every Interval.
+type Watcher struct {
+	// Interval is how often the Watcher checks for changes.
+	Interval time.Duration
+
+	paths    []string
+	onChange func()
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewWatcher returns a Watcher that calls onChange whenever anything at
+// paths changes.
+func NewWatcher(onChange func(), paths ...string) *Watcher {
+	return &Watcher{
+		Interval: 10 * time.Second,
+		paths:    paths,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start starts watching in the background.
+func (w *Watcher) Start() {
+	last := snapshot(w.paths)
+	go func() {
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-time.After(w.Interval):
+			}
+			if current := snapshot(w.paths); current != last {
+				last = current
+				w.onChange()
+			}
+		}
+	}()
+}
+
+// Stop stops watching.
+func (w *Watcher) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// snapshot returns a description of the files at paths that changes whenever
+// they do.
+func snapshot(paths []string) string {
+	var s string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Follow symbolic links, such as those Kubernetes swaps to
+			// update the files of a mounted ConfigMap.
+			if target, err := os.Stat(path); err == nil {
+				info = target
+			}
+			s += fmt.Sprintf("%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			s += fmt.Sprintf("%s %v\n", path, err)
+		}
+	}
+	return s
+}
@@ -0,0 +1,272 @@
+// Package config layers a YAML configuration file and environment variables
+// under the broker's command-line flags. Every flag registered on a FlagSet,
+// including the user-defined ones added by broker.AddFlags, can be set by, in
+// increasing order of precedence:
+//
+//   - its default value
+//   - a key with the flag's name in the configuration file
+//   - an environment variable named after the flag, for example OSB_TLS_KEY
+//     for --tlsKey or OSB_TLS_CERT_FILE for --tls-cert-file
+//   - the flag itself
+//
+// A value of the form "file:<path>" is replaced by the contents of the file at
+// path, so secrets such as TLS keys can be mounted as files rather than
+// passed on the command line, where they show up in ps and pod specs.
+package config // import "github.com/pmorie/osb-starter-pack/pkg/config"
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ghodss/yaml"
+)
+
+// Sources of a flag's value.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// FileReferencePrefix marks a value as a reference to a file holding the
+// actual value.
+const FileReferencePrefix = "file:"
+
+// redacted replaces the values of secret flags when the configuration is
+// printed.
+const redacted = "REDACTED"
+
+var secrets = map[string]bool{}
+
+// MarkSecret marks the flags with the given names as holding secrets, so their
+// values are redacted when the configuration is printed.
+func MarkSecret(names ...string) {
+	for _, name := range names {
+		secrets[name] = true
+	}
+}
+
+// Config records where the value of every flag in a FlagSet came from.
+type Config struct {
+	fs      *flag.FlagSet
+	sources map[string]string
+	// references holds the file each value read from a file reference came
+	// from, by flag name.
+	references map[string]string
+}
+
+// Apply sets the flags in fs that were not set on the command line from
+// environment variables with the given prefix and then from the
+// configuration file named by the flag configFlag, if it is set. It must be
+// called after fs is parsed.
+func Apply(fs *flag.FlagSet, envPrefix, configFlag string) (*Config, error) {
+	c := &Config{
+		fs:         fs,
+		sources:    map[string]string{},
+		references: map[string]string{},
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		c.sources[f.Name] = SourceDefault
+	})
+	fs.Visit(func(f *flag.Flag) {
+		c.sources[f.Name] = SourceFlag
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || c.sources[f.Name] != SourceDefault {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(envPrefix, f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, EnvName(envPrefix, f.Name), setErr)
+				return
+			}
+			c.sources[f.Name] = SourceEnv
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if f := fs.Lookup(configFlag); f != nil && f.Value.String() != "" {
+		if err := c.applyFile(f.Value.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.resolveReferences(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ApplyValues sets the flags in fs from values, keyed by flag name, as if
+// they were read from a configuration file; in is where they were read from,
+// for error messages. Values may be file references. The flags must not have
+// been set on the command line.
+func ApplyValues(fs *flag.FlagSet, values map[string]interface{}, in string) (*Config, error) {
+	c := &Config{
+		fs:         fs,
+		sources:    map[string]string{},
+		references: map[string]string{},
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		c.sources[f.Name] = SourceDefault
+	})
+	if err := c.applyValues(values, in); err != nil {
+		return nil, err
+	}
+	if err := c.resolveReferences(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) applyFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("unable to parse config file %q: %v", path, err)
+	}
+	return c.applyValues(values, fmt.Sprintf("config file %q", path))
+}
+
+// applyValues sets the flags that are still at their defaults from values,
+// which were read from in.
+func (c *Config) applyValues(values map[string]interface{}, in string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if c.fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q in %s", name, in)
+		}
+		if c.sources[name] != SourceDefault {
+			continue
+		}
+
+		value, err := scalar(values[name])
+		if err != nil {
+			return fmt.Errorf("invalid value for %q in %s: %v", name, in, err)
+		}
+		if err := c.fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %q in %s: %v", value, name, in, err)
+		}
+		c.sources[name] = SourceFile
+	}
+	return nil
+}
+
+// scalar converts a value decoded from YAML into the string form a flag
+// accepts.
+func scalar(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("must be a string, number or boolean")
+}
+
+func (c *Config) resolveReferences() error {
+	var err error
+	c.fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if err != nil || !strings.HasPrefix(value, FileReferencePrefix) {
+			return
+		}
+
+		path := strings.TrimPrefix(value, FileReferencePrefix)
+		data, readErr := ioutil.ReadFile(path)
+		if readErr != nil {
+			err = fmt.Errorf("unable to read value of %q: %v", f.Name, readErr)
+			return
+		}
+		if setErr := c.fs.Set(f.Name, strings.TrimRight(string(data), "\r\n")); setErr != nil {
+			err = fmt.Errorf("invalid value in %q for %q: %v", path, f.Name, setErr)
+			return
+		}
+		c.references[f.Name] = path
+	})
+	return err
+}
+
+// Source returns where the value of the named flag came from.
+func (c *Config) Source(name string) string {
+	return c.sources[name]
+}
+
+// Print writes the effective configuration to w as YAML that can be used as a
+// configuration file, with the values of secret flags redacted and the source
+// of each value in a comment.
+func (c *Config) Print(w io.Writer) error {
+	var err error
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		var value string
+		switch {
+		case secrets[f.Name] && f.Value.String() != "":
+			value = redacted
+		case c.references[f.Name] != "":
+			value = FileReferencePrefix + c.references[f.Name]
+		default:
+			value = f.Value.String()
+		}
+
+		quoted, marshalErr := yaml.Marshal(value)
+		if marshalErr != nil {
+			err = marshalErr
+			return
+		}
+		_, err = fmt.Fprintf(w, "%s: %s  # %s\n", f.Name, strings.TrimSpace(string(quoted)), c.sources[f.Name])
+	})
+	return err
+}
+
+// EnvName returns the name of the environment variable for the flag with the
+// given name: the prefix followed by the flag's name in upper snake case.
+func EnvName(prefix, name string) string {
+	var b bytes.Buffer
+	b.WriteString(prefix)
+
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == '.':
+			b.WriteRune('_')
+		case unicode.IsUpper(r):
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envPrefix is the prefix of the environment variables the tests set, so that
+// they do not pick up the broker's.
+const envPrefix = "OSB_CONFIG_TEST_"
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setenv(t *testing.T, name, value string) {
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newFlagSet returns a FlagSet with a config flag and four string flags,
+// parsed from args.
+func newFlagSet(t *testing.T, args ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.String("default", "default", "")
+	fs.String("file", "default", "")
+	fs.String("env", "default", "")
+	fs.String("flag", "default", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestPrecedence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, "file: file\nenv: file\nflag: file\n")
+
+	setenv(t, envPrefix+"ENV", "env")
+	setenv(t, envPrefix+"FLAG", "env")
+	defer os.Unsetenv(envPrefix + "ENV")
+	defer os.Unsetenv(envPrefix + "FLAG")
+
+	fs := newFlagSet(t, "--config", path, "--flag", "flag")
+	c, err := Apply(fs, envPrefix, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{SourceDefault, SourceFile, SourceEnv, SourceFlag} {
+		if value := fs.Lookup(name).Value.String(); value != name {
+			t.Errorf("%s: got value %q, want %q", name, value, name)
+		}
+		if source := c.Source(name); source != name {
+			t.Errorf("%s: got source %q, want %q", name, source, name)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{
+		"unknown option": "unknown: value\n",
+		"invalid value":  "file: [a, b]\n",
+		"invalid YAML":   "file: [\n",
+	} {
+		path := filepath.Join(dir, "config.yaml")
+		writeFile(t, path, data)
+		if _, err := Apply(newFlagSet(t, "--config", path), envPrefix, "config"); err == nil {
+			t.Errorf("%s: Apply succeeded", name)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 0, "")
+	setenv(t, envPrefix+"PORT", "not a number")
+	defer os.Unsetenv(envPrefix + "PORT")
+	if _, err := Apply(fs, envPrefix, "config"); err == nil || !strings.Contains(err.Error(), envPrefix+"PORT") {
+		t.Errorf("got error %v for an invalid environment variable, want one naming it", err)
+	}
+}
+
+func TestFileReferences(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret")
+	writeFile(t, secret, "s3cret\n")
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, "file: file:"+secret+"\n")
+
+	setenv(t, envPrefix+"ENV", FileReferencePrefix+secret)
+	defer os.Unsetenv(envPrefix + "ENV")
+
+	fs := newFlagSet(t, "--config", path, "--flag", FileReferencePrefix+secret)
+	if _, err := Apply(fs, envPrefix, "config"); err != nil {
+		t.Fatal(err)
+	}
+	// References are resolved wherever the value came from, and the trailing
+	// newline is dropped.
+	for _, name := range []string{SourceFile, SourceEnv, SourceFlag} {
+		if value := fs.Lookup(name).Value.String(); value != "s3cret" {
+			t.Errorf("%s: got value %q, want the contents of the file", name, value)
+		}
+	}
+
+	fs = newFlagSet(t, "--flag", FileReferencePrefix+filepath.Join(dir, "missing"))
+	if _, err := Apply(fs, envPrefix, "config"); err == nil {
+		t.Error("Apply succeeded with a reference to a missing file")
+	}
+}
+
+func TestPrint(t *testing.T) {
+	defer func() { delete(secrets, "secret"); delete(secrets, "referenced") }()
+	MarkSecret("secret", "referenced")
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reference")
+	writeFile(t, path, "from file")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("plain", "", "")
+	fs.String("secret", "", "")
+	fs.String("referenced", "", "")
+	fs.String("unset", "", "")
+	fs.String("reference", "", "")
+	if err := fs.Parse([]string{
+		"--plain", "value",
+		"--secret", "s3cret",
+		"--referenced", FileReferencePrefix + path,
+		"--reference", FileReferencePrefix + path,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Apply(fs, envPrefix, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := c.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	// Secrets are redacted even if they came from a file, references to
+	// other values are printed rather than the values, and flags are printed
+	// in name order.
+	want := `plain: value  # flag
+reference: file:` + path + `  # flag
+referenced: REDACTED  # flag
+secret: REDACTED  # flag
+unset: ""  # default
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	if strings.Contains(out.String(), "s3cret") || strings.Contains(out.String(), "from file") {
+		t.Error("the printed configuration holds a secret value")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"port":          "OSB_PORT",
+		"tlsKey":        "OSB_TLS_KEY",
+		"tls-cert-file": "OSB_TLS_CERT_FILE",
+		"v":             "OSB_V",
+		"log.level":     "OSB_LOG_LEVEL",
+		"s3Bucket":      "OSB_S3_BUCKET",
+	} {
+		if got := EnvName("OSB_", name); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
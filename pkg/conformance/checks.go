@@ -0,0 +1,536 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/uuid"
+)
+
+// catalog is the part of a catalog the checks validate. Pointers tell missing
+// required fields apart from zero values.
+type catalog struct {
+	Services []struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Bindable    *bool  `json:"bindable"`
+		Plans       []struct {
+			ID          string `json:"id"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Bindable    *bool  `json:"bindable"`
+		} `json:"plans"`
+	} `json:"services"`
+}
+
+// target is the service and plan the lifecycle checks act on.
+type target struct {
+	serviceID string
+	planID    string
+	bindable  bool
+}
+
+func (r *runner) run() {
+	r.checkVersionHeader()
+
+	t, err := r.checkCatalog()
+	if err != nil {
+		for _, name := range lifecycleChecks {
+			r.skip(name, specCatalog, err.Error())
+		}
+	} else {
+		r.checkLifecycle(t)
+	}
+
+	r.check("deprovisioning an instance that does not exist returns 410 Gone", specDeprovisioning, func() error {
+		resp, err := r.send(func() error {
+			_, err := r.client.DeprovisionInstance(&osb.DeprovisionRequest{
+				InstanceID: uuid.New(),
+				ServiceID:  "conformance-unknown-service",
+				PlanID:     "conformance-unknown-plan",
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return expect(resp, http.StatusGone)
+	})
+
+	r.checkErrorBodies()
+}
+
+// Names of the checks that act on a provisioned instance.
+const (
+	checkProvision          = "provisioning a new instance returns 201 Created, or 202 Accepted when accepts_incomplete=true"
+	checkProvisionPoll      = "polling last_operation returns 200 OK with a valid state until provisioning succeeds"
+	checkProvisionIdentical = "repeating an identical provision request returns 200 OK"
+	checkProvisionConflict  = "provisioning an existing instance ID with different parameters returns 409 Conflict"
+	checkBind               = "binding returns 201 Created with a JSON object body"
+	checkBindIdentical      = "repeating an identical bind request returns 200 OK"
+	checkBindConflict       = "binding an existing binding ID with different parameters returns 409 Conflict"
+	checkUnbind             = "unbinding returns 200 OK"
+	checkUnbindGone         = "unbinding a binding that was already deleted returns 410 Gone"
+	checkDeprovision        = "deprovisioning returns 200 OK, or 202 Accepted when accepts_incomplete=true"
+	checkDeprovisionPoll    = "polling last_operation after deprovisioning ends in 410 Gone or succeeded"
+	checkDeprovisionGone    = "deprovisioning an instance that was already deprovisioned returns 410 Gone"
+)
+
+var lifecycleChecks = []string{
+	checkProvision, checkProvisionPoll, checkProvisionIdentical, checkProvisionConflict,
+	checkBind, checkBindIdentical, checkBindConflict, checkUnbind, checkUnbindGone,
+	checkDeprovision, checkDeprovisionPoll, checkDeprovisionGone,
+}
+
+// checkVersionHeader checks how the broker handles the version header. The
+// OSB client always sends the header, so these requests are sent directly.
+func (r *runner) checkVersionHeader() {
+	r.check("requests without the X-Broker-API-Version header are rejected with 412 Precondition Failed", specVersionHeader, func() error {
+		resp, err := r.do(http.MethodGet, "/v2/catalog", "")
+		if err != nil {
+			return err
+		}
+		return expect(resp, http.StatusPreconditionFailed)
+	})
+	r.check("requests for an unsupported major API version are rejected with 412 Precondition Failed", specVersionHeader, func() error {
+		resp, err := r.do(http.MethodGet, "/v2/catalog", "1.0")
+		if err != nil {
+			return err
+		}
+		return expect(resp, http.StatusPreconditionFailed)
+	})
+}
+
+// checkCatalog validates the catalog and returns the plan to run the
+// lifecycle checks against, or the reason they cannot run.
+func (r *runner) checkCatalog() (*target, error) {
+	var cat catalog
+	ok := r.check("GET /v2/catalog returns 200 OK with a valid catalog", specCatalog, func() error {
+		resp, err := r.send(func() error {
+			_, err := r.client.GetCatalog()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := expectParsed(resp, http.StatusOK); err != nil {
+			return err
+		}
+		// The client's catalog does not tell missing required fields
+		// apart from zero values, so the catalog is validated as sent.
+		if err := json.Unmarshal(resp.body, &cat); err != nil {
+			return fmt.Errorf("unable to parse catalog: %v", err)
+		}
+		return validateCatalog(&cat)
+	})
+	if !ok {
+		return nil, fmt.Errorf("the catalog is not valid")
+	}
+
+	for _, s := range cat.Services {
+		if r.config.ServiceID != "" && s.ID != r.config.ServiceID {
+			continue
+		}
+		for _, p := range s.Plans {
+			if r.config.PlanID != "" && p.ID != r.config.PlanID {
+				continue
+			}
+			bindable := *s.Bindable
+			if p.Bindable != nil {
+				bindable = *p.Bindable
+			}
+			if r.config.PlanID == "" && !bindable {
+				continue
+			}
+			return &target{serviceID: s.ID, planID: p.ID, bindable: bindable}, nil
+		}
+	}
+	if r.config.ServiceID != "" || r.config.PlanID != "" {
+		return nil, fmt.Errorf("the catalog has no plan %q of service %q", r.config.PlanID, r.config.ServiceID)
+	}
+	return nil, fmt.Errorf("the catalog has no bindable plan")
+}
+
+func validateCatalog(cat *catalog) error {
+	var problems []string
+	ids := map[string]bool{}
+	names := map[string]bool{}
+	unique := func(id, what string) {
+		if id == "" {
+			return
+		}
+		if ids[id] {
+			problems = append(problems, fmt.Sprintf("%s ID %q is not unique", what, id))
+		}
+		ids[id] = true
+	}
+
+	if cat.Services == nil {
+		problems = append(problems, "services is missing")
+	}
+	for i, s := range cat.Services {
+		where := fmt.Sprintf("services[%d]", i)
+		if s.ID == "" {
+			problems = append(problems, where+": id is required")
+		}
+		if s.Name == "" {
+			problems = append(problems, where+": name is required")
+		} else if names[s.Name] {
+			problems = append(problems, fmt.Sprintf("%s: name %q is not unique", where, s.Name))
+		}
+		names[s.Name] = true
+		if s.Description == "" {
+			problems = append(problems, where+": description is required")
+		}
+		if s.Bindable == nil {
+			problems = append(problems, where+": bindable is required")
+		}
+		if len(s.Plans) == 0 {
+			problems = append(problems, where+": at least one plan is required")
+		}
+		unique(s.ID, "service")
+
+		planNames := map[string]bool{}
+		for j, p := range s.Plans {
+			where := fmt.Sprintf("services[%d].plans[%d]", i, j)
+			if p.ID == "" {
+				problems = append(problems, where+": id is required")
+			}
+			if p.Name == "" {
+				problems = append(problems, where+": name is required")
+			} else if planNames[p.Name] {
+				problems = append(problems, fmt.Sprintf("%s: name %q is not unique within the service", where, p.Name))
+			}
+			planNames[p.Name] = true
+			if p.Description == "" {
+				problems = append(problems, where+": description is required")
+			}
+			unique(p.ID, "plan")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkLifecycle provisions, binds, unbinds and deprovisions an instance of
+// the target plan.
+func (r *runner) checkLifecycle(t *target) {
+	provision := &osb.ProvisionRequest{
+		InstanceID:        uuid.New(),
+		ServiceID:         t.serviceID,
+		PlanID:            t.planID,
+		OrganizationGUID:  uuid.New(),
+		SpaceGUID:         uuid.New(),
+		AcceptsIncomplete: true,
+	}
+	provisionInstance := func(request *osb.ProvisionRequest) (*osb.ProvisionResponse, *response, error) {
+		var provisioned *osb.ProvisionResponse
+		resp, err := r.send(func() error {
+			var err error
+			provisioned, err = r.client.ProvisionInstance(request)
+			return err
+		})
+		return provisioned, resp, err
+	}
+
+	var operation *osb.OperationKey
+	var accepted bool
+	created := r.check(checkProvision, specProvisioning, func() error {
+		provisioned, resp, err := provisionInstance(provision)
+		if err != nil {
+			return err
+		}
+		if err := expectParsed(resp, http.StatusCreated, http.StatusAccepted); err != nil {
+			return err
+		}
+		accepted = provisioned.Async
+		operation = provisioned.OperationKey
+		return nil
+	})
+	if !created {
+		for _, name := range lifecycleChecks[1:] {
+			r.skip(name, specProvisioning, "provisioning failed")
+		}
+		return
+	}
+
+	provisioned := true
+	if accepted {
+		provisioned = r.check(checkProvisionPoll, specPolling, func() error {
+			return r.poll(provision.InstanceID, operation, t, false)
+		})
+	} else {
+		r.skip(checkProvisionPoll, specPolling, "provisioning completed synchronously")
+	}
+
+	if provisioned {
+		r.check(checkProvisionIdentical, specProvisioning, func() error {
+			_, resp, err := provisionInstance(provision)
+			if err != nil {
+				return err
+			}
+			return expectParsed(resp, http.StatusOK)
+		})
+		r.check(checkProvisionConflict, specProvisioning, func() error {
+			conflicting := *provision
+			conflicting.Parameters = map[string]interface{}{"conformance": uuid.New()}
+			_, resp, err := provisionInstance(&conflicting)
+			if err != nil {
+				return err
+			}
+			return expect(resp, http.StatusConflict)
+		})
+	} else {
+		r.skip(checkProvisionIdentical, specProvisioning, "provisioning did not succeed")
+		r.skip(checkProvisionConflict, specProvisioning, "provisioning did not succeed")
+	}
+
+	switch {
+	case !provisioned:
+		for _, name := range []string{checkBind, checkBindIdentical, checkBindConflict, checkUnbind, checkUnbindGone} {
+			r.skip(name, specBinding, "provisioning did not succeed")
+		}
+	case !t.bindable:
+		for _, name := range []string{checkBind, checkBindIdentical, checkBindConflict, checkUnbind, checkUnbindGone} {
+			r.skip(name, specBinding, "the plan is not bindable")
+		}
+	default:
+		r.checkBinding(provision.InstanceID, t)
+	}
+
+	r.checkDeprovision(provision.InstanceID, t)
+}
+
+func (r *runner) checkBinding(instanceID string, t *target) {
+	bind := &osb.BindRequest{
+		BindingID:  uuid.New(),
+		InstanceID: instanceID,
+		ServiceID:  t.serviceID,
+		PlanID:     t.planID,
+	}
+	bindInstance := func(request *osb.BindRequest) (*response, error) {
+		return r.send(func() error {
+			_, err := r.client.Bind(request)
+			return err
+		})
+	}
+
+	bound := r.check(checkBind, specBinding, func() error {
+		resp, err := bindInstance(bind)
+		if err != nil {
+			return err
+		}
+		if err := expectParsed(resp, http.StatusCreated); err != nil {
+			return err
+		}
+		_, err = resp.object()
+		return err
+	})
+	if !bound {
+		for _, name := range []string{checkBindIdentical, checkBindConflict, checkUnbind, checkUnbindGone} {
+			r.skip(name, specBinding, "binding failed")
+		}
+		return
+	}
+
+	r.check(checkBindIdentical, specBinding, func() error {
+		resp, err := bindInstance(bind)
+		if err != nil {
+			return err
+		}
+		return expectParsed(resp, http.StatusOK)
+	})
+	r.check(checkBindConflict, specBinding, func() error {
+		conflicting := *bind
+		conflicting.Parameters = map[string]interface{}{"conformance": uuid.New()}
+		resp, err := bindInstance(&conflicting)
+		if err != nil {
+			return err
+		}
+		return expect(resp, http.StatusConflict)
+	})
+
+	unbind := func() (*response, error) {
+		return r.send(func() error {
+			_, err := r.client.Unbind(&osb.UnbindRequest{
+				InstanceID: instanceID,
+				BindingID:  bind.BindingID,
+				ServiceID:  t.serviceID,
+				PlanID:     t.planID,
+			})
+			return err
+		})
+	}
+	r.check(checkUnbind, specUnbinding, func() error {
+		resp, err := unbind()
+		if err != nil {
+			return err
+		}
+		return expectParsed(resp, http.StatusOK)
+	})
+	r.check(checkUnbindGone, specUnbinding, func() error {
+		resp, err := unbind()
+		if err != nil {
+			return err
+		}
+		return expect(resp, http.StatusGone)
+	})
+}
+
+func (r *runner) checkDeprovision(instanceID string, t *target) {
+	var deprovisioned *osb.DeprovisionResponse
+	deprovision := func() (*response, error) {
+		return r.send(func() error {
+			var err error
+			deprovisioned, err = r.client.DeprovisionInstance(&osb.DeprovisionRequest{
+				InstanceID:        instanceID,
+				ServiceID:         t.serviceID,
+				PlanID:            t.planID,
+				AcceptsIncomplete: true,
+			})
+			return err
+		})
+	}
+
+	var operation *osb.OperationKey
+	var accepted bool
+	ok := r.check(checkDeprovision, specDeprovisioning, func() error {
+		resp, err := deprovision()
+		if err != nil {
+			return err
+		}
+		if err := expectParsed(resp, http.StatusOK, http.StatusAccepted); err != nil {
+			return err
+		}
+		accepted = deprovisioned.Async
+		operation = deprovisioned.OperationKey
+		// The client does not parse the body of a 200 OK response.
+		_, err = resp.object()
+		return err
+	})
+	if !ok {
+		r.skip(checkDeprovisionPoll, specPolling, "deprovisioning failed")
+		r.skip(checkDeprovisionGone, specDeprovisioning, "deprovisioning failed")
+		return
+	}
+
+	if accepted {
+		ok = r.check(checkDeprovisionPoll, specPolling, func() error {
+			return r.poll(instanceID, operation, t, true)
+		})
+	} else {
+		r.skip(checkDeprovisionPoll, specPolling, "deprovisioning completed synchronously")
+	}
+	if !ok {
+		r.skip(checkDeprovisionGone, specDeprovisioning, "deprovisioning did not succeed")
+		return
+	}
+
+	r.check(checkDeprovisionGone, specDeprovisioning, func() error {
+		resp, err := deprovision()
+		if err != nil {
+			return err
+		}
+		return expect(resp, http.StatusGone)
+	})
+}
+
+// poll polls the last operation of an instance until it succeeds, checking
+// every response. If gone is true, a 410 Gone response also means the
+// operation succeeded.
+func (r *runner) poll(instanceID string, operation *osb.OperationKey, t *target, gone bool) error {
+	request := &osb.LastOperationRequest{
+		InstanceID:   instanceID,
+		ServiceID:    &t.serviceID,
+		PlanID:       &t.planID,
+		OperationKey: operation,
+	}
+
+	deadline := time.Now().Add(r.config.PollTimeout)
+	for {
+		var last *osb.LastOperationResponse
+		resp, err := r.send(func() error {
+			var err error
+			last, err = r.client.PollLastOperation(request)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if gone && resp.status == http.StatusGone {
+			return nil
+		}
+		// The client fails to parse a description that is not a string.
+		if err := expectParsed(resp, http.StatusOK); err != nil {
+			return err
+		}
+
+		switch last.State {
+		case osb.StateSucceeded:
+			return nil
+		case osb.StateFailed:
+			return fmt.Errorf("the operation failed: %v", resp)
+		case osb.StateInProgress:
+		default:
+			return fmt.Errorf("state must be %q, %q or %q: %v", osb.StateInProgress, osb.StateSucceeded, osb.StateFailed, resp)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the operation did not finish within %v", r.config.PollTimeout)
+		}
+		time.Sleep(r.config.PollInterval)
+	}
+}
+
+// expectParsed returns an error unless the response has one of the given
+// statuses and the OSB client could parse it.
+func expectParsed(resp *response, statuses ...int) error {
+	if err := expect(resp, statuses...); err != nil {
+		return err
+	}
+	if resp.err != nil {
+		return fmt.Errorf("%v: %v", resp, resp.err)
+	}
+	return nil
+}
+
+// checkErrorBodies checks the body of every error response the broker sent
+// during the run.
+func (r *runner) checkErrorBodies() {
+	name := "error responses have a JSON object body whose error and description fields are strings"
+	if len(r.errors) == 0 {
+		r.skip(name, specErrors, "the broker sent no error responses")
+		return
+	}
+
+	r.check(name, specErrors, func() error {
+		var problems []string
+		for _, resp := range r.errors {
+			object, err := resp.object()
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			for _, field := range []string{"error", "description"} {
+				if v, ok := object[field]; ok {
+					if _, ok := v.(string); !ok {
+						problems = append(problems, fmt.Sprintf("%s must be a string: %v", field, resp))
+					}
+				}
+			}
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%s", strings.Join(problems, "; "))
+		}
+		return nil
+	})
+}
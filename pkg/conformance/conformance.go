@@ -0,0 +1,336 @@
+// Package conformance checks that a broker behaves as the Open Service Broker
+// API specification requires. Run drives a scripted lifecycle against a
+// broker over HTTP: it fetches and validates the catalog, provisions an
+// instance, binds to it, unbinds and deprovisions it, repeating requests to
+// check that they are idempotent, sending conflicting ones and polling
+// asynchronous operations. Every response is checked for the status code and
+// body shape the specification requires, and the outcome of each check is
+// recorded in a Report along with the section of the specification it comes
+// from.
+//
+// The checks make their requests with the OSB client, through a local proxy
+// that records the broker's responses, because the client hides some of what
+// they check: it returns 200 and 201 responses alike and reports 410 Gone
+// from deprovision and unbind as success. Only the checks of the version
+// header, which the client always sends, make their requests directly.
+package conformance // import "github.com/pmorie/osb-starter-pack/pkg/conformance"
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Config configures a run of the checks.
+type Config struct {
+	// URL of the broker.
+	URL string
+	// APIVersion is sent in the X-Broker-API-Version header. It must be a
+	// version the OSB client supports, 2.11, 2.12 or 2.13, and defaults to
+	// the latest.
+	APIVersion string
+
+	// Username and Password authenticate with basic authentication, Token
+	// with a bearer token.
+	Username string
+	Password string
+	Token    string
+	// Insecure skips verification of the broker's TLS certificate.
+	Insecure bool
+
+	// ServiceID and PlanID select the plan to provision. They default to the
+	// first plan of the first bindable service in the catalog.
+	ServiceID string
+	PlanID    string
+
+	// PollInterval is how often asynchronous operations are polled; it
+	// defaults to one second. PollTimeout is how long they may take; it
+	// defaults to five minutes.
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+
+	// Client sends the requests to the broker, if set.
+	Client *http.Client
+}
+
+// Sections of the specification the checks refer to.
+const (
+	specVersionHeader  = SpecURL + "#api-version-header"
+	specErrors         = SpecURL + "#service-broker-errors"
+	specCatalog        = SpecURL + "#catalog-management"
+	specProvisioning   = SpecURL + "#provisioning"
+	specPolling        = SpecURL + "#polling-last-operation"
+	specBinding        = SpecURL + "#binding"
+	specUnbinding      = SpecURL + "#unbinding"
+	specDeprovisioning = SpecURL + "#deprovisioning"
+)
+
+// Run runs every check against the broker described by c. Checks that depend
+// on an earlier one that failed are skipped. It fails if c is not valid.
+func Run(c Config) (*Report, error) {
+	if c.APIVersion == "" {
+		c.APIVersion = osb.LatestAPIVersion().HeaderValue()
+	}
+	version, err := apiVersion(c.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = time.Second
+	}
+	if c.PollTimeout == 0 {
+		c.PollTimeout = 5 * time.Minute
+	}
+	if c.Client == nil {
+		c.Client = &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: c.Insecure},
+			},
+		}
+	}
+
+	r := &runner{
+		config: c,
+		url:    strings.TrimRight(c.URL, "/"),
+		report: &Report{URL: c.URL},
+	}
+	r.proxy = &proxy{runner: r}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the recording proxy: %v", err)
+	}
+	server := &http.Server{Handler: r.proxy}
+	go server.Serve(listener)
+	defer server.Close()
+
+	config := osb.DefaultClientConfiguration()
+	config.Name = "conformance"
+	config.URL = "http://" + listener.Addr().String()
+	config.APIVersion = version
+	switch {
+	case c.Token != "":
+		config.AuthConfig = &osb.AuthConfig{BearerConfig: &osb.BearerConfig{Token: c.Token}}
+	case c.Username != "":
+		config.AuthConfig = &osb.AuthConfig{BasicAuthConfig: &osb.BasicAuthConfig{Username: c.Username, Password: c.Password}}
+	}
+	if r.client, err = osb.NewClient(config); err != nil {
+		return nil, err
+	}
+
+	r.run()
+	return r.report, nil
+}
+
+// apiVersion returns the version of the OSB client with the given label.
+func apiVersion(label string) (osb.APIVersion, error) {
+	for _, v := range []osb.APIVersion{osb.Version2_11(), osb.Version2_12(), osb.Version2_13()} {
+		if v.HeaderValue() == label {
+			return v, nil
+		}
+	}
+	return osb.APIVersion{}, fmt.Errorf("unsupported API version %q", label)
+}
+
+type runner struct {
+	config Config
+	url    string
+	report *Report
+	client osb.Client
+	proxy  *proxy
+
+	// errors holds the error responses the broker sent during the run, whose
+	// bodies are checked last.
+	errors []*response
+}
+
+// response is a response from the broker.
+type response struct {
+	method string
+	path   string
+	status int
+	header http.Header
+	body   []byte
+	// err is the error the OSB client returned for the response, if it
+	// was made by the client.
+	err error
+}
+
+// object decodes the body of the response as a JSON object.
+func (resp *response) object() (map[string]interface{}, error) {
+	object := map[string]interface{}{}
+	if err := json.Unmarshal(resp.body, &object); err != nil {
+		return nil, fmt.Errorf("%s %s: response body is not a JSON object: %v", resp.method, resp.path, err)
+	}
+	return object, nil
+}
+
+// maxBodyInMessage is the length at which response bodies are truncated in
+// the messages of failed checks.
+const maxBodyInMessage = 200
+
+func (resp *response) String() string {
+	body := string(bytes.TrimSpace(resp.body))
+	if len(body) > maxBodyInMessage {
+		body = body[:maxBodyInMessage] + "..."
+	}
+	return fmt.Sprintf("%s %s returned %d: %s", resp.method, resp.path, resp.status, body)
+}
+
+// proxy forwards the OSB client's requests to the broker and records the
+// broker's last response.
+type proxy struct {
+	runner *runner
+
+	mu   sync.Mutex
+	last *response
+	err  error
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+	out, err := http.NewRequest(req.Method, p.runner.url+req.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+	out.Header = req.Header
+	resp, err := p.runner.forward(out)
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+
+	p.mu.Lock()
+	p.last = resp
+	p.mu.Unlock()
+	for k, v := range resp.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// fail records that the request could not be forwarded.
+func (p *proxy) fail(w http.ResponseWriter, err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// send calls fn, which makes a request with the OSB client and returns the
+// client's error, and returns the broker's response to the request. It fails
+// if the request did not reach the broker.
+func (r *runner) send(fn func() error) (*response, error) {
+	r.proxy.mu.Lock()
+	r.proxy.last, r.proxy.err = nil, nil
+	r.proxy.mu.Unlock()
+
+	err := fn()
+
+	r.proxy.mu.Lock()
+	defer r.proxy.mu.Unlock()
+	switch {
+	case r.proxy.err != nil:
+		return nil, r.proxy.err
+	case r.proxy.last == nil:
+		// The client rejected the request without sending it.
+		return nil, err
+	}
+	resp := r.proxy.last
+	resp.err = err
+	return resp, nil
+}
+
+// do sends a request to the broker directly, with the version header unless
+// version is empty, for the checks the OSB client cannot make.
+func (r *runner) do(method, path, version string) (*response, error) {
+	req, err := http.NewRequest(method, r.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if version != "" {
+		req.Header.Set(osb.APIVersionHeader, version)
+	}
+	switch {
+	case r.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+r.config.Token)
+	case r.config.Username != "":
+		req.SetBasicAuth(r.config.Username, r.config.Password)
+	}
+	return r.forward(req)
+}
+
+// forward sends req to the broker and reads its response, recording error
+// responses.
+func (r *runner) forward(req *http.Request) (*response, error) {
+	httpResp, err := r.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	resp := &response{
+		method: req.Method,
+		path:   req.URL.Path,
+		status: httpResp.StatusCode,
+		header: httpResp.Header,
+	}
+	if resp.body, err = ioutil.ReadAll(httpResp.Body); err != nil {
+		return nil, err
+	}
+	if resp.status >= 400 {
+		r.errors = append(r.errors, resp)
+	}
+	return resp, nil
+}
+
+// check runs fn and records its outcome under name, returning whether it
+// passed.
+func (r *runner) check(name, spec string, fn func() error) bool {
+	result := &Result{Name: name, Spec: spec, Status: Pass}
+	if err := fn(); err != nil {
+		result.Status = Fail
+		result.Message = err.Error()
+	}
+	r.report.Results = append(r.report.Results, result)
+	return result.Status == Pass
+}
+
+// skip records that the check with the given name was skipped.
+func (r *runner) skip(name, spec, reason string) {
+	r.report.Results = append(r.report.Results, &Result{
+		Name:    name,
+		Spec:    spec,
+		Status:  Skip,
+		Message: reason,
+	})
+}
+
+// expect returns an error unless the response has one of the given statuses.
+func expect(resp *response, statuses ...int) error {
+	for _, status := range statuses {
+		if resp.status == status {
+			return nil
+		}
+	}
+	want := make([]string, len(statuses))
+	for i, status := range statuses {
+		want[i] = fmt.Sprint(status)
+	}
+	return fmt.Errorf("expected %s, but %v", strings.Join(want, " or "), resp)
+}
@@ -0,0 +1,77 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+)
+
+// Statuses of a check.
+const (
+	Pass = "pass"
+	Fail = "fail"
+	Skip = "skip"
+)
+
+// SpecURL is the version of the specification the checks refer to.
+const SpecURL = "https://github.com/openservicebrokerapi/servicebroker/blob/v2.13/spec.md"
+
+// Result is the outcome of one check.
+type Result struct {
+	// Name describes the behavior the check expects.
+	Name string `json:"name"`
+	// Spec links to the section of the specification that requires the
+	// behavior.
+	Spec    string `json:"spec"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Report holds the results of a run of the checks against a broker.
+type Report struct {
+	URL     string    `json:"url"`
+	Results []*Result `json:"results"`
+}
+
+// Count returns the number of results with the given status.
+func (r *Report) Count(status string) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Passed reports whether no check failed.
+func (r *Report) Passed() bool {
+	return r.Count(Fail) == 0
+}
+
+// WriteText writes the report to w as one line per check, followed by the
+// reason it failed or was skipped, and a summary.
+func (r *Report) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Conformance of %s\n\n", r.URL); err != nil {
+		return err
+	}
+	for _, result := range r.Results {
+		var status string
+		switch result.Status {
+		case Pass:
+			status = "PASS"
+		case Fail:
+			status = "FAIL"
+		default:
+			status = "SKIP"
+		}
+		fmt.Fprintf(w, "%s  %s\n", status, result.Name)
+		if result.Message != "" {
+			fmt.Fprintf(w, "      %s\n", result.Message)
+		}
+		if result.Status == Fail {
+			fmt.Fprintf(w, "      see %s\n", result.Spec)
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", r.Count(Pass), r.Count(Fail), r.Count(Skip))
+	return err
+}
@@ -0,0 +1,377 @@
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/catalog"
+	"github.com/pmorie/osb-starter-pack/pkg/maintenance"
+	"github.com/pmorie/osb-starter-pack/pkg/visibility"
+)
+
+// Catalog holds the services a broker offers, the Driver of each and the
+// fields of their plans the OSB libraries do not know about.
+type Catalog struct {
+	mu          sync.RWMutex
+	services    []osb.Service
+	drivers     map[string]Driver
+	maintenance map[string]maintenance.Info
+	polling     map[string]time.Duration
+	// visibility holds the visibility rules of services and plans, by their
+	// IDs.
+	visibility map[string]visibility.Rule
+	// revision counts the changes to the catalog.
+	revision uint64
+}
+
+var _ catalog.Source = &Catalog{}
+
+// NewCatalog returns an empty Catalog.
+func NewCatalog() *Catalog {
+	return &Catalog{
+		drivers:     map[string]Driver{},
+		maintenance: map[string]maintenance.Info{},
+		polling:     map[string]time.Duration{},
+		visibility:  map[string]visibility.Rule{},
+	}
+}
+
+// Add adds a service to the catalog, provisioned by d.
+func (c *Catalog) Add(s osb.Service, d Driver) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s.ID == "" || s.Name == "" {
+		return fmt.Errorf("service must have an ID and a name")
+	}
+	if _, ok := c.drivers[s.ID]; ok {
+		return fmt.Errorf("service %q is already in the catalog", s.ID)
+	}
+	if len(s.Plans) == 0 {
+		return fmt.Errorf("service %q has no plans", s.Name)
+	}
+	c.services = append(c.services, s)
+	c.drivers[s.ID] = d
+	c.revision++
+	return nil
+}
+
+// ReplaceService replaces the definition of the service in the catalog with
+// the same ID as s, keeping its Driver. The service and its plans lose their
+// visibility rules, and plans that s does not have lose their maintenance
+// info and maximum polling duration.
+func (c *Catalog) ReplaceService(s osb.Service) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s.ID == "" || s.Name == "" {
+		return fmt.Errorf("service must have an ID and a name")
+	}
+	if len(s.Plans) == 0 {
+		return fmt.Errorf("service %q has no plans", s.Name)
+	}
+	for i := range c.services {
+		if c.services[i].ID != s.ID {
+			continue
+		}
+		delete(c.visibility, s.ID)
+		for _, p := range c.services[i].Plans {
+			delete(c.maintenance, p.ID)
+			delete(c.polling, p.ID)
+			delete(c.visibility, p.ID)
+		}
+		c.services[i] = s
+		c.revision++
+		return nil
+	}
+	return fmt.Errorf("the broker has no driver for service %q", s.ID)
+}
+
+// Set replaces the contents of the catalog with those of n, such as a catalog
+// that was built again from changed files. n must not be used afterwards.
+func (c *Catalog) Set(n *Catalog) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.services = n.services
+	c.drivers = n.drivers
+	c.maintenance = n.maintenance
+	c.polling = n.polling
+	c.visibility = n.visibility
+	c.revision++
+}
+
+// Revision returns a number that changes whenever the catalog does, for
+// caches of it to tell when they are stale.
+func (c *Catalog) Revision() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.revision
+}
+
+// Wrap replaces the Driver of every service in the catalog with the Driver
+// fn returns for it, such as one that wraps it.
+func (c *Catalog) Wrap(fn func(s osb.Service, d Driver) Driver) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range c.services {
+		c.drivers[s.ID] = fn(s, c.drivers[s.ID])
+	}
+	c.revision++
+}
+
+// SetMaintenanceInfo sets the maintenance info of the plan with the given ID,
+// which must be in the catalog. Change its version whenever a new version of
+// the broker changes what instances of the plan get, so platforms offer to
+// upgrade them.
+func (c *Catalog) SetMaintenanceInfo(planID string, info maintenance.Info) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if info.Version == "" {
+		return fmt.Errorf("maintenance info of plan %q must have a version", planID)
+	}
+	if !c.hasPlan(planID) {
+		return fmt.Errorf("the catalog has no plan %q", planID)
+	}
+	c.maintenance[planID] = info
+	c.revision++
+	return nil
+}
+
+// MaintenanceInfo returns the maintenance info of the plan with the given ID,
+// or nil if it has none.
+func (c *Catalog) MaintenanceInfo(planID string) *maintenance.Info {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	info, ok := c.maintenance[planID]
+	if !ok {
+		return nil
+	}
+	return &info
+}
+
+// SetMaximumPollingDuration sets how long operations on instances of the plan
+// with the given ID, which must be in the catalog, may run before they fail.
+// Platforms stop polling them after this long too. It is rounded up to whole
+// seconds.
+func (c *Catalog) SetMaximumPollingDuration(planID string, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d <= 0 {
+		return fmt.Errorf("maximum polling duration of plan %q must be positive", planID)
+	}
+	if !c.hasPlan(planID) {
+		return fmt.Errorf("the catalog has no plan %q", planID)
+	}
+	if rem := d % time.Second; rem != 0 {
+		d += time.Second - rem
+	}
+	c.polling[planID] = d
+	c.revision++
+	return nil
+}
+
+// MaximumPollingDuration returns how long operations on instances of the plan
+// with the given ID may run, or 0 if there is no limit.
+func (c *Catalog) MaximumPollingDuration(planID string) time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.polling[planID]
+}
+
+// PlanFields returns the maintenance_info and maximum_polling_duration of the
+// plan with the given ID, for catalog.Middleware to add to the catalog it
+// serves.
+func (c *Catalog) PlanFields(planID string) map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fields := map[string]interface{}{}
+	if info, ok := c.maintenance[planID]; ok {
+		fields["maintenance_info"] = info
+	}
+	if d, ok := c.polling[planID]; ok {
+		fields["maximum_polling_duration"] = int64(d / time.Second)
+	}
+	return fields
+}
+
+// SetVisibility restricts who sees the service or plan with the given ID,
+// which must be in the catalog, to those r allows. A plan is only visible to
+// those both its own rule and its service's allow.
+func (c *Catalog) SetVisibility(id string, r visibility.Rule) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.drivers[id]; !ok && !c.hasPlan(id) {
+		return fmt.Errorf("the catalog has no service or plan %q", id)
+	}
+	c.visibility[id] = r
+	c.revision++
+	return nil
+}
+
+// VisibleServices returns the services in the catalog with the plans that
+// may be visible to the viewer of r, leaving out services none of whose plans
+// are.
+func (c *Catalog) VisibleServices(r *http.Request) []osb.Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	v := visibility.FromRequest(r)
+	var services []osb.Service
+	for _, s := range c.services {
+		var plans []osb.Plan
+		for _, p := range s.Plans {
+			if c.rule(s.ID).MayAllow(v) && c.rule(p.ID).MayAllow(v) {
+				plans = append(plans, p)
+			}
+		}
+		if len(plans) == 0 {
+			continue
+		}
+		s.Plans = plans
+		services = append(services, s)
+	}
+	return services
+}
+
+// CheckVisible returns a 403 Forbidden error unless the plan with the given
+// IDs is visible to v, which may then provision and bind it.
+func (c *Catalog) CheckVisible(v *visibility.Viewer, serviceID, planID string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.rule(serviceID).Allows(v) && c.rule(planID).Allows(v) {
+		return nil
+	}
+	description := fmt.Sprintf("Plan %q of service %q is not available to the originating identity", planID, serviceID)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusForbidden,
+		Description: &description,
+	}
+}
+
+// rule returns the visibility rule of the service or plan with the given ID,
+// or nil if it has none. It must be called with c.mu held.
+func (c *Catalog) rule(id string) *visibility.Rule {
+	r, ok := c.visibility[id]
+	if !ok {
+		return nil
+	}
+	return &r
+}
+
+// hasPlan reports whether the catalog has a plan with the given ID. It must be
+// called with c.mu held.
+func (c *Catalog) hasPlan(planID string) bool {
+	for _, s := range c.services {
+		for _, p := range s.Plans {
+			if p.ID == planID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Services returns the services in the catalog, in the order they were
+// added.
+func (c *Catalog) Services() []osb.Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	services := make([]osb.Service, len(c.services))
+	copy(services, c.services)
+	return services
+}
+
+// Lookup returns the service and plan with the given IDs and the Driver of
+// the service. It returns a 400 Bad Request error if there is no such service
+// or plan.
+func (c *Catalog) Lookup(serviceID, planID string) (*osb.Service, *osb.Plan, Driver, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for i := range c.services {
+		s := &c.services[i]
+		if s.ID != serviceID {
+			continue
+		}
+		for j := range s.Plans {
+			if s.Plans[j].ID == planID {
+				return s, &s.Plans[j], c.drivers[s.ID], nil
+			}
+		}
+		return nil, nil, nil, badRequest(fmt.Sprintf("Service %q has no plan %q", s.Name, planID))
+	}
+	return nil, nil, nil, badRequest(fmt.Sprintf("The catalog has no service %q", serviceID))
+}
+
+// Driver returns the Driver of the service with the given ID.
+func (c *Catalog) Driver(serviceID string) (Driver, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	d, ok := c.drivers[serviceID]
+	if !ok {
+		return nil, badRequest(fmt.Sprintf("The catalog has no service %q", serviceID))
+	}
+	return d, nil
+}
+
+func badRequest(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
+
+// Validate checks that the catalog is one the OSB API allows a broker to
+// serve: services and plans have IDs and names, service IDs and names and
+// plan IDs are unique in the catalog, and plan names in their service.
+func (c *Catalog) Validate() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	serviceIDs := map[string]bool{}
+	serviceNames := map[string]bool{}
+	planIDs := map[string]bool{}
+	for _, s := range c.services {
+		switch {
+		case serviceIDs[s.ID]:
+			return fmt.Errorf("more than one service has the ID %q", s.ID)
+		case serviceNames[s.Name]:
+			return fmt.Errorf("more than one service is named %q", s.Name)
+		}
+		serviceIDs[s.ID] = true
+		serviceNames[s.Name] = true
+
+		planNames := map[string]bool{}
+		for _, p := range s.Plans {
+			switch {
+			case p.ID == "" || p.Name == "":
+				return fmt.Errorf("plans of service %q must have an ID and a name", s.Name)
+			case planIDs[p.ID]:
+				return fmt.Errorf("more than one plan has the ID %q", p.ID)
+			case planNames[p.Name]:
+				return fmt.Errorf("service %q has more than one plan named %q", s.Name, p.Name)
+			}
+			planIDs[p.ID] = true
+			planNames[p.Name] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package driver separates the resources behind a service from the Open
+// Service Broker protocol. A Driver creates, updates and deletes the
+// resources of an instance, binds to it and reports its status; the broker's
+// BusinessLogic handles the protocol around it: catalog lookup, state,
+// idempotency, asynchronous operations and errors. A Catalog maps each
+// service the broker offers to the Driver that provisions it.
+package driver // import "github.com/pmorie/osb-starter-pack/pkg/driver"
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Driver provisions and binds the resources behind the plans of a service.
+//
+// Create, Update and Delete may return before the resources are ready or
+// gone; the broker then polls Status until they are. They may be called
+// again for the same instance if the broker restarted during the operation,
+// so they must be idempotent. Errors of type osb.HTTPStatusCodeError are
+// returned to the platform as they are; any other error fails the operation
+// with the error's message as its description.
+type Driver interface {
+	// Create creates the resources of a new instance. It may set the
+	// instance's DashboardURL.
+	Create(ctx context.Context, i *state.Instance) error
+	// Update applies the instance's plan and parameters, which the platform
+	// asked to change, to its resources. It is also how instances are
+	// upgraded to the current version of their plan, as recorded in the
+	// instance's MaintenanceVersion.
+	Update(ctx context.Context, i *state.Instance) error
+	// Delete deletes the resources of an instance, including any that were
+	// only partially created.
+	Delete(ctx context.Context, i *state.Instance) error
+	// Bind creates a binding to an instance and returns its credentials.
+	Bind(ctx context.Context, i *state.Instance, b *state.Binding) (map[string]interface{}, error)
+	// Unbind deletes a binding.
+	Unbind(ctx context.Context, i *state.Instance, b *state.Binding) error
+	// Status reports the state of the resources of an instance.
+	Status(ctx context.Context, i *state.Instance) (*Status, error)
+}
+
+// State is the state of the resources of an instance.
+type State string
+
+const (
+	// Ready means the resources exist and are usable.
+	Ready State = "ready"
+	// Pending means the resources are being created, updated or deleted.
+	Pending State = "pending"
+	// Failed means the resources cannot become ready.
+	Failed State = "failed"
+	// Gone means the resources do not exist.
+	Gone State = "gone"
+)
+
+// Status is the state of the resources of an instance, with a description
+// for the platform's user.
+type Status struct {
+	State       State
+	Description string
+}
+
+// Wait polls d.Status every interval until the resources of the instance
+// reach the state want. It returns an error if they fail or ctx is done
+// first. If progress is not nil, it is called with every other status.
+func Wait(ctx context.Context, d Driver, i *state.Instance, want State, interval time.Duration, progress func(s *Status)) error {
+	for {
+		status, err := d.Status(ctx, i)
+		if err != nil {
+			return err
+		}
+		if status.State == want {
+			return nil
+		}
+		if progress != nil {
+			progress(status)
+		}
+		if status.State == Failed {
+			if status.Description != "" {
+				return fmt.Errorf("%s", status.Description)
+			}
+			return fmt.Errorf("the resources of the instance failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,417 @@
+// Package exec provides a driver.Driver that runs a script for each step of
+// an instance's lifecycle, so a service can be written in any language
+// without changing the broker. A script gets the OSB request of its step as
+// JSON on stdin, for example an osb.ProvisionRequest for provision or an
+// osb.BindRequest for bind, and these environment variables:
+//
+//	OSB_OPERATION       provision, update, deprovision, bind, unbind or status
+//	OSB_INSTANCE_ID     the ID of the instance
+//	OSB_INSTANCE_STATE  creating, ready, deleting or failed
+//	OSB_SERVICE_ID      the ID of the instance's service
+//	OSB_PLAN_ID         the ID of the instance's plan
+//	OSB_BINDING_ID      the ID of the binding, for bind and unbind
+//
+// It runs in its own directory with the broker's environment, without the
+// OSB_ variables that configure the broker, which may hold secrets. It may
+// write a JSON object to stdout:
+//
+//	{
+//	  "dashboard_url": "https://...",  the instance's dashboard, for provision
+//	  "credentials": {...},            the binding's credentials, for bind
+//	  "state": "pending",              ready, pending, failed or gone, for status
+//	  "description": "..."             shown to the platform's user
+//	}
+//
+// Scripts that write more than MaxOutput bytes to stdout fail; only the end
+// of what they write to stderr is kept.
+//
+// A script that exits with a status of ExitCodes returns that HTTP status to
+// the platform, with the description or else what the script wrote to
+// stderr; one that exits with any other non-zero status fails the operation.
+// Scripts that run longer than the Driver's Timeout are killed, with any
+// processes they started, and fail.
+//
+// Provision, update and deprovision may return before the instance's
+// resources are ready or gone if the plan has a status script, which the
+// broker then runs every few seconds until it reports that they are. Without
+// one, they are ready once provision or update exits and gone once
+// deprovision does.
+package exec // import "github.com/pmorie/osb-starter-pack/pkg/driver/exec"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// The operations a plan can have a script for.
+const (
+	Provision   = "provision"
+	Update      = "update"
+	Deprovision = "deprovision"
+	Bind        = "bind"
+	Unbind      = "unbind"
+	Status      = "status"
+)
+
+var operations = map[string]bool{
+	Provision:   true,
+	Update:      true,
+	Deprovision: true,
+	Bind:        true,
+	Unbind:      true,
+	Status:      true,
+}
+
+// ExitCodes maps the exit statuses of scripts to the HTTP statuses they
+// return to the platform.
+var ExitCodes = map[int]int{
+	10: http.StatusBadRequest,
+	11: http.StatusConflict,
+	12: http.StatusUnprocessableEntity,
+}
+
+// DefaultTimeout is the Timeout of the Drivers New returns.
+const DefaultTimeout = 5 * time.Minute
+
+// MaxOutput is the most a script may write to stdout. Scripts that write more
+// fail.
+const MaxOutput = 4 << 20
+
+// maxStderr is the most of what a script writes to stderr that is kept; the
+// end of it is kept.
+const maxStderr = 64 << 10
+
+// maxDescription is the most of a script's stderr that is used as the
+// description of its failure. The end of it is kept, which is usually where
+// the error is.
+const maxDescription = 1024
+
+// Plan holds the scripts of a plan.
+type Plan struct {
+	// Scripts maps the operations the plan has a script for to the script's
+	// path. Provision and Deprovision are required, and so is Bind if the
+	// plan is bindable.
+	Scripts map[string]string
+}
+
+// Output is what a script may write to stdout.
+type Output struct {
+	DashboardURL string                 `json:"dashboard_url,omitempty"`
+	Credentials  map[string]interface{} `json:"credentials,omitempty"`
+	State        driver.State           `json:"state,omitempty"`
+	Description  string                 `json:"description,omitempty"`
+}
+
+// Driver runs the scripts of a service's plans.
+type Driver struct {
+	// Timeout bounds each run of a script. 0 lets scripts run for as long
+	// as the operation may.
+	Timeout time.Duration
+
+	plans map[string]*Plan
+}
+
+var _ driver.Driver = &Driver{}
+
+// New returns a Driver without plans. Add the plans it provisions with
+// AddPlan.
+func New() *Driver {
+	return &Driver{
+		Timeout: DefaultTimeout,
+		plans:   map[string]*Plan{},
+	}
+}
+
+// AddPlan sets the scripts of the plan with the given ID.
+func (d *Driver) AddPlan(planID string, p *Plan) {
+	d.plans[planID] = p
+}
+
+func (d *Driver) Create(ctx context.Context, i *state.Instance) error {
+	out, err := d.run(ctx, Provision, i, nil, &osb.ProvisionRequest{
+		InstanceID:          i.ID,
+		ServiceID:           i.ServiceID,
+		PlanID:              i.PlanID,
+		OrganizationGUID:    i.OrganizationGUID,
+		SpaceGUID:           i.SpaceGUID,
+		Parameters:          i.Parameters,
+		Context:             i.Context,
+		OriginatingIdentity: i.Owner,
+	})
+	if err != nil {
+		return err
+	}
+	if out.DashboardURL != "" {
+		i.DashboardURL = out.DashboardURL
+	}
+	return nil
+}
+
+func (d *Driver) Update(ctx context.Context, i *state.Instance) error {
+	if d.script(i.PlanID, Update) == "" {
+		return unprocessable(fmt.Sprintf("Instances of plan %q cannot be updated", i.PlanID))
+	}
+	planID := i.PlanID
+	_, err := d.run(ctx, Update, i, nil, &osb.UpdateInstanceRequest{
+		InstanceID: i.ID,
+		ServiceID:  i.ServiceID,
+		PlanID:     &planID,
+		Parameters: i.Parameters,
+		Context:    i.Context,
+	})
+	return err
+}
+
+func (d *Driver) Delete(ctx context.Context, i *state.Instance) error {
+	_, err := d.run(ctx, Deprovision, i, nil, &osb.DeprovisionRequest{
+		InstanceID: i.ID,
+		ServiceID:  i.ServiceID,
+		PlanID:     i.PlanID,
+	})
+	return err
+}
+
+func (d *Driver) Bind(ctx context.Context, i *state.Instance, b *state.Binding) (map[string]interface{}, error) {
+	out, err := d.run(ctx, Bind, i, b, &osb.BindRequest{
+		BindingID:           b.ID,
+		InstanceID:          i.ID,
+		ServiceID:           b.ServiceID,
+		PlanID:              b.PlanID,
+		Parameters:          b.Parameters,
+		Context:             b.Context,
+		OriginatingIdentity: b.Owner,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Credentials, nil
+}
+
+func (d *Driver) Unbind(ctx context.Context, i *state.Instance, b *state.Binding) error {
+	if d.script(i.PlanID, Unbind) == "" {
+		return nil
+	}
+	_, err := d.run(ctx, Unbind, i, b, &osb.UnbindRequest{
+		InstanceID: i.ID,
+		BindingID:  b.ID,
+		ServiceID:  b.ServiceID,
+		PlanID:     b.PlanID,
+	})
+	return err
+}
+
+func (d *Driver) Status(ctx context.Context, i *state.Instance) (*driver.Status, error) {
+	if d.script(i.PlanID, Status) == "" {
+		if i.State == state.InstanceDeleting || i.State == state.InstanceFailed {
+			return &driver.Status{State: driver.Gone}, nil
+		}
+		return &driver.Status{State: driver.Ready}, nil
+	}
+
+	request := &osb.LastOperationRequest{
+		InstanceID: i.ID,
+		ServiceID:  &i.ServiceID,
+		PlanID:     &i.PlanID,
+	}
+	if i.LastOperation != nil {
+		key := osb.OperationKey(i.LastOperation.Key)
+		request.OperationKey = &key
+	}
+	out, err := d.run(ctx, Status, i, nil, request)
+	if err != nil {
+		return nil, err
+	}
+	switch out.State {
+	case driver.Ready, driver.Pending, driver.Failed, driver.Gone:
+	default:
+		return nil, fmt.Errorf("the status script reported the unknown state %q", out.State)
+	}
+	return &driver.Status{State: out.State, Description: out.Description}, nil
+}
+
+// script returns the path of the script of a plan for an operation, or "" if
+// it has none.
+func (d *Driver) script(planID, operation string) string {
+	p, ok := d.plans[planID]
+	if !ok {
+		return ""
+	}
+	return p.Scripts[operation]
+}
+
+// run runs the script of the instance's plan for an operation with request
+// on its stdin, and returns what it wrote to stdout. b is the binding the
+// operation is on, if any.
+func (d *Driver) run(ctx context.Context, operation string, i *state.Instance, b *state.Binding, request interface{}) (*Output, error) {
+	path := d.script(i.PlanID, operation)
+	if path == "" {
+		return nil, badRequest(fmt.Sprintf("Plan %q has no %s script", i.PlanID, operation))
+	}
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	stdout := &limitedWriter{limit: MaxOutput}
+	stderr := &limitedWriter{limit: maxStderr, tail: true}
+	cmd := osexec.Command(path)
+	cmd.Dir = filepath.Dir(path)
+	cmd.Env = environment(operation, i, b)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to run the %s script: %v", operation, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("the %s script did not finish within %s", operation, d.Timeout)
+		}
+		return nil, ctx.Err()
+	}
+
+	out := &Output{}
+	parseErr := parseOutput(stdout.data, out)
+	if err != nil {
+		exitErr, ok := err.(*osexec.ExitError)
+		if !ok {
+			return nil, fmt.Errorf("unable to run the %s script: %v", operation, err)
+		}
+		code := exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+		description := out.Description
+		if description == "" {
+			description = tail(strings.TrimSpace(string(stderr.data)), maxDescription)
+		}
+		if description == "" {
+			description = fmt.Sprintf("The %s script exited with status %d", operation, code)
+		}
+		if status, ok := ExitCodes[code]; ok {
+			return nil, osb.HTTPStatusCodeError{
+				StatusCode:  status,
+				Description: &description,
+			}
+		}
+		return nil, fmt.Errorf("%s", description)
+	}
+	if stdout.exceeded {
+		return nil, fmt.Errorf("the %s script wrote more than %d bytes to stdout", operation, MaxOutput)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("the %s script wrote invalid output: %v", operation, parseErr)
+	}
+	return out, nil
+}
+
+// parseOutput parses what a script wrote to stdout into out. A script may
+// write nothing.
+func parseOutput(stdout []byte, out *Output) error {
+	if len(bytes.TrimSpace(stdout)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(stdout, out)
+}
+
+// limitedWriter keeps at most limit bytes of what is written to it, and
+// records whether more was written. Writes never fail, so scripts are not
+// killed by a broken pipe.
+type limitedWriter struct {
+	data  []byte
+	limit int
+	// tail, if set, keeps the last limit bytes rather than the first.
+	tail     bool
+	exceeded bool
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(w.data)+len(p) > w.limit {
+		w.exceeded = true
+	}
+	switch {
+	case w.tail:
+		w.data = append(w.data, p...)
+		if over := len(w.data) - w.limit; over > 0 {
+			w.data = w.data[:copy(w.data, w.data[over:])]
+		}
+	case len(w.data) < w.limit:
+		room := w.limit - len(w.data)
+		if len(p) < room {
+			room = len(p)
+		}
+		w.data = append(w.data, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// environment returns the environment scripts run with: the broker's, without
+// the OSB_ variables that configure it, and the variables that describe the
+// operation.
+func environment(operation string, i *state.Instance, b *state.Binding) []string {
+	var env []string
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "OSB_") {
+			env = append(env, v)
+		}
+	}
+	env = append(env,
+		"OSB_OPERATION="+operation,
+		"OSB_INSTANCE_ID="+i.ID,
+		"OSB_INSTANCE_STATE="+string(i.State),
+		"OSB_SERVICE_ID="+i.ServiceID,
+		"OSB_PLAN_ID="+i.PlanID,
+	)
+	if b != nil {
+		env = append(env, "OSB_BINDING_ID="+b.ID)
+	}
+	return env
+}
+
+// tail returns the last n bytes of s, or s if it is shorter.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
+}
+
+func badRequest(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
+
+func unprocessable(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusUnprocessableEntity,
+		Description: &description,
+	}
+}
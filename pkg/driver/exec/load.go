@@ -0,0 +1,132 @@
+package exec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// ServiceFile is the name of the file, in a service's directory, that holds
+// the service's catalog entry.
+const ServiceFile = "service.yaml"
+
+// Load loads a service and the Driver of its plans from a directory laid out
+// as:
+//
+//	service.yaml          the service's catalog entry, in YAML or JSON
+//	provision, bind, ...  the scripts of every plan, named after their
+//	                      operation with any extension, such as provision.sh
+//	<plan name>/bind, ... scripts of a plan that replace the service's
+//
+// Scripts must be executable.
+func Load(dir string) (*osb.Service, *Driver, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ServiceFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	service := &osb.Service{}
+	if err := yaml.Unmarshal(b, service); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse %s: %v", filepath.Join(dir, ServiceFile), err)
+	}
+	scripts, err := findScripts(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := New()
+	for _, plan := range service.Plans {
+		p, err := loadPlan(filepath.Join(dir, plan.Name), scripts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("plan %q of service %q: %v", plan.Name, service.Name, err)
+		}
+		bindable := service.Bindable
+		if plan.Bindable != nil {
+			bindable = *plan.Bindable
+		}
+		required := []string{Provision, Deprovision}
+		if bindable {
+			required = append(required, Bind)
+		}
+		for _, operation := range required {
+			if p.Scripts[operation] == "" {
+				return nil, nil, fmt.Errorf("plan %q of service %q has no %s script", plan.Name, service.Name, operation)
+			}
+		}
+		d.AddPlan(plan.ID, p)
+	}
+	return service, d, nil
+}
+
+// loadPlan returns the plan whose own scripts are in dir, which need not
+// exist, and whose other scripts are those of its service.
+func loadPlan(dir string, service map[string]string) (*Plan, error) {
+	p := &Plan{Scripts: map[string]string{}}
+	for operation, path := range service {
+		p.Scripts[operation] = path
+	}
+	if info, err := os.Stat(dir); os.IsNotExist(err) || err == nil && !info.IsDir() {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	scripts, err := findScripts(dir)
+	if err != nil {
+		return nil, err
+	}
+	for operation, path := range scripts {
+		p.Scripts[operation] = path
+	}
+	return p, nil
+}
+
+// findScripts returns the paths of the scripts in dir by operation.
+func findScripts(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	scripts := map[string]string{}
+	for _, f := range files {
+		operation := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		if f.IsDir() || !operations[operation] {
+			continue
+		}
+		path, err := filepath.Abs(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if f.Mode()&0111 == 0 {
+			return nil, fmt.Errorf("%s is not executable", path)
+		}
+		if other, ok := scripts[operation]; ok {
+			return nil, fmt.Errorf("%s and %s are both %s scripts", other, path, operation)
+		}
+		scripts[operation] = path
+	}
+	return scripts, nil
+}
+
+// Services returns the directories of the services in dir: its
+// subdirectories that have a service.yaml.
+func Services(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, f := range files {
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		if _, err := os.Stat(filepath.Join(path, ServiceFile)); err == nil {
+			dirs = append(dirs, path)
+		}
+	}
+	return dirs, nil
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	osexec "os/exec"
+	"syscall"
+)
+
+// setProcessGroup has cmd run in a process group of its own, so that
+// killProcessGroup also kills the processes it starts.
+func setProcessGroup(cmd *osexec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group of cmd, which must have been
+// started.
+func killProcessGroup(cmd *osexec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
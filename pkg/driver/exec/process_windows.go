@@ -0,0 +1,14 @@
+package exec
+
+import (
+	osexec "os/exec"
+)
+
+// setProcessGroup does nothing on Windows, where only the script itself is
+// killed.
+func setProcessGroup(cmd *osexec.Cmd) {}
+
+// killProcessGroup kills cmd, which must have been started.
+func killProcessGroup(cmd *osexec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/maintenance"
+	"github.com/pmorie/osb-starter-pack/pkg/visibility"
+)
+
+// catalogFile is a catalog file: a catalog response in YAML or JSON.
+type catalogFile struct {
+	Services []json.RawMessage `json:"services"`
+}
+
+// fileFields holds the fields of the services and plans in a catalog file
+// that osb.Service and osb.Plan do not have.
+type fileFields struct {
+	Visibility *visibility.Rule `json:"visibility"`
+	Plans      []struct {
+		ID                     string            `json:"id"`
+		MaintenanceInfo        *maintenance.Info `json:"maintenance_info"`
+		MaximumPollingDuration *int64            `json:"maximum_polling_duration"`
+		Visibility             *visibility.Rule  `json:"visibility"`
+	} `json:"plans"`
+}
+
+// ApplyFile replaces the definitions of the services in the catalog with
+// those in the catalog file at path, which is a catalog response in YAML or
+// JSON. Every service in the file must be one the catalog has a Driver for;
+// the file only changes how it is offered, such as its description or plans.
+// Plans in the file may have a maintenance_info and a
+// maximum_polling_duration in seconds, and services and plans a visibility
+// rule.
+func (c *Catalog) ApplyFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return fmt.Errorf("unable to parse catalog file %q: %v", path, err)
+	}
+	var f catalogFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("unable to parse catalog file %q: %v", path, err)
+	}
+
+	for _, raw := range f.Services {
+		var s osb.Service
+		var fields fileFields
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("unable to parse catalog file %q: %v", path, err)
+		}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return fmt.Errorf("unable to parse catalog file %q: %v", path, err)
+		}
+		if err := c.ReplaceService(s); err != nil {
+			return fmt.Errorf("catalog file %q: %v", path, err)
+		}
+		if fields.Visibility != nil {
+			if err := c.SetVisibility(s.ID, *fields.Visibility); err != nil {
+				return fmt.Errorf("catalog file %q: %v", path, err)
+			}
+		}
+		for _, p := range fields.Plans {
+			if p.MaintenanceInfo != nil {
+				if err := c.SetMaintenanceInfo(p.ID, *p.MaintenanceInfo); err != nil {
+					return fmt.Errorf("catalog file %q: %v", path, err)
+				}
+			}
+			if p.MaximumPollingDuration != nil {
+				if err := c.SetMaximumPollingDuration(p.ID, time.Duration(*p.MaximumPollingDuration)*time.Second); err != nil {
+					return fmt.Errorf("catalog file %q: %v", path, err)
+				}
+			}
+			if p.Visibility != nil {
+				if err := c.SetVisibility(p.ID, *p.Visibility); err != nil {
+					return fmt.Errorf("catalog file %q: %v", path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package driver
+
+import "strings"
+
+// InstanceIDLabel is the label that drivers which create Kubernetes objects
+// put on the objects of an instance, with the instance's ID as its value.
+const InstanceIDLabel = "osb-starter-pack/instance-id"
+
+// BindingIDLabel is the label that drivers which create Kubernetes objects
+// put on the objects of a binding, with the binding's ID as its value.
+const BindingIDLabel = "osb-starter-pack/binding-id"
+
+// KubernetesName returns a name derived from an instance or binding ID that
+// is valid for any kind of Kubernetes object, including namespaces.
+func KubernetesName(id string) string {
+	name := "osb-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(id))
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
@@ -0,0 +1,329 @@
+// Package namespaces provides namespace-as-a-service: a driver.Driver whose
+// instances are Kubernetes namespaces. Each instance gets a namespace of its
+// own, limited by the ResourceQuota and LimitRange of its plan, and each
+// binding gets a ServiceAccount with a role in the namespace, whose token is
+// returned as the binding's credentials along with a kubeconfig that uses it.
+package namespaces // import "github.com/pmorie/osb-starter-pack/pkg/driver/namespaces"
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+const (
+	// quotaName and limitsName are the names of the ResourceQuota and
+	// LimitRange of an instance's namespace.
+	quotaName  = "osb-quota"
+	limitsName = "osb-limits"
+
+	// defaultRole is the ClusterRole a binding gets in the namespace unless
+	// its role parameter names another of roles.
+	defaultRole = "admin"
+
+	// tokenTimeout is how long Bind waits for Kubernetes to issue the token
+	// of a binding's ServiceAccount.
+	tokenTimeout = 30 * time.Second
+)
+
+// roles are the ClusterRoles a binding can ask for with its role parameter.
+var roles = map[string]bool{"admin": true, "edit": true, "view": true}
+
+// Driver creates a namespace for each instance.
+type Driver struct {
+	client kubernetes.Interface
+	server string
+}
+
+var _ driver.Driver = &Driver{}
+
+// New returns a Driver that manages namespaces with client. server is the URL
+// of the Kubernetes API server that the kubeconfigs returned as credentials
+// point to.
+func New(client kubernetes.Interface, server string) *Driver {
+	return &Driver{
+		client: client,
+		server: server,
+	}
+}
+
+// Name returns the name of the namespace of the instance with the given ID.
+func Name(instanceID string) string {
+	return driver.KubernetesName(instanceID)
+}
+
+func (d *Driver) Create(ctx context.Context, i *state.Instance) error {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   Name(i.ID),
+			Labels: map[string]string{driver.InstanceIDLabel: i.ID},
+		},
+	}
+	_, err := d.client.CoreV1().Namespaces().Create(ns)
+	if errors.IsAlreadyExists(err) {
+		// Create is repeated when the broker resumes an interrupted
+		// provision, but the namespace must be the instance's.
+		existing, err := d.client.CoreV1().Namespaces().Get(ns.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if existing.Labels[driver.InstanceIDLabel] != i.ID {
+			return fmt.Errorf("namespace %s already exists and does not belong to the instance", ns.Name)
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to create namespace %s: %v", ns.Name, err)
+	}
+
+	return d.Update(ctx, i)
+}
+
+// Update applies the quota and limits of the instance's plan to its
+// namespace.
+func (d *Driver) Update(ctx context.Context, i *state.Instance) error {
+	p := planByID(i.PlanID)
+	if p == nil {
+		return badRequest(fmt.Sprintf("The namespace service has no plan %q", i.PlanID))
+	}
+	namespace := Name(i.ID)
+	labels := map[string]string{driver.InstanceIDLabel: i.ID}
+
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: quotaName, Labels: labels},
+		Spec:       corev1.ResourceQuotaSpec{Hard: p.Quota},
+	}
+	quotas := d.client.CoreV1().ResourceQuotas(namespace)
+	existingQuota, err := quotas.Get(quotaName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = quotas.Create(quota)
+	} else if err == nil {
+		quota.ResourceVersion = existingQuota.ResourceVersion
+		_, err = quotas.Update(quota)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to apply ResourceQuota %s: %v", quotaName, err)
+	}
+
+	limits := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: limitsName, Labels: labels},
+		Spec:       corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{p.Limits}},
+	}
+	limitRanges := d.client.CoreV1().LimitRanges(namespace)
+	existingLimits, err := limitRanges.Get(limitsName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = limitRanges.Create(limits)
+	} else if err == nil {
+		limits.ResourceVersion = existingLimits.ResourceVersion
+		_, err = limitRanges.Update(limits)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to apply LimitRange %s: %v", limitsName, err)
+	}
+	return nil
+}
+
+// Delete deletes the instance's namespace, and with it everything in it.
+func (d *Driver) Delete(ctx context.Context, i *state.Instance) error {
+	ns, err := d.namespace(i)
+	if err != nil || ns == nil || ns.DeletionTimestamp != nil {
+		return err
+	}
+	err = d.client.CoreV1().Namespaces().Delete(ns.Name, &metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Bind creates a ServiceAccount with a role in the instance's namespace and
+// returns its token.
+func (d *Driver) Bind(ctx context.Context, i *state.Instance, b *state.Binding) (map[string]interface{}, error) {
+	role := defaultRole
+	if r, ok := b.Parameters["role"]; ok {
+		s, _ := r.(string)
+		if !roles[s] {
+			return nil, badRequest(fmt.Sprintf("The role parameter must be one of admin, edit or view, got %v", r))
+		}
+		role = s
+	}
+
+	namespace := Name(i.ID)
+	name := driver.KubernetesName(b.ID)
+	meta := metav1.ObjectMeta{
+		Name: name,
+		Labels: map[string]string{
+			driver.InstanceIDLabel: i.ID,
+			driver.BindingIDLabel:  b.ID,
+		},
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: meta}
+	if _, err := d.client.CoreV1().ServiceAccounts(namespace).Create(sa); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("unable to create ServiceAccount %s: %v", name, err)
+	}
+
+	rb := &rbacv1.RoleBinding{
+		ObjectMeta: meta,
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      name,
+			Namespace: namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     role,
+		},
+	}
+	if _, err := d.client.RbacV1().RoleBindings(namespace).Create(rb); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("unable to create RoleBinding %s: %v", name, err)
+	}
+
+	// Ask for the ServiceAccount's token with a Secret of our own, so its
+	// name is known and it is deleted with the binding.
+	secret := &corev1.Secret{
+		ObjectMeta: meta,
+		Type:       corev1.SecretTypeServiceAccountToken,
+	}
+	secret.Name = name + "-token"
+	secret.Annotations = map[string]string{corev1.ServiceAccountNameKey: name}
+	if _, err := d.client.CoreV1().Secrets(namespace).Create(secret); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("unable to create Secret %s: %v", secret.Name, err)
+	}
+	token, ca, err := d.waitForToken(ctx, namespace, secret.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfig, err := d.kubeconfig(namespace, name, token, ca)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"namespace":             namespace,
+		"server":                d.server,
+		"token":                 string(token),
+		"certificate_authority": string(ca),
+		"kubeconfig":            string(kubeconfig),
+	}, nil
+}
+
+// waitForToken waits for Kubernetes to fill in the token of a ServiceAccount
+// token Secret and returns the token and the cluster's CA certificate.
+func (d *Driver) waitForToken(ctx context.Context, namespace, name string) ([]byte, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, tokenTimeout)
+	defer cancel()
+	for {
+		secret, err := d.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if token := secret.Data[corev1.ServiceAccountTokenKey]; len(token) > 0 {
+			return token, secret.Data[corev1.ServiceAccountRootCAKey], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("the token of Secret %s was not issued: %v", name, ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// kubeconfig returns a kubeconfig file that authenticates with token and
+// defaults to namespace.
+func (d *Driver) kubeconfig(namespace, user string, token, ca []byte) ([]byte, error) {
+	config := clientcmdv1.Config{
+		Kind:       "Config",
+		APIVersion: "v1",
+		Clusters: []clientcmdv1.NamedCluster{{
+			Name: "cluster",
+			Cluster: clientcmdv1.Cluster{
+				Server:                   d.server,
+				CertificateAuthorityData: ca,
+			},
+		}},
+		AuthInfos: []clientcmdv1.NamedAuthInfo{{
+			Name:     user,
+			AuthInfo: clientcmdv1.AuthInfo{Token: string(token)},
+		}},
+		Contexts: []clientcmdv1.NamedContext{{
+			Name: namespace,
+			Context: clientcmdv1.Context{
+				Cluster:   "cluster",
+				AuthInfo:  user,
+				Namespace: namespace,
+			},
+		}},
+		CurrentContext: namespace,
+	}
+	return yaml.Marshal(config)
+}
+
+// Unbind deletes the binding's ServiceAccount, RoleBinding and token Secret.
+func (d *Driver) Unbind(ctx context.Context, i *state.Instance, b *state.Binding) error {
+	namespace := Name(i.ID)
+	name := driver.KubernetesName(b.ID)
+
+	err := d.client.RbacV1().RoleBindings(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete RoleBinding %s: %v", name, err)
+	}
+	err = d.client.CoreV1().Secrets(namespace).Delete(name+"-token", &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete Secret %s-token: %v", name, err)
+	}
+	err = d.client.CoreV1().ServiceAccounts(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete ServiceAccount %s: %v", name, err)
+	}
+	return nil
+}
+
+func (d *Driver) Status(ctx context.Context, i *state.Instance) (*driver.Status, error) {
+	ns, err := d.namespace(i)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ns == nil:
+		return &driver.Status{State: driver.Gone}, nil
+	case ns.Status.Phase == corev1.NamespaceTerminating:
+		return &driver.Status{State: driver.Pending, Description: fmt.Sprintf("Namespace %s is being deleted", ns.Name)}, nil
+	}
+	return &driver.Status{State: driver.Ready}, nil
+}
+
+// namespace returns the instance's namespace, or nil if it has none.
+func (d *Driver) namespace(i *state.Instance) (*corev1.Namespace, error) {
+	ns, err := d.client.CoreV1().Namespaces().Get(Name(i.ID), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ns.Labels[driver.InstanceIDLabel] != i.ID {
+		// The namespace was not created for the instance.
+		return nil, nil
+	}
+	return ns, nil
+}
+
+func badRequest(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
@@ -0,0 +1,125 @@
+package namespaces
+
+import (
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ServiceID is the ID of the namespace service in the catalog.
+const ServiceID = "5b3a3d6c-2f5e-4d0a-9a3e-7c1f0e6d8b42"
+
+// Plan is a size of namespace.
+type Plan struct {
+	ID          string
+	Name        string
+	Description string
+	// Quota is the ResourceQuota of the namespace.
+	Quota corev1.ResourceList
+	// Limits are the defaults and limits of the namespace's containers.
+	Limits corev1.LimitRangeItem
+}
+
+// Plans are the plans of the namespace service.
+var Plans = []Plan{
+	{
+		ID:          "0e1c5b9a-6b4f-4c43-8d0e-2a7f3c9d5e61",
+		Name:        "small",
+		Description: "A namespace with 2 CPUs, 4GiB of memory and 10GiB of storage",
+		Quota:       quota("1", "2Gi", "2", "4Gi", "10Gi", "10"),
+		Limits:      limits("100m", "128Mi", "500m", "512Mi"),
+	},
+	{
+		ID:          "7d2f8a41-3c6e-4b5a-9f1d-8e0b4c2a6f73",
+		Name:        "medium",
+		Description: "A namespace with 8 CPUs, 16GiB of memory and 50GiB of storage",
+		Quota:       quota("4", "8Gi", "8", "16Gi", "50Gi", "40"),
+		Limits:      limits("250m", "256Mi", "1", "1Gi"),
+	},
+	{
+		ID:          "c4a9e6b2-1f7d-4e3c-b5a8-6d2e9f0c1b84",
+		Name:        "large",
+		Description: "A namespace with 32 CPUs, 64GiB of memory and 200GiB of storage",
+		Quota:       quota("16", "32Gi", "32", "64Gi", "200Gi", "100"),
+		Limits:      limits("500m", "512Mi", "2", "2Gi"),
+	},
+}
+
+// Service returns the catalog entry of the namespace service.
+func Service() osb.Service {
+	updatable := true
+	s := osb.Service{
+		ID:            ServiceID,
+		Name:          "namespace",
+		Description:   "A Kubernetes namespace of your own, with a quota",
+		Bindable:      true,
+		PlanUpdatable: &updatable,
+		Metadata: map[string]interface{}{
+			"displayName": "Kubernetes namespace",
+		},
+	}
+	for _, p := range Plans {
+		free := true
+		s.Plans = append(s.Plans, osb.Plan{
+			ID:          p.ID,
+			Name:        p.Name,
+			Description: p.Description,
+			Free:        &free,
+			Schemas: &osb.Schemas{
+				ServiceBinding: &osb.ServiceBindingSchema{
+					Create: &osb.RequestResponseSchema{
+						InputParametersSchema: osb.InputParametersSchema{
+							Parameters: map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"role": map[string]interface{}{
+										"type":    "string",
+										"default": defaultRole,
+										"enum":    []string{"admin", "edit", "view"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	return s
+}
+
+func planByID(id string) *Plan {
+	for i := range Plans {
+		if Plans[i].ID == id {
+			return &Plans[i]
+		}
+	}
+	return nil
+}
+
+func quota(requestsCPU, requestsMemory, limitsCPU, limitsMemory, storage, pods string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceRequestsCPU:     resource.MustParse(requestsCPU),
+		corev1.ResourceRequestsMemory:  resource.MustParse(requestsMemory),
+		corev1.ResourceLimitsCPU:       resource.MustParse(limitsCPU),
+		corev1.ResourceLimitsMemory:    resource.MustParse(limitsMemory),
+		corev1.ResourceRequestsStorage: resource.MustParse(storage),
+		corev1.ResourcePods:            resource.MustParse(pods),
+	}
+}
+
+// limits returns the limits of containers, which get the given CPU and
+// memory requests and limits unless they set their own.
+func limits(requestCPU, requestMemory, limitCPU, limitMemory string) corev1.LimitRangeItem {
+	return corev1.LimitRangeItem{
+		Type: corev1.LimitTypeContainer,
+		DefaultRequest: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(requestCPU),
+			corev1.ResourceMemory: resource.MustParse(requestMemory),
+		},
+		Default: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(limitCPU),
+			corev1.ResourceMemory: resource.MustParse(limitMemory),
+		},
+	}
+}
@@ -0,0 +1,205 @@
+// Package secrets delivers the credentials of bindings in Kubernetes Secrets
+// rather than in bind responses, for consumers that read their credentials
+// from Secrets but do not use a platform that writes them. It wraps the
+// driver.Driver of a service: when a binding asks for it with the
+// credentials_secret parameter, or its plan has credentialsSecret set to
+// true in its metadata, the credentials the driver returns are written to a
+// Secret and the bind response only names the Secret. Unbinding deletes it.
+package secrets // import "github.com/pmorie/osb-starter-pack/pkg/driver/secrets"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+	"github.com/pmorie/osb-starter-pack/pkg/quota"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+const (
+	// Parameter is the bind parameter that asks for the binding's
+	// credentials to be written to a Secret.
+	Parameter = "credentials_secret"
+	// PlanMetadata is the key of the plan metadata that makes every binding
+	// of the plan get a Secret.
+	PlanMetadata = "credentialsSecret"
+
+	// NameKey and NamespaceKey are the keys of the credentials returned in
+	// place of the driver's, which name the Secret that holds them.
+	NameKey      = "secret_name"
+	NamespaceKey = "secret_namespace"
+)
+
+// Driver wraps a driver.Driver to write the credentials of bindings to
+// Secrets.
+type Driver struct {
+	driver.Driver
+	client kubernetes.Interface
+	plans  map[string]bool
+}
+
+// Wrap returns d wrapped to write the credentials of bindings to Secrets with
+// client. The plans of service whose metadata sets PlanMetadata to true always
+// do.
+func Wrap(d driver.Driver, client kubernetes.Interface, service osb.Service) *Driver {
+	plans := map[string]bool{}
+	for _, p := range service.Plans {
+		if enabled, _ := p.Metadata[PlanMetadata].(bool); enabled {
+			plans[p.ID] = true
+		}
+	}
+	return &Driver{
+		Driver: d,
+		client: client,
+		plans:  plans,
+	}
+}
+
+// Bind binds with the wrapped driver and, if the binding asks for a Secret,
+// writes the credentials to a Secret in the binding's namespace, or else the
+// instance's, named after the binding.
+func (d *Driver) Bind(ctx context.Context, i *state.Instance, b *state.Binding) (map[string]interface{}, error) {
+	enabled, err := d.enabled(b)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return d.Driver.Bind(ctx, i, b)
+	}
+
+	namespace := bindingNamespace(b)
+	if namespace == "" {
+		namespace = quota.Namespace(i)
+	}
+	if namespace == "" {
+		return nil, badRequest("Credentials can only be written to a Secret for bindings made from a Kubernetes namespace")
+	}
+
+	credentials, err := d.Driver.Bind(ctx, i, b)
+	if err != nil {
+		return nil, err
+	}
+	name := driver.KubernetesName(b.ID)
+	if err := d.write(i, b, namespace, name, credentials); err != nil {
+		err = fmt.Errorf("unable to write Secret %s/%s: %v", namespace, name, err)
+		if err := d.Driver.Unbind(ctx, i, b); err != nil {
+			log.With("binding_id", b.ID, "error", err).Error("unable to delete binding whose credentials were not written")
+		}
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		NameKey:      name,
+		NamespaceKey: namespace,
+	}, nil
+}
+
+// write creates or updates the Secret that holds the credentials of b.
+func (d *Driver) write(i *state.Instance, b *state.Binding, namespace, name string, credentials map[string]interface{}) error {
+	data, err := secretData(credentials)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				driver.InstanceIDLabel: i.ID,
+				driver.BindingIDLabel:  b.ID,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+
+	secrets := d.client.CoreV1().Secrets(namespace)
+	existing, err := secrets.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = secrets.Create(secret)
+		return err
+	} else if err != nil {
+		return err
+	}
+	if existing.Labels[driver.BindingIDLabel] != b.ID {
+		return fmt.Errorf("it already exists and does not belong to the binding")
+	}
+	// Bind is repeated if the broker failed to record the binding.
+	secret.ResourceVersion = existing.ResourceVersion
+	_, err = secrets.Update(secret)
+	return err
+}
+
+// Unbind deletes the binding's Secret, if it has one, and unbinds with the
+// wrapped driver.
+func (d *Driver) Unbind(ctx context.Context, i *state.Instance, b *state.Binding) error {
+	name, _ := b.Credentials[NameKey].(string)
+	namespace, _ := b.Credentials[NamespaceKey].(string)
+	if name != "" && namespace != "" {
+		err := d.client.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete Secret %s/%s: %v", namespace, name, err)
+		}
+	}
+	return d.Driver.Unbind(ctx, i, b)
+}
+
+// enabled reports whether the credentials of b go in a Secret.
+func (d *Driver) enabled(b *state.Binding) (bool, error) {
+	v, ok := b.Parameters[Parameter]
+	if !ok {
+		return d.plans[b.PlanID], nil
+	}
+	enabled, ok := v.(bool)
+	if !ok {
+		return false, badRequest(fmt.Sprintf("The %s parameter must be true or false", Parameter))
+	}
+	return enabled, nil
+}
+
+// secretData returns credentials as the data of a Secret. Strings are stored
+// as they are and other values as JSON.
+func secretData(credentials map[string]interface{}) (map[string][]byte, error) {
+	data := map[string][]byte{}
+	for k, v := range credentials {
+		if errs := validation.IsConfigMapKey(k); len(errs) > 0 {
+			return nil, fmt.Errorf("credential %q cannot be a key of a Secret: %s", k, errs[0])
+		}
+		if s, ok := v.(string); ok {
+			data[k] = []byte(s)
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = b
+	}
+	return data, nil
+}
+
+// bindingNamespace returns the Kubernetes namespace a binding was made from,
+// or "" if it was not made by Kubernetes.
+func bindingNamespace(b *state.Binding) string {
+	if platform, _ := b.Context["platform"].(string); platform != osb.PlatformKubernetes {
+		return ""
+	}
+	namespace, _ := b.Context["namespace"].(string)
+	return namespace
+}
+
+func badRequest(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
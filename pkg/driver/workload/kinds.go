@@ -0,0 +1,363 @@
+package workload
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// kind is a kind of object manifests can contain, with the typed client
+// calls that manage it.
+type kind struct {
+	gvk schema.GroupVersionKind
+
+	get    func(c kubernetes.Interface, namespace, name string) (metav1.Object, error)
+	create func(c kubernetes.Interface, namespace string, o metav1.Object) error
+	// update replaces existing with o. It copies fields the API server set
+	// and does not allow to change from existing to o.
+	update func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error
+	list   func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error)
+	del    func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error
+	// status reports whether an object is ready and why it is not. Objects
+	// of kinds without it are ready once they exist.
+	status func(o metav1.Object) (driver.State, string)
+}
+
+// kinds are the kinds of object manifests can contain.
+var kinds = []*kind{
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind("ServiceAccount"),
+		get: func(c kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			o, err := c.CoreV1().ServiceAccounts(namespace).Get(name, metav1.GetOptions{})
+			return o, err
+		},
+		create: func(c kubernetes.Interface, namespace string, o metav1.Object) error {
+			_, err := c.CoreV1().ServiceAccounts(namespace).Create(o.(*corev1.ServiceAccount))
+			return err
+		},
+		update: func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error {
+			sa := o.(*corev1.ServiceAccount)
+			// Keep the token secrets the token controller added.
+			sa.Secrets = existing.(*corev1.ServiceAccount).Secrets
+			_, err := c.CoreV1().ServiceAccounts(namespace).Update(sa)
+			return err
+		},
+		list: func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			l, err := c.CoreV1().ServiceAccounts(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]metav1.Object, len(l.Items))
+			for i := range l.Items {
+				objects[i] = &l.Items[i]
+			}
+			return objects, nil
+		},
+		del: func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error {
+			return c.CoreV1().ServiceAccounts(namespace).Delete(name, opts)
+		},
+	},
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		get: func(c kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			o, err := c.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+			return o, err
+		},
+		create: func(c kubernetes.Interface, namespace string, o metav1.Object) error {
+			_, err := c.CoreV1().ConfigMaps(namespace).Create(o.(*corev1.ConfigMap))
+			return err
+		},
+		update: func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error {
+			_, err := c.CoreV1().ConfigMaps(namespace).Update(o.(*corev1.ConfigMap))
+			return err
+		},
+		list: func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			l, err := c.CoreV1().ConfigMaps(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]metav1.Object, len(l.Items))
+			for i := range l.Items {
+				objects[i] = &l.Items[i]
+			}
+			return objects, nil
+		},
+		del: func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error {
+			return c.CoreV1().ConfigMaps(namespace).Delete(name, opts)
+		},
+	},
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind("Secret"),
+		get: func(c kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			o, err := c.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+			return o, err
+		},
+		create: func(c kubernetes.Interface, namespace string, o metav1.Object) error {
+			_, err := c.CoreV1().Secrets(namespace).Create(o.(*corev1.Secret))
+			return err
+		},
+		update: func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error {
+			_, err := c.CoreV1().Secrets(namespace).Update(o.(*corev1.Secret))
+			return err
+		},
+		list: func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			l, err := c.CoreV1().Secrets(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]metav1.Object, len(l.Items))
+			for i := range l.Items {
+				objects[i] = &l.Items[i]
+			}
+			return objects, nil
+		},
+		del: func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error {
+			return c.CoreV1().Secrets(namespace).Delete(name, opts)
+		},
+	},
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"),
+		get: func(c kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			o, err := c.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+			return o, err
+		},
+		create: func(c kubernetes.Interface, namespace string, o metav1.Object) error {
+			_, err := c.CoreV1().PersistentVolumeClaims(namespace).Create(o.(*corev1.PersistentVolumeClaim))
+			return err
+		},
+		update: func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error {
+			// The spec of a claim is immutable once it is bound, apart from
+			// the storage it requests.
+			pvc := o.(*corev1.PersistentVolumeClaim)
+			requests := pvc.Spec.Resources.Requests
+			pvc.Spec = existing.(*corev1.PersistentVolumeClaim).Spec
+			pvc.Spec.Resources.Requests = requests
+			_, err := c.CoreV1().PersistentVolumeClaims(namespace).Update(pvc)
+			return err
+		},
+		list: func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			l, err := c.CoreV1().PersistentVolumeClaims(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]metav1.Object, len(l.Items))
+			for i := range l.Items {
+				objects[i] = &l.Items[i]
+			}
+			return objects, nil
+		},
+		del: func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error {
+			return c.CoreV1().PersistentVolumeClaims(namespace).Delete(name, opts)
+		},
+		status: func(o metav1.Object) (driver.State, string) {
+			switch o.(*corev1.PersistentVolumeClaim).Status.Phase {
+			case corev1.ClaimBound:
+				return driver.Ready, ""
+			case corev1.ClaimLost:
+				return driver.Failed, "its volume was lost"
+			}
+			return driver.Pending, "not bound to a volume"
+		},
+	},
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind("Service"),
+		get: func(c kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			o, err := c.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+			return o, err
+		},
+		create: func(c kubernetes.Interface, namespace string, o metav1.Object) error {
+			_, err := c.CoreV1().Services(namespace).Create(o.(*corev1.Service))
+			return err
+		},
+		update: func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error {
+			svc := o.(*corev1.Service)
+			// The cluster IP cannot change once it is allocated.
+			svc.Spec.ClusterIP = existing.(*corev1.Service).Spec.ClusterIP
+			_, err := c.CoreV1().Services(namespace).Update(svc)
+			return err
+		},
+		list: func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			l, err := c.CoreV1().Services(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]metav1.Object, len(l.Items))
+			for i := range l.Items {
+				objects[i] = &l.Items[i]
+			}
+			return objects, nil
+		},
+		del: func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error {
+			return c.CoreV1().Services(namespace).Delete(name, opts)
+		},
+	},
+	{
+		gvk: appsv1.SchemeGroupVersion.WithKind("Deployment"),
+		get: func(c kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			o, err := c.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+			return o, err
+		},
+		create: func(c kubernetes.Interface, namespace string, o metav1.Object) error {
+			_, err := c.AppsV1().Deployments(namespace).Create(o.(*appsv1.Deployment))
+			return err
+		},
+		update: func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error {
+			_, err := c.AppsV1().Deployments(namespace).Update(o.(*appsv1.Deployment))
+			return err
+		},
+		list: func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			l, err := c.AppsV1().Deployments(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]metav1.Object, len(l.Items))
+			for i := range l.Items {
+				objects[i] = &l.Items[i]
+			}
+			return objects, nil
+		},
+		del: func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error {
+			return c.AppsV1().Deployments(namespace).Delete(name, opts)
+		},
+		status: func(o metav1.Object) (driver.State, string) {
+			d := o.(*appsv1.Deployment)
+			for _, c := range d.Status.Conditions {
+				if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+					return driver.Failed, c.Message
+				}
+			}
+			if d.Status.ObservedGeneration < d.Generation {
+				return driver.Pending, "rollout not yet started"
+			}
+			replicas := replicas(d.Spec.Replicas)
+			if d.Status.UpdatedReplicas < replicas {
+				return driver.Pending, fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, replicas)
+			}
+			if d.Status.AvailableReplicas < replicas {
+				return driver.Pending, fmt.Sprintf("%d of %d replicas available", d.Status.AvailableReplicas, replicas)
+			}
+			return driver.Ready, ""
+		},
+	},
+	{
+		gvk: appsv1.SchemeGroupVersion.WithKind("StatefulSet"),
+		get: func(c kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			o, err := c.AppsV1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+			return o, err
+		},
+		create: func(c kubernetes.Interface, namespace string, o metav1.Object) error {
+			_, err := c.AppsV1().StatefulSets(namespace).Create(o.(*appsv1.StatefulSet))
+			return err
+		},
+		update: func(c kubernetes.Interface, namespace string, o, existing metav1.Object) error {
+			_, err := c.AppsV1().StatefulSets(namespace).Update(o.(*appsv1.StatefulSet))
+			return err
+		},
+		list: func(c kubernetes.Interface, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			l, err := c.AppsV1().StatefulSets(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]metav1.Object, len(l.Items))
+			for i := range l.Items {
+				objects[i] = &l.Items[i]
+			}
+			return objects, nil
+		},
+		del: func(c kubernetes.Interface, namespace, name string, opts *metav1.DeleteOptions) error {
+			return c.AppsV1().StatefulSets(namespace).Delete(name, opts)
+		},
+		status: func(o metav1.Object) (driver.State, string) {
+			s := o.(*appsv1.StatefulSet)
+			if s.Status.ObservedGeneration < s.Generation {
+				return driver.Pending, "rollout not yet started"
+			}
+			replicas := replicas(s.Spec.Replicas)
+			if s.Status.UpdateRevision != "" && s.Status.CurrentRevision != s.Status.UpdateRevision {
+				return driver.Pending, fmt.Sprintf("%d of %d replicas updated", s.Status.UpdatedReplicas, replicas)
+			}
+			if s.Status.ReadyReplicas < replicas {
+				return driver.Pending, fmt.Sprintf("%d of %d replicas ready", s.Status.ReadyReplicas, replicas)
+			}
+			return driver.Ready, ""
+		},
+	},
+}
+
+// kindOf returns the kind with the given group, version and kind.
+func kindOf(gvk schema.GroupVersionKind) *kind {
+	for _, k := range kinds {
+		if k.gvk == gvk {
+			return k
+		}
+	}
+	return nil
+}
+
+// apply creates o, or updates it if it already exists. It refuses to update
+// an object that does not belong to the same instance.
+func (k *kind) apply(c kubernetes.Interface, namespace string, o metav1.Object) error {
+	existing, err := k.get(c, namespace, o.GetName())
+	if errors.IsNotFound(err) {
+		return k.create(c, namespace, o)
+	} else if err != nil {
+		return err
+	}
+
+	if owner := existing.GetLabels()[driver.InstanceIDLabel]; owner != o.GetLabels()[driver.InstanceIDLabel] {
+		return fmt.Errorf("it already exists and does not belong to the instance")
+	}
+	o.SetResourceVersion(existing.GetResourceVersion())
+	return k.update(c, namespace, o, existing)
+}
+
+// delete deletes the object with the given name, along with the objects it
+// owns, such as the pods of a Deployment. An object that does not exist is
+// already deleted.
+func (k *kind) delete(c kubernetes.Interface, namespace, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := k.del(c, namespace, name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// eachObject calls fn with every object that belongs to the instance i.
+func (d *Driver) eachObject(i *state.Instance, namespace string, fn func(k *kind, o metav1.Object) error) error {
+	opts := metav1.ListOptions{LabelSelector: driver.InstanceIDLabel + "=" + i.ID}
+	for _, k := range kinds {
+		objects, err := k.list(d.client, namespace, opts)
+		if err != nil {
+			return fmt.Errorf("unable to list %ss: %v", k.gvk.Kind, err)
+		}
+		for _, o := range objects {
+			if err := fn(k, o); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// each calls fn with the name of every object that belongs to the instance i.
+func (d *Driver) each(i *state.Instance, namespace string, fn func(k *kind, name string) error) error {
+	return d.eachObject(i, namespace, func(k *kind, o metav1.Object) error {
+		return fn(k, o.GetName())
+	})
+}
+
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
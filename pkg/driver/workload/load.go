@@ -0,0 +1,114 @@
+package workload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ServiceFile is the name of the file, in a service's directory, that holds
+// the service's catalog entry.
+const ServiceFile = "service.yaml"
+
+// CredentialsFile is the name of the file, in a plan's directory, that holds
+// the plan's credentials template. Every other YAML file in the directory is
+// a manifest template.
+const CredentialsFile = "credentials.yaml"
+
+// Load loads a service and the Driver of its plans from a directory laid out
+// as:
+//
+//	service.yaml          the service's catalog entry, in YAML or JSON
+//	<plan name>/*.yaml    the manifest templates of each plan, applied in
+//	                      the order of their file names
+//	<plan name>/credentials.yaml
+//	                      the plan's credentials template, if it has one
+func Load(client kubernetes.Interface, dir string) (*osb.Service, *Driver, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ServiceFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	service := &osb.Service{}
+	if err := yaml.Unmarshal(b, service); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse %s: %v", filepath.Join(dir, ServiceFile), err)
+	}
+
+	d := New(client)
+	for _, plan := range service.Plans {
+		p, err := loadPlan(filepath.Join(dir, plan.Name))
+		if err != nil {
+			return nil, nil, fmt.Errorf("plan %q of service %q: %v", plan.Name, service.Name, err)
+		}
+		d.AddPlan(plan.ID, p)
+	}
+	return service, d, nil
+}
+
+func loadPlan(dir string) (*Plan, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+
+	p := &Plan{}
+	for _, name := range names {
+		t, err := loadTemplate(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if name == CredentialsFile {
+			p.Credentials = t
+		} else {
+			p.Manifests = append(p.Manifests, t)
+		}
+	}
+	if len(p.Manifests) == 0 {
+		return nil, fmt.Errorf("%s has no manifests", dir)
+	}
+	return p, nil
+}
+
+func loadTemplate(path string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Funcs(Funcs).Option("missingkey=zero").Parse(string(b))
+}
+
+// Services returns the directories of the services in dir: its
+// subdirectories that have a service.yaml.
+func Services(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, f := range files {
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		if _, err := os.Stat(filepath.Join(path, ServiceFile)); err == nil {
+			dirs = append(dirs, path)
+		}
+	}
+	return dirs, nil
+}
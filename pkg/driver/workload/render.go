@@ -0,0 +1,94 @@
+package workload
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+)
+
+// object is an object rendered from a manifest.
+type object struct {
+	kind   *kind
+	name   string
+	object metav1.Object
+}
+
+func (o object) String() string {
+	return o.kind.gvk.Kind + " " + o.name
+}
+
+// render renders the manifests of a plan into the objects to apply, labeled
+// with the instance's ID and placed in its namespace.
+func (d *Driver) render(planID string, data *Data) ([]object, error) {
+	var objects []object
+	for _, t := range d.plans[planID].Manifests {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+
+		r := k8syaml.NewYAMLReader(bufio.NewReader(&buf))
+		for n := 1; ; n++ {
+			doc, err := r.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("unable to read %s: %v", t.Name(), err)
+			}
+			o, err := decode(doc, data)
+			if err != nil {
+				return nil, fmt.Errorf("document %d of %s: %v", n, t.Name(), err)
+			}
+			if o != nil {
+				objects = append(objects, *o)
+			}
+		}
+	}
+	return objects, nil
+}
+
+// decode decodes a YAML document into an object. It returns nil if the
+// document is empty.
+func decode(doc []byte, data *Data) (*object, error) {
+	j, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	if string(j) == "null" {
+		return nil, nil
+	}
+
+	decoded, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(j, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	k := kindOf(*gvk)
+	if k == nil {
+		return nil, fmt.Errorf("unsupported kind %s", gvk)
+	}
+	o := decoded.(metav1.Object)
+	if o.GetName() == "" {
+		return nil, fmt.Errorf("%s has no name", gvk.Kind)
+	}
+	if namespace := o.GetNamespace(); namespace != "" && namespace != data.Namespace {
+		return nil, fmt.Errorf("%s %s is in namespace %q, not the instance's namespace %q", gvk.Kind, o.GetName(), namespace, data.Namespace)
+	}
+	o.SetNamespace(data.Namespace)
+
+	labels := o.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[driver.InstanceIDLabel] = data.InstanceID
+	o.SetLabels(labels)
+
+	return &object{kind: k, name: o.GetName(), object: o}, nil
+}
@@ -0,0 +1,246 @@
+// Package workload provides a driver.Driver whose instances are Kubernetes
+// workloads. Each plan has a set of Go-templated manifests, which are rendered
+// with the instance's parameters and applied into the namespace the instance
+// was provisioned from. The instance is ready once every Deployment,
+// StatefulSet and PersistentVolumeClaim it created is, and deprovisioning it
+// deletes everything it created.
+//
+// Every object the driver creates is labeled with driver.InstanceIDLabel, which is
+// how it finds them again to prune, check and delete.
+//
+// Manifests can only contain the kinds listed in kinds.go: ServiceAccounts,
+// ConfigMaps, Secrets, PersistentVolumeClaims, Services, Deployments and
+// StatefulSets. The driver manages each with its typed client, so it can
+// keep the fields the API server sets when it updates them; rendering any
+// other kind fails. Supporting another kind means adding it there.
+package workload // import "github.com/pmorie/osb-starter-pack/pkg/driver/workload"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/quota"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Plan holds the templates of a plan.
+type Plan struct {
+	// Manifests are applied in order when an instance is created or updated.
+	// Each may render to several YAML documents.
+	Manifests []*template.Template
+	// Credentials, if set, renders to the YAML or JSON object returned as the
+	// credentials of a binding. Without it, bindings get the instance's name
+	// and namespace.
+	Credentials *template.Template
+}
+
+// Data is what templates are rendered with.
+type Data struct {
+	InstanceID string
+	// Name is a name derived from the instance ID that is valid for any
+	// kind of object, for templates to name or prefix their objects with.
+	Name       string
+	Namespace  string
+	ServiceID  string
+	PlanID     string
+	Parameters map[string]interface{}
+	// BindingID is only set when rendering credentials.
+	BindingID string
+}
+
+// Driver deploys the manifests of a service's plans.
+type Driver struct {
+	client kubernetes.Interface
+	plans  map[string]*Plan
+}
+
+var _ driver.Driver = &Driver{}
+
+// New returns a Driver that applies manifests with client. Add the plans it
+// provisions with AddPlan.
+func New(client kubernetes.Interface) *Driver {
+	return &Driver{
+		client: client,
+		plans:  map[string]*Plan{},
+	}
+}
+
+// AddPlan sets the templates of the plan with the given ID.
+func (d *Driver) AddPlan(planID string, p *Plan) {
+	d.plans[planID] = p
+}
+
+// Funcs are the functions templates can use in addition to the standard
+// ones: default returns its first argument if the second is empty, and json
+// encodes its argument as JSON, which is also valid YAML.
+var Funcs = template.FuncMap{
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return def
+		}
+		return value
+	},
+	"json": func(value interface{}) (string, error) {
+		b, err := json.Marshal(value)
+		return string(b), err
+	},
+}
+
+func (d *Driver) Create(ctx context.Context, i *state.Instance) error {
+	return d.apply(i)
+}
+
+func (d *Driver) Update(ctx context.Context, i *state.Instance) error {
+	return d.apply(i)
+}
+
+// apply renders the manifests of the instance's plan, applies them, and then
+// deletes any object the instance created earlier that they no longer
+// render, as when the instance changes plan.
+func (d *Driver) apply(i *state.Instance) error {
+	data, err := d.data(i)
+	if err != nil {
+		return err
+	}
+	objects, err := d.render(i.PlanID, data)
+	if err != nil {
+		return err
+	}
+
+	applied := map[string]bool{}
+	for _, o := range objects {
+		if err := o.kind.apply(d.client, data.Namespace, o.object); err != nil {
+			return fmt.Errorf("unable to apply %s: %v", o, err)
+		}
+		applied[o.String()] = true
+	}
+
+	return d.each(i, data.Namespace, func(k *kind, name string) error {
+		o := object{kind: k, name: name}
+		if applied[o.String()] {
+			return nil
+		}
+		if err := k.delete(d.client, data.Namespace, name); err != nil {
+			return fmt.Errorf("unable to delete %s: %v", o, err)
+		}
+		return nil
+	})
+}
+
+func (d *Driver) Delete(ctx context.Context, i *state.Instance) error {
+	namespace := quota.Namespace(i)
+	if namespace == "" {
+		// Nothing can have been created.
+		return nil
+	}
+	return d.each(i, namespace, func(k *kind, name string) error {
+		if err := k.delete(d.client, namespace, name); err != nil {
+			return fmt.Errorf("unable to delete %s: %v", object{kind: k, name: name}, err)
+		}
+		return nil
+	})
+}
+
+func (d *Driver) Bind(ctx context.Context, i *state.Instance, b *state.Binding) (map[string]interface{}, error) {
+	data, err := d.data(i)
+	if err != nil {
+		return nil, err
+	}
+	p := d.plans[i.PlanID]
+	if p.Credentials == nil {
+		return map[string]interface{}{
+			"name":      data.Name,
+			"namespace": data.Namespace,
+		}, nil
+	}
+
+	data.BindingID = b.ID
+	var buf bytes.Buffer
+	if err := p.Credentials.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	var credentials map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &credentials); err != nil {
+		return nil, fmt.Errorf("unable to decode credentials rendered by %s: %v", p.Credentials.Name(), err)
+	}
+	return credentials, nil
+}
+
+func (d *Driver) Unbind(ctx context.Context, i *state.Instance, b *state.Binding) error {
+	return nil
+}
+
+func (d *Driver) Status(ctx context.Context, i *state.Instance) (*driver.Status, error) {
+	namespace := quota.Namespace(i)
+	if namespace == "" {
+		return &driver.Status{State: driver.Gone}, nil
+	}
+
+	status := &driver.Status{State: driver.Gone}
+	var waiting []string
+	err := d.eachObject(i, namespace, func(k *kind, o metav1.Object) error {
+		if status.State == driver.Failed {
+			return nil
+		}
+		if status.State == driver.Gone {
+			status.State = driver.Ready
+		}
+		if k.status == nil {
+			return nil
+		}
+		s, reason := k.status(o)
+		switch s {
+		case driver.Failed:
+			status.State = driver.Failed
+			status.Description = fmt.Sprintf("%s failed: %s", object{kind: k, name: o.GetName()}, reason)
+		case driver.Pending:
+			waiting = append(waiting, fmt.Sprintf("%s: %s", object{kind: k, name: o.GetName()}, reason))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status.State == driver.Ready && len(waiting) > 0 {
+		status.State = driver.Pending
+		status.Description = "Waiting for " + strings.Join(waiting, "; ")
+	}
+	return status, nil
+}
+
+// data returns the data the templates of an instance are rendered with.
+func (d *Driver) data(i *state.Instance) (*Data, error) {
+	if _, ok := d.plans[i.PlanID]; !ok {
+		return nil, badRequest(fmt.Sprintf("Plan %q has no manifests", i.PlanID))
+	}
+	namespace := quota.Namespace(i)
+	if namespace == "" {
+		return nil, badRequest("Instances of this service must be provisioned from a Kubernetes namespace")
+	}
+	return &Data{
+		InstanceID: i.ID,
+		Name:       driver.KubernetesName(i.ID),
+		Namespace:  namespace,
+		ServiceID:  i.ServiceID,
+		PlanID:     i.PlanID,
+		Parameters: i.Parameters,
+	}, nil
+}
+
+func badRequest(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
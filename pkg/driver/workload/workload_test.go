@@ -0,0 +1,489 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/pmorie/osb-starter-pack/pkg/driver"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+const testNamespace = "team-a"
+
+// apiServer is an in-memory Kubernetes API server for the kinds the driver
+// manages. It stores what it is sent as is, so tests can set the status of
+// objects with an update.
+type apiServer struct {
+	t  *testing.T
+	mu sync.Mutex
+	// objects are keyed by their path, such as
+	// /apis/apps/v1/namespaces/team-a/deployments/web.
+	objects map[string]map[string]interface{}
+	version int
+}
+
+// resources maps the path of each resource the driver manages, without its
+// namespace, to its group version and kind.
+var resources = map[string][2]string{
+	"/api/v1/serviceaccounts":        {"v1", "ServiceAccount"},
+	"/api/v1/configmaps":             {"v1", "ConfigMap"},
+	"/api/v1/secrets":                {"v1", "Secret"},
+	"/api/v1/persistentvolumeclaims": {"v1", "PersistentVolumeClaim"},
+	"/api/v1/services":               {"v1", "Service"},
+	"/apis/apps/v1/deployments":      {"apps/v1", "Deployment"},
+	"/apis/apps/v1/statefulsets":     {"apps/v1", "StatefulSet"},
+}
+
+// newClient returns a clientset for a new apiServer.
+func newClient(t *testing.T) (kubernetes.Interface, *apiServer, func()) {
+	s := &apiServer{t: t, objects: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(s)
+	c, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL, QPS: 1000, Burst: 1000})
+	if err != nil {
+		t.Fatalf("NewForConfig: %v", err)
+	}
+	return c, s, server.Close
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Split /api/v1/namespaces/<namespace>/<resource>[/<name>] into the
+	// resource's path and the name.
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	prefix := 2
+	if parts[0] == "apis" {
+		prefix = 3
+	}
+	if len(parts) < prefix+3 || parts[prefix] != "namespaces" {
+		s.t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		http.NotFound(w, req)
+		return
+	}
+	resource := "/" + strings.Join(append(parts[:prefix:prefix], parts[prefix+2]), "/")
+	gvk, ok := resources[resource]
+	if !ok {
+		s.t.Errorf("request for unexpected resource %s", resource)
+		http.NotFound(w, req)
+		return
+	}
+	collection := "/" + strings.Join(parts[:prefix+3], "/")
+	var name string
+	if len(parts) > prefix+3 {
+		name = parts[prefix+3]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := collection + "/" + name
+	switch {
+	case req.Method == http.MethodGet && name == "":
+		selector, err := labels.Parse(req.URL.Query().Get("labelSelector"))
+		if err != nil {
+			s.t.Errorf("bad label selector: %v", err)
+		}
+		items := []interface{}{}
+		for _, k := range s.keys(collection + "/") {
+			if selector.Matches(labels.Set(objectLabels(s.objects[k]))) {
+				items = append(items, s.objects[k])
+			}
+		}
+		s.respond(w, http.StatusOK, map[string]interface{}{
+			"apiVersion": gvk[0],
+			"kind":       gvk[1] + "List",
+			"metadata":   map[string]interface{}{},
+			"items":      items,
+		})
+	case req.Method == http.MethodGet:
+		o, ok := s.objects[key]
+		if !ok {
+			s.notFound(w, name)
+			return
+		}
+		s.respond(w, http.StatusOK, o)
+	case req.Method == http.MethodPost, req.Method == http.MethodPut:
+		o := map[string]interface{}{}
+		if err := json.NewDecoder(req.Body).Decode(&o); err != nil {
+			s.t.Errorf("unable to decode %s %s: %v", req.Method, req.URL.Path, err)
+		}
+		metadata, _ := o["metadata"].(map[string]interface{})
+		if name == "" {
+			name, _ = metadata["name"].(string)
+			key += name
+		}
+		_, exists := s.objects[key]
+		if req.Method == http.MethodPost && exists {
+			s.status(w, http.StatusConflict, metav1.StatusReasonAlreadyExists, name)
+			return
+		}
+		if req.Method == http.MethodPut && !exists {
+			s.notFound(w, name)
+			return
+		}
+		s.version++
+		metadata["resourceVersion"] = strconv.Itoa(s.version)
+		s.objects[key] = o
+		s.respond(w, http.StatusOK, o)
+	case req.Method == http.MethodDelete:
+		if _, ok := s.objects[key]; !ok {
+			s.notFound(w, name)
+			return
+		}
+		delete(s.objects, key)
+		s.status(w, http.StatusOK, "", name)
+	default:
+		s.t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// keys returns the keys of the objects with the given prefix, sorted.
+func (s *apiServer) keys(prefix string) []string {
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// names returns the kinds and names of the stored objects, such as
+// "Deployment web".
+func (s *apiServer) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, k := range s.keys("/") {
+		o := s.objects[k]
+		metadata, _ := o["metadata"].(map[string]interface{})
+		names = append(names, o["kind"].(string)+" "+metadata["name"].(string))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *apiServer) respond(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.t.Errorf("unable to encode response: %v", err)
+	}
+}
+
+func (s *apiServer) status(w http.ResponseWriter, code int, reason metav1.StatusReason, name string) {
+	status := metav1.StatusSuccess
+	if code != http.StatusOK {
+		status = metav1.StatusFailure
+	}
+	s.respond(w, code, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   status,
+		Reason:   reason,
+		Code:     int32(code),
+		Details:  &metav1.StatusDetails{Name: name},
+	})
+}
+
+func (s *apiServer) notFound(w http.ResponseWriter, name string) {
+	s.status(w, http.StatusNotFound, metav1.StatusReasonNotFound, name)
+}
+
+func objectLabels(o map[string]interface{}) map[string]string {
+	metadata, _ := o["metadata"].(map[string]interface{})
+	l, _ := metadata["labels"].(map[string]interface{})
+	labels := map[string]string{}
+	for k, v := range l {
+		labels[k], _ = v.(string)
+	}
+	return labels
+}
+
+const (
+	configManifest = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{.Name}}-config
+data:
+  size: {{default "1Gi" .Parameters.size | json}}
+`
+	deploymentManifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{.Name}}
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: {{.Name}}
+  template:
+    metadata:
+      labels:
+        app: {{.Name}}
+    spec:
+      containers:
+      - name: web
+        image: nginx
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{.Name}}
+spec:
+  selector:
+    app: {{.Name}}
+  ports:
+  - port: 80
+`
+	claimManifest = `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: {{.Name}}-data
+spec:
+  accessModes: [ReadWriteOnce]
+  resources:
+    requests:
+      storage: 1Gi
+`
+)
+
+func testPlan(manifests ...string) *Plan {
+	p := &Plan{}
+	for i, m := range manifests {
+		name := strconv.Itoa(i) + ".yaml"
+		p.Manifests = append(p.Manifests, template.Must(template.New(name).Funcs(Funcs).Option("missingkey=zero").Parse(m)))
+	}
+	return p
+}
+
+func testInstance(id, planID string) *state.Instance {
+	return &state.Instance{
+		ID:        id,
+		ServiceID: "web",
+		PlanID:    planID,
+		Context: map[string]interface{}{
+			"platform":  "kubernetes",
+			"namespace": testNamespace,
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	d := New(nil)
+	d.AddPlan("small", testPlan(configManifest, "---\n", deploymentManifest))
+	i := testInstance("instance", "small")
+	i.Parameters = map[string]interface{}{"size": "5Gi"}
+	data, err := d.data(i)
+	if err != nil {
+		t.Fatalf("data: %v", err)
+	}
+
+	objects, err := d.render("small", data)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.String())
+		if o.object.GetNamespace() != testNamespace {
+			t.Errorf("%s is in namespace %q, want %q", o, o.object.GetNamespace(), testNamespace)
+		}
+		if id := o.object.GetLabels()[driver.InstanceIDLabel]; id != "instance" {
+			t.Errorf("%s is labeled with instance %q, want %q", o, id, "instance")
+		}
+	}
+	want := "ConfigMap osb-instance-config, Deployment osb-instance, Service osb-instance"
+	if got := strings.Join(names, ", "); got != want {
+		t.Errorf("rendered %s, want %s", got, want)
+	}
+	if size := objects[0].object.(*corev1.ConfigMap).Data["size"]; size != "5Gi" {
+		t.Errorf("rendered size %q, want %q", size, "5Gi")
+	}
+
+	for manifest, want := range map[string]string{
+		"apiVersion: v1\nkind: Pod\nmetadata:\n  name: pod\n":                         "unsupported kind",
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\n  namespace: other\n": "not the instance's namespace",
+		"apiVersion: v1\nkind: ConfigMap\n":                                           "has no name",
+	} {
+		d.AddPlan("bad", testPlan(manifest))
+		if _, err := d.render("bad", data); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("render of %q returned %v, want an error containing %q", manifest, err, want)
+		}
+	}
+
+	if _, err := d.data(testInstance("instance", "none")); err == nil {
+		t.Error("data of an instance of a plan without manifests succeeded")
+	}
+	cf := testInstance("instance", "small")
+	cf.Context = map[string]interface{}{"platform": "cloudfoundry"}
+	if _, err := d.data(cf); err == nil {
+		t.Error("data of an instance provisioned from Cloud Foundry succeeded")
+	}
+}
+
+func TestApply(t *testing.T) {
+	c, s, closeServer := newClient(t)
+	defer closeServer()
+	d := New(c)
+	d.AddPlan("small", testPlan(configManifest, deploymentManifest))
+	d.AddPlan("large", testPlan(deploymentManifest, claimManifest))
+	ctx := context.Background()
+
+	i := testInstance("instance", "small")
+	if err := d.Create(ctx, i); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	want := "ConfigMap osb-instance-config, Deployment osb-instance, Service osb-instance"
+	if got := strings.Join(s.names(), ", "); got != want {
+		t.Errorf("created %s, want %s", got, want)
+	}
+
+	// Updating keeps the cluster IP the API server allocated.
+	svc, err := c.CoreV1().Services(testNamespace).Get("osb-instance", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Spec.ClusterIP = "10.0.0.10"
+	if _, err := c.CoreV1().Services(testNamespace).Update(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing plan applies the new plan's manifests and deletes what the old
+	// one created that the new one does not.
+	i.PlanID = "large"
+	if err := d.Update(ctx, i); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	want = "Deployment osb-instance, PersistentVolumeClaim osb-instance-data, Service osb-instance"
+	if got := strings.Join(s.names(), ", "); got != want {
+		t.Errorf("after update, have %s, want %s", got, want)
+	}
+	svc, err = c.CoreV1().Services(testNamespace).Get("osb-instance", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.ClusterIP != "10.0.0.10" {
+		t.Errorf("cluster IP changed to %q on update", svc.Spec.ClusterIP)
+	}
+
+	// An object of the same name that belongs to something else is not
+	// taken over.
+	if _, err := c.CoreV1().ConfigMaps(testNamespace).Create(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "osb-other-config"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(ctx, testInstance("other", "small")); err == nil {
+		t.Error("Create over an object that does not belong to the instance succeeded")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	c, _, closeServer := newClient(t)
+	defer closeServer()
+	d := New(c)
+	d.AddPlan("large", testPlan(deploymentManifest, claimManifest))
+	ctx := context.Background()
+	i := testInstance("instance", "large")
+
+	status := func(want driver.State) *driver.Status {
+		s, err := d.Status(ctx, i)
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		if s.State != want {
+			t.Errorf("got state %q (%s), want %q", s.State, s.Description, want)
+		}
+		return s
+	}
+
+	status(driver.Gone)
+	if err := d.Create(ctx, i); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	s := status(driver.Pending)
+	for _, want := range []string{"Deployment osb-instance: 0 of 2 replicas updated", "PersistentVolumeClaim osb-instance-data: not bound"} {
+		if !strings.Contains(s.Description, want) {
+			t.Errorf("description %q does not contain %q", s.Description, want)
+		}
+	}
+
+	deployment, err := c.AppsV1().Deployments(testNamespace).Get("osb-instance", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment.Status = appsv1.DeploymentStatus{UpdatedReplicas: 2, AvailableReplicas: 2}
+	if _, err := c.AppsV1().Deployments(testNamespace).Update(deployment); err != nil {
+		t.Fatal(err)
+	}
+	claim, err := c.CoreV1().PersistentVolumeClaims(testNamespace).Get("osb-instance-data", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim.Status.Phase = corev1.ClaimBound
+	if claim, err = c.CoreV1().PersistentVolumeClaims(testNamespace).Update(claim); err != nil {
+		t.Fatal(err)
+	}
+	status(driver.Ready)
+
+	claim.Status.Phase = corev1.ClaimLost
+	if _, err := c.CoreV1().PersistentVolumeClaims(testNamespace).Update(claim); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(driver.Failed); !strings.Contains(s.Description, "its volume was lost") {
+		t.Errorf("description %q does not say the volume was lost", s.Description)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	c, s, closeServer := newClient(t)
+	defer closeServer()
+	d := New(c)
+	d.AddPlan("small", testPlan(configManifest, deploymentManifest))
+	ctx := context.Background()
+
+	if err := d.Create(ctx, testInstance("instance", "small")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := d.Create(ctx, testInstance("other", "small")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := d.Delete(ctx, testInstance("instance", "small")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want := "ConfigMap osb-other-config, Deployment osb-other, Service osb-other"
+	if got := strings.Join(s.names(), ", "); got != want {
+		t.Errorf("after delete, have %s, want %s", got, want)
+	}
+
+	// Deleting again finds nothing to delete.
+	if err := d.Delete(ctx, testInstance("instance", "small")); err != nil {
+		t.Errorf("second Delete: %v", err)
+	}
+	cf := testInstance("instance", "small")
+	cf.Context = nil
+	if err := d.Delete(ctx, cf); err != nil {
+		t.Errorf("Delete of an instance without a namespace: %v", err)
+	}
+	// A kind's delete of an object that is already gone succeeds.
+	if err := kindOf(appsv1.SchemeGroupVersion.WithKind("Deployment")).delete(c, testNamespace, "osb-instance"); err != nil {
+		t.Errorf("delete of a deleted Deployment: %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+// Package health serves the broker's readiness and liveness endpoints from a
+// registry of named checks. Parts of the broker, such as the business logic,
+// the state store and the Kubernetes client, register the checks that tell
+// whether they can serve requests (readiness) and whether they are still
+// working at all (liveness). A check that does not finish within the
+// Registry's Timeout is reported as failed, even if it ignores its context.
+package health // import "github.com/pmorie/osb-starter-pack/pkg/health"
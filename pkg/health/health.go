@@ -0,0 +1,203 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// ReadyzPath is the path of the readiness endpoint.
+	ReadyzPath = "/readyz"
+	// LivezPath is the path of the liveness endpoint.
+	LivezPath = "/livez"
+	// HealthzPath is the path of the legacy health endpoint served by
+	// osb-broker-lib.
+	HealthzPath = "/healthz"
+)
+
+// Checker checks the health of one part of the broker and returns an error if
+// it is unhealthy.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f.
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Registry holds the readiness and liveness checks of the broker.
+type Registry struct {
+	// Timeout bounds how long a single check may run.
+	Timeout time.Duration
+
+	mu        sync.RWMutex
+	readiness []namedCheck
+	liveness  []namedCheck
+
+	// parent and prefix are set on the registries Sub returns, which add
+	// their checks to parent.
+	parent *Registry
+	prefix string
+}
+
+type namedCheck struct {
+	name    string
+	checker Checker
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{Timeout: 5 * time.Second}
+}
+
+// Sub returns a Registry that adds its checks to r, with names prefixed by
+// prefix and a slash, for one of several brokers served together. Its
+// handlers serve all of r's checks.
+func (r *Registry) Sub(prefix string) *Registry {
+	return &Registry{parent: r, prefix: prefix}
+}
+
+// root returns the Registry that holds r's checks.
+func (r *Registry) root() *Registry {
+	if r.parent != nil {
+		return r.parent.root()
+	}
+	return r
+}
+
+// AddReadinessCheck registers a check that must pass for the broker to be
+// ready to serve requests.
+func (r *Registry) AddReadinessCheck(name string, c Checker) {
+	if r.parent != nil {
+		r.parent.AddReadinessCheck(r.prefix+"/"+name, c)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness = append(r.readiness, namedCheck{name: name, checker: c})
+}
+
+// AddLivenessCheck registers a check that must pass for the broker to be
+// considered alive. A failing liveness check will get the broker restarted,
+// so only register checks that a restart can fix.
+func (r *Registry) AddLivenessCheck(name string, c Checker) {
+	if r.parent != nil {
+		r.parent.AddLivenessCheck(r.prefix+"/"+name, c)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness = append(r.liveness, namedCheck{name: name, checker: c})
+}
+
+// Status is the body of a readiness or liveness response.
+type Status struct {
+	// Status is "ok" if every check passed and "failed" otherwise.
+	Status string        `json:"status"`
+	Checks []CheckStatus `json:"checks"`
+}
+
+// CheckStatus is the result of a single check.
+type CheckStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadyzHandler returns the handler for the readiness endpoint.
+func (r *Registry) ReadyzHandler() http.Handler {
+	r = r.root()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.RLock()
+		checks := r.readiness
+		r.mu.RUnlock()
+		r.serve(w, req, checks)
+	})
+}
+
+// LivezHandler returns the handler for the liveness endpoint.
+func (r *Registry) LivezHandler() http.Handler {
+	r = r.root()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.RLock()
+		checks := r.liveness
+		r.mu.RUnlock()
+		r.serve(w, req, checks)
+	})
+}
+
+// serve runs checks concurrently and writes their results, with a 503 Service
+// Unavailable status if any of them failed.
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request, checks []namedCheck) {
+	ctx, cancel := context.WithTimeout(req.Context(), r.Timeout)
+	defer cancel()
+
+	status := Status{
+		Status: "ok",
+		Checks: make([]CheckStatus, len(checks)),
+	}
+
+	// Checks that ignore ctx may outlive it; they are reported as failed
+	// rather than holding up the response.
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(checks))
+	for i := range checks {
+		status.Checks[i] = CheckStatus{Name: checks[i].name, Status: "failed", Error: "the check did not finish in time"}
+		go func(i int) {
+			results <- result{i: i, err: checks[i].checker.Check(ctx)}
+		}(i)
+	}
+
+collect:
+	for pending := len(checks); pending > 0; pending-- {
+		select {
+		case r := <-results:
+			status.Checks[r.i] = CheckStatus{Name: checks[r.i].name, Status: "ok"}
+			if r.err != nil {
+				status.Checks[r.i].Status = "failed"
+				status.Checks[r.i].Error = r.err.Error()
+			}
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	code := http.StatusOK
+	for _, c := range status.Checks {
+		if c.Status != "ok" {
+			status.Status = "failed"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// Exempt wraps an authentication middleware so that requests for the health
+// endpoints bypass it, the way TokenReviewMiddleware lets requests for
+// /healthz through. Probes from the kubelet carry no credentials.
+func Exempt(middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case HealthzPath, ReadyzPath, LivezPath:
+				next.ServeHTTP(w, r)
+			default:
+				authenticated.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+// Package identity reads who a request is made by out of the originating
+// identity platforms send with OSB requests, for logging, auditing and
+// authorizing the request.
+package identity // import "github.com/pmorie/osb-starter-pack/pkg/identity"
+
+import (
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Parse returns the platform of an originating identity and the user and
+// groups the platform sent: the Kubernetes username and groups, or the Cloud
+// Foundry user_id, which comes without groups. The user and groups are empty
+// if the platform is not one of these or its value cannot be parsed, and
+// everything is empty if o is nil.
+func Parse(o *osb.OriginatingIdentity) (platform, user string, groups []string) {
+	if o == nil {
+		return "", "", nil
+	}
+
+	identity, err := broker.ParseIdentity(*o)
+	if err != nil {
+		return o.Platform, "", nil
+	}
+	switch {
+	case identity.Kubernetes != nil:
+		return o.Platform, identity.Kubernetes.Username, identity.Kubernetes.Groups
+	case identity.CloudFoundry != nil:
+		return o.Platform, identity.CloudFoundry.UserID, nil
+	}
+	return o.Platform, "", nil
+}
@@ -0,0 +1,34 @@
+package identity
+
+import (
+	"reflect"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		identity *osb.OriginatingIdentity
+		platform string
+		user     string
+		groups   []string
+	}{
+		{nil, "", "", nil},
+		{
+			&osb.OriginatingIdentity{Platform: "kubernetes", Value: `{"username":"alice","uid":"1","groups":["admins","devs"]}`},
+			"kubernetes", "alice", []string{"admins", "devs"},
+		},
+		{
+			&osb.OriginatingIdentity{Platform: "cloudfoundry", Value: `{"user_id":"f7c7e3b2"}`},
+			"cloudfoundry", "f7c7e3b2", nil,
+		},
+		{&osb.OriginatingIdentity{Platform: "kubernetes", Value: `not json`}, "kubernetes", "", nil},
+		{&osb.OriginatingIdentity{Platform: "other", Value: `{}`}, "other", "", nil},
+	} {
+		platform, user, groups := Parse(tc.identity)
+		if platform != tc.platform || user != tc.user || !reflect.DeepEqual(groups, tc.groups) {
+			t.Errorf("Parse(%+v) = %q, %q, %v, want %q, %q, %v", tc.identity, platform, user, groups, tc.platform, tc.user, tc.groups)
+		}
+	}
+}
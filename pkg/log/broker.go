@@ -0,0 +1,174 @@
+package log
+
+import (
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+
+	"github.com/pmorie/osb-starter-pack/pkg/identity"
+)
+
+// Broker wraps a broker.Interface and logs every operation with its
+// request-scoped fields: the action, instance and binding IDs, service and
+// plan IDs, originating identity and the latency of the call. A Logger
+// carrying those fields is attached to the request's context before the
+// wrapped Interface is called, so entries logged through ForRequest carry them
+// as well.
+type Broker struct {
+	broker.Interface
+
+	Logger *Logger
+}
+
+var _ broker.Interface = &Broker{}
+
+// NewBroker returns a Broker that wraps b and logs through l.
+func NewBroker(b broker.Interface, l *Logger) *Broker {
+	return &Broker{
+		Interface: b,
+		Logger:    l,
+	}
+}
+
+// ForRequest returns the Logger attached to the request in c, or the default
+// Logger if there is none.
+func ForRequest(c *broker.RequestContext) *Logger {
+	if c == nil || c.Request == nil {
+		return std
+	}
+	return FromContext(c.Request.Context())
+}
+
+func (b *Broker) GetCatalog(c *broker.RequestContext) (*broker.CatalogResponse, error) {
+	l := b.begin(c, "get_catalog")
+	start := time.Now()
+	response, err := b.Interface.GetCatalog(c)
+	b.end(l, start, err)
+	return response, err
+}
+
+func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
+	l := b.begin(c, "provision",
+		"instance_id", request.InstanceID,
+		"service_id", request.ServiceID,
+		"plan_id", request.PlanID,
+		"originating_identity", Identity(request.OriginatingIdentity),
+	)
+	start := time.Now()
+	response, err := b.Interface.Provision(request, c)
+	b.end(l, start, err)
+	return response, err
+}
+
+func (b *Broker) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
+	l := b.begin(c, "deprovision",
+		"instance_id", request.InstanceID,
+		"service_id", request.ServiceID,
+		"plan_id", request.PlanID,
+		"originating_identity", Identity(request.OriginatingIdentity),
+	)
+	start := time.Now()
+	response, err := b.Interface.Deprovision(request, c)
+	b.end(l, start, err)
+	return response, err
+}
+
+func (b *Broker) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
+	l := b.begin(c, "last_operation",
+		"instance_id", request.InstanceID,
+		"service_id", stringValue(request.ServiceID),
+		"plan_id", stringValue(request.PlanID),
+		"originating_identity", Identity(request.OriginatingIdentity),
+	)
+	start := time.Now()
+	response, err := b.Interface.LastOperation(request, c)
+	b.end(l, start, err)
+	return response, err
+}
+
+func (b *Broker) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
+	l := b.begin(c, "bind",
+		"instance_id", request.InstanceID,
+		"binding_id", request.BindingID,
+		"service_id", request.ServiceID,
+		"plan_id", request.PlanID,
+		"originating_identity", Identity(request.OriginatingIdentity),
+	)
+	start := time.Now()
+	response, err := b.Interface.Bind(request, c)
+	b.end(l, start, err)
+	return response, err
+}
+
+func (b *Broker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
+	l := b.begin(c, "unbind",
+		"instance_id", request.InstanceID,
+		"binding_id", request.BindingID,
+		"service_id", request.ServiceID,
+		"plan_id", request.PlanID,
+		"originating_identity", Identity(request.OriginatingIdentity),
+	)
+	start := time.Now()
+	response, err := b.Interface.Unbind(request, c)
+	b.end(l, start, err)
+	return response, err
+}
+
+func (b *Broker) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
+	l := b.begin(c, "update",
+		"instance_id", request.InstanceID,
+		"service_id", request.ServiceID,
+		"plan_id", stringValue(request.PlanID),
+		"originating_identity", Identity(request.OriginatingIdentity),
+	)
+	start := time.Now()
+	response, err := b.Interface.Update(request, c)
+	b.end(l, start, err)
+	return response, err
+}
+
+// begin derives the request-scoped Logger for an operation and attaches it to
+// the request in c. Fields the request left empty are omitted.
+func (b *Broker) begin(c *broker.RequestContext, action string, keysAndValues ...interface{}) *Logger {
+	fields := []interface{}{"action", action}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i+1] != "" {
+			fields = append(fields, keysAndValues[i], keysAndValues[i+1])
+		}
+	}
+	l := b.Logger.With(fields...)
+	if c != nil && c.Request != nil {
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), l))
+	}
+	return l
+}
+
+func (b *Broker) end(l *Logger, start time.Time, err error) {
+	l = l.With("latency", time.Since(start))
+	if err != nil {
+		if httpErr, ok := osb.IsHTTPError(err); ok {
+			l = l.With("status", httpErr.StatusCode)
+		}
+		l.With("error", err).Error("request failed")
+		return
+	}
+	l.Info("request completed")
+}
+
+// Identity returns a short, loggable form of an originating identity:
+// the platform followed by the user name or ID the platform sent.
+func Identity(o *osb.OriginatingIdentity) string {
+	platform, user, _ := identity.Parse(o)
+	if user == "" {
+		return platform
+	}
+	return platform + "/" + user
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,9 @@
+// Package log provides the structured logger used by the broker skeleton and
+// the broker's business logic. Entries are written as JSON or logfmt so they
+// can be consumed by log pipelines, and verbosity follows glog's -v flag:
+//
+//	log.V(4).With("instance_id", id).Info("provisioning instance")
+//
+// Loggers carrying request-scoped fields are attached to a request's context by
+// Broker and can be retrieved with FromContext.
+package log // import "github.com/pmorie/osb-starter-pack/pkg/log"
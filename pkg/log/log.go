@@ -0,0 +1,330 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// FormatJSON writes one JSON object per entry.
+	FormatJSON = "json"
+	// FormatLogfmt writes one line of key=value pairs per entry.
+	FormatLogfmt = "logfmt"
+)
+
+// Logger writes structured log entries. Each entry carries a timestamp, a
+// level, a message and the fields added to the Logger with With. Loggers are
+// safe for concurrent use and cheap to derive from one another.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	format string
+	fields []field
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// New returns a Logger that writes entries in the given format to out.
+func New(out io.Writer, format string) (*Logger, error) {
+	switch format {
+	case FormatJSON, FormatLogfmt:
+	default:
+		return nil, fmt.Errorf("unknown log format %q, must be one of %q or %q", format, FormatJSON, FormatLogfmt)
+	}
+
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		format: format,
+	}, nil
+}
+
+// With returns a Logger that adds the given key/value pairs to every entry.
+// Keys must be strings; a value for a key already set on the Logger replaces
+// the previous one.
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(keysAndValues)/2)
+	copy(fields, l.fields)
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+
+		replaced := false
+		for j := range fields {
+			if fields[j].key == key {
+				fields[j].value = value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			fields = append(fields, field{key: key, value: value})
+		}
+	}
+
+	return &Logger{
+		mu:     l.mu,
+		out:    l.out,
+		format: l.format,
+		fields: fields,
+	}
+}
+
+// Info logs a message at the info level.
+func (l *Logger) Info(msg string) {
+	l.write("info", msg)
+}
+
+// Infof logs a formatted message at the info level.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write("info", fmt.Sprintf(format, args...))
+}
+
+// Warning logs a message at the warning level.
+func (l *Logger) Warning(msg string) {
+	l.write("warning", msg)
+}
+
+// Warningf logs a formatted message at the warning level.
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.write("warning", fmt.Sprintf(format, args...))
+}
+
+// Error logs a message at the error level.
+func (l *Logger) Error(msg string) {
+	l.write("error", msg)
+}
+
+// Errorf logs a formatted message at the error level.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write("error", fmt.Sprintf(format, args...))
+}
+
+// Fatal logs a message at the fatal level and exits the program.
+func (l *Logger) Fatal(msg string) {
+	l.write("fatal", msg)
+	glog.Flush()
+	os.Exit(255)
+}
+
+// Fatalf logs a formatted message at the fatal level and exits the program.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.Fatal(fmt.Sprintf(format, args...))
+}
+
+// Verbose is returned by V and only writes entries if the verbosity level it
+// was created with is enabled.
+type Verbose struct {
+	l       *Logger
+	enabled bool
+}
+
+// V reports whether the given verbosity level is enabled by glog's -v flag
+// and returns a Verbose that logs through l if it is.
+func (l *Logger) V(level glog.Level) Verbose {
+	return Verbose{l: l, enabled: bool(glog.V(level))}
+}
+
+// Enabled reports whether entries logged through v will be written.
+func (v Verbose) Enabled() bool {
+	return v.enabled
+}
+
+// With returns a Verbose whose logger adds the given key/value pairs.
+func (v Verbose) With(keysAndValues ...interface{}) Verbose {
+	if !v.enabled {
+		return v
+	}
+	return Verbose{l: v.l.With(keysAndValues...), enabled: true}
+}
+
+// Info logs a message at the info level if v is enabled.
+func (v Verbose) Info(msg string) {
+	if v.enabled {
+		v.l.Info(msg)
+	}
+}
+
+// Infof logs a formatted message at the info level if v is enabled.
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		v.l.Infof(format, args...)
+	}
+}
+
+func (l *Logger) write(level, msg string) {
+	entry := make([]field, 0, len(l.fields)+3)
+	entry = append(entry,
+		field{key: "ts", value: time.Now().UTC().Format(time.RFC3339Nano)},
+		field{key: "level", value: level},
+		field{key: "msg", value: msg},
+	)
+	entry = append(entry, l.fields...)
+
+	var buf bytes.Buffer
+	if l.format == FormatJSON {
+		writeJSON(&buf, entry)
+	} else {
+		writeLogfmt(&buf, entry)
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, entry []field) {
+	buf.WriteByte('{')
+	for i, f := range entry {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(jsonValue(f.value))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprintf("%+v", f.value))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+// jsonValue converts values that encoding/json would render unhelpfully into
+// their string form.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return v
+}
+
+func writeLogfmt(buf *bytes.Buffer, entry []field) {
+	for i, f := range entry {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(f.key)
+		buf.WriteByte('=')
+
+		var s string
+		switch t := f.value.(type) {
+		case string:
+			s = t
+		case error:
+			s = t.Error()
+		case fmt.Stringer:
+			s = t.String()
+		case nil:
+			s = ""
+		default:
+			s = fmt.Sprintf("%+v", t)
+		}
+		if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+var std = &Logger{
+	mu:     &sync.Mutex{},
+	out:    os.Stderr,
+	format: FormatLogfmt,
+}
+
+// SetDefault replaces the Logger used by the package-level functions and
+// returned by FromContext when a context carries no Logger.
+func SetDefault(l *Logger) {
+	std = l
+}
+
+// Default returns the Logger used by the package-level functions.
+func Default() *Logger {
+	return std
+}
+
+// With returns a Logger derived from the default Logger that adds the given
+// key/value pairs to every entry.
+func With(keysAndValues ...interface{}) *Logger {
+	return std.With(keysAndValues...)
+}
+
+// V reports whether the given verbosity level is enabled and returns a
+// Verbose that logs through the default Logger.
+func V(level glog.Level) Verbose {
+	return std.V(level)
+}
+
+// Info logs a message at the info level with the default Logger.
+func Info(msg string) {
+	std.Info(msg)
+}
+
+// Infof logs a formatted message at the info level with the default Logger.
+func Infof(format string, args ...interface{}) {
+	std.Infof(format, args...)
+}
+
+// Warningf logs a formatted message at the warning level with the default
+// Logger.
+func Warningf(format string, args ...interface{}) {
+	std.Warningf(format, args...)
+}
+
+// Error logs a message at the error level with the default Logger.
+func Error(msg string) {
+	std.Error(msg)
+}
+
+// Errorf logs a formatted message at the error level with the default Logger.
+func Errorf(format string, args ...interface{}) {
+	std.Errorf(format, args...)
+}
+
+// Fatal logs a message at the fatal level with the default Logger and exits
+// the program.
+func Fatal(msg string) {
+	std.Fatal(msg)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the Logger carried by ctx, or the default Logger if ctx
+// carries none.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return std
+}
@@ -0,0 +1,12 @@
+// Package maintenance implements the maintenance_info of the OSB API, which
+// lets platforms upgrade instances to a new version of the broker's plans.
+// Each plan may have an Info whose version changes whenever an update to the
+// broker changes what instances of the plan get. Platforms pass the version
+// they saw in the catalog when they provision an instance, and pass a newer
+// one in an update request to have the instance upgraded.
+//
+// The OSB libraries the broker is built on predate maintenance_info, so
+// Middleware decodes it from provision and update requests for the business
+// logic to read with FromRequest, and catalog.Middleware adds each plan's Info
+// to the catalog.
+package maintenance // import "github.com/pmorie/osb-starter-pack/pkg/maintenance"
@@ -0,0 +1,70 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Info is the maintenance_info of a plan, or of a provision or update request.
+type Info struct {
+	// Version is the version of the plan, which should follow semantic
+	// versioning.
+	Version string `json:"version"`
+	// Description describes what changed in this version, for the platform to
+	// show to users deciding whether to upgrade.
+	Description string `json:"description,omitempty"`
+}
+
+type contextKey struct{}
+
+// requested is the maintenance_info of a request as Middleware decoded it.
+type requested struct {
+	info *Info
+	err  error
+}
+
+// FromRequest returns the maintenance_info of a provision or update request
+// served by Middleware, or nil if the request has none. It returns a 400 Bad
+// Request error if the request's maintenance_info is malformed.
+func FromRequest(r *http.Request) (*Info, error) {
+	if r == nil {
+		return nil, nil
+	}
+	v, ok := r.Context().Value(contextKey{}).(requested)
+	if !ok {
+		return nil, nil
+	}
+	return v.info, v.err
+}
+
+// withRequested returns r with its decoded maintenance_info.
+func withRequested(r *http.Request, info *Info, err error) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKey{}, requested{info: info, err: err}))
+}
+
+// Check returns the 422 MaintenanceInfoConflict error the OSB API specifies if
+// a request asked for the maintenance_info requested and the plan it is for
+// has the Info current. A request that asks for none does not conflict.
+func Check(requested, current *Info) error {
+	switch {
+	case requested == nil:
+		return nil
+	case current == nil:
+		return conflict("The plan has no maintenance_info")
+	case requested.Version != current.Version:
+		return conflict(fmt.Sprintf("The plan's maintenance_info version is %q, not %q", current.Version, requested.Version))
+	}
+	return nil
+}
+
+func conflict(description string) error {
+	errorMessage := "MaintenanceInfoConflict"
+	return osb.HTTPStatusCodeError{
+		StatusCode:   http.StatusUnprocessableEntity,
+		ErrorMessage: &errorMessage,
+		Description:  &description,
+	}
+}
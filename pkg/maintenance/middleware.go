@@ -0,0 +1,58 @@
+package maintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Middleware is middleware for the broker's HTTP API that decodes the
+// maintenance_info of PUT and PATCH requests for FromRequest.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut || r.Method == http.MethodPatch {
+			r = decodeRequest(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeRequest returns r with its maintenance_info decoded and its body
+// restored for the handler to read again.
+func decodeRequest(r *http.Request) *http.Request {
+	if r.Body == nil {
+		return r
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		// The handler will fail to read the body too.
+		return r
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// The handler rejects bodies that are not JSON objects.
+		return r
+	}
+	raw, ok := fields["maintenance_info"]
+	if !ok || string(raw) == "null" {
+		return r
+	}
+	var info Info
+	if err := json.Unmarshal(raw, &info); err != nil || info.Version == "" {
+		return withRequested(r, nil, badRequest("maintenance_info must be an object with a version"))
+	}
+	return withRequested(r, &info, nil)
+}
+
+func badRequest(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Broker wraps a broker.Interface and rejects the requests its Policy denies
+// with a 403 Forbidden. The Store is used to find the plan and owner of the
+// instance a request acts on when the request itself does not carry them.
+type Broker struct {
+	broker.Interface
+
+	Policy *Policy
+	Store  state.Store
+}
+
+var _ broker.Interface = &Broker{}
+
+// NewBroker returns a Broker that wraps b and authorizes requests with p.
+func NewBroker(b broker.Interface, p *Policy, store state.Store) *Broker {
+	return &Broker{
+		Interface: b,
+		Policy:    p,
+		Store:     store,
+	}
+}
+
+func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
+	err := b.authorize(&Request{
+		Action:    "provision",
+		ServiceID: request.ServiceID,
+		PlanID:    request.PlanID,
+		Identity:  request.OriginatingIdentity,
+	}, "")
+	if err != nil {
+		return nil, err
+	}
+	return b.Interface.Provision(request, c)
+}
+
+func (b *Broker) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
+	r := &Request{
+		Action:    "update",
+		ServiceID: request.ServiceID,
+		Identity:  request.OriginatingIdentity,
+	}
+	if request.PlanID != nil {
+		r.PlanID = *request.PlanID
+	}
+	if err := b.authorize(r, request.InstanceID); err != nil {
+		return nil, err
+	}
+	return b.Interface.Update(request, c)
+}
+
+func (b *Broker) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
+	err := b.authorize(&Request{
+		Action:    "deprovision",
+		ServiceID: request.ServiceID,
+		PlanID:    request.PlanID,
+		Identity:  request.OriginatingIdentity,
+	}, request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	return b.Interface.Deprovision(request, c)
+}
+
+func (b *Broker) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
+	err := b.authorize(&Request{
+		Action:    "bind",
+		ServiceID: request.ServiceID,
+		PlanID:    request.PlanID,
+		Identity:  request.OriginatingIdentity,
+	}, request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	return b.Interface.Bind(request, c)
+}
+
+func (b *Broker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
+	err := b.authorize(&Request{
+		Action:    "unbind",
+		ServiceID: request.ServiceID,
+		PlanID:    request.PlanID,
+		Identity:  request.OriginatingIdentity,
+	}, request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	return b.Interface.Unbind(request, c)
+}
+
+// authorize evaluates r against the policy, first filling in the service,
+// plan and owner of the instance with the given ID if there is one.
+func (b *Broker) authorize(r *Request, instanceID string) error {
+	if instanceID != "" {
+		err := b.Store.View(func(tx state.Tx) error {
+			instance, err := tx.GetInstance(instanceID)
+			if err != nil {
+				return err
+			}
+			if r.ServiceID == "" {
+				r.ServiceID = instance.ServiceID
+			}
+			if r.PlanID == "" {
+				r.PlanID = instance.PlanID
+			}
+			r.Owner = instance.Owner
+			return nil
+		})
+		if err != nil && err != state.ErrNotFound {
+			return err
+		}
+	}
+
+	allowed, rule := b.Policy.Evaluate(r)
+	if allowed {
+		return nil
+	}
+
+	description := "The request was denied by the broker's authorization policy"
+	if rule != nil && rule.Description != "" {
+		description = rule.Description
+	}
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusForbidden,
+		Description: &description,
+	}
+}
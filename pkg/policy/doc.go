@@ -0,0 +1,23 @@
+// Package policy authorizes OSB requests based on the originating identity of
+// the user on whose behalf the platform makes them. A Policy is an ordered
+// list of rules loaded from a YAML or JSON file, for example:
+//
+//	defaultEffect: allow
+//	rules:
+//	- description: only team-db-admins may provision the premium plan
+//	  effect: allow
+//	  actions: [provision]
+//	  plans: [premium-plan-id]
+//	  groups: [team-db-admins]
+//	- description: only team-db-admins may provision the premium plan
+//	  effect: deny
+//	  actions: [provision]
+//	  plans: [premium-plan-id]
+//	- description: only the user that provisioned an instance may deprovision it
+//	  effect: deny
+//	  actions: [deprovision]
+//	  owner: false
+//
+// The first rule that matches a request decides whether it is allowed; if no
+// rule matches, the policy's default effect applies.
+package policy // import "github.com/pmorie/osb-starter-pack/pkg/policy"
@@ -0,0 +1,190 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/identity"
+)
+
+// Effects a rule can have.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Actions rules can match.
+var actions = map[string]bool{
+	"provision":   true,
+	"update":      true,
+	"deprovision": true,
+	"bind":        true,
+	"unbind":      true,
+}
+
+// Policy is an ordered list of rules.
+type Policy struct {
+	// DefaultEffect applies to requests no rule matches. Defaults to Allow.
+	DefaultEffect string `json:"defaultEffect,omitempty"`
+	Rules         []Rule `json:"rules"`
+}
+
+// Rule allows or denies the requests it matches. Every non-empty field of a
+// rule must match a request for the rule to match it; list fields match if
+// any of their entries do.
+type Rule struct {
+	// Description is returned to the platform when the rule denies a request.
+	Description string `json:"description,omitempty"`
+	// Effect is either Allow or Deny.
+	Effect string `json:"effect"`
+
+	Actions  []string `json:"actions,omitempty"`
+	Services []string `json:"services,omitempty"`
+	Plans    []string `json:"plans,omitempty"`
+
+	// Platforms match the platform of the originating identity, for example
+	// "kubernetes" or "cloudfoundry".
+	Platforms []string `json:"platforms,omitempty"`
+	// Users match the Kubernetes username or Cloud Foundry user_id of the
+	// originating identity.
+	Users []string `json:"users,omitempty"`
+	// Groups match any of the Kubernetes groups of the originating identity.
+	Groups []string `json:"groups,omitempty"`
+	// Owner, if set, matches requests whose originating identity is (true)
+	// or is not (false) the identity that provisioned the instance. Rules
+	// with an Owner only match requests with an originating identity on an
+	// existing instance whose owner is known, so they never turn requests on
+	// unknown instances, which the broker answers with 410 Gone, into 403s.
+	Owner *bool `json:"owner,omitempty"`
+}
+
+// Request holds the attributes of an OSB request that rules match against.
+type Request struct {
+	Action    string
+	ServiceID string
+	PlanID    string
+	// Identity is the originating identity of the request.
+	Identity *osb.OriginatingIdentity
+	// Owner is the originating identity that provisioned the instance the
+	// request acts on, if known.
+	Owner *osb.OriginatingIdentity
+}
+
+// Load reads a Policy from the YAML or JSON file at path and validates it.
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("unable to parse policy file %q: %v", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %q: %v", path, err)
+	}
+	return p, nil
+}
+
+// Validate checks that the policy's effects and actions are known.
+func (p *Policy) Validate() error {
+	if p.DefaultEffect != "" && p.DefaultEffect != Allow && p.DefaultEffect != Deny {
+		return fmt.Errorf("unknown default effect %q", p.DefaultEffect)
+	}
+	for i, r := range p.Rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %d: unknown effect %q", i, r.Effect)
+		}
+		for _, a := range r.Actions {
+			if !actions[a] {
+				return fmt.Errorf("rule %d: unknown action %q", i, a)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate returns whether r is allowed and the rule that decided it, which is
+// nil if the default effect applied.
+func (p *Policy) Evaluate(r *Request) (bool, *Rule) {
+	s := newSubject(r.Identity)
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.matches(r, s) {
+			return rule.Effect == Allow, rule
+		}
+	}
+	return p.DefaultEffect != Deny, nil
+}
+
+func (rule *Rule) matches(r *Request, s *subject) bool {
+	if !matchAny(rule.Actions, r.Action) ||
+		!matchAny(rule.Services, r.ServiceID) ||
+		!matchAny(rule.Plans, r.PlanID) {
+		return false
+	}
+
+	if len(rule.Platforms) > 0 && (s == nil || !matchAny(rule.Platforms, s.platform)) {
+		return false
+	}
+	if len(rule.Users) > 0 && (s == nil || !matchAny(rule.Users, s.user)) {
+		return false
+	}
+	if len(rule.Groups) > 0 && (s == nil || !intersects(rule.Groups, s.groups)) {
+		return false
+	}
+	if rule.Owner != nil {
+		owner := newSubject(r.Owner)
+		if s == nil || owner == nil {
+			return false
+		}
+		isOwner := s.platform == owner.platform && s.user != "" && s.user == owner.user
+		if *rule.Owner != isOwner {
+			return false
+		}
+	}
+	return true
+}
+
+// subject is the part of an originating identity rules match against.
+type subject struct {
+	platform string
+	user     string
+	groups   []string
+}
+
+func newSubject(o *osb.OriginatingIdentity) *subject {
+	if o == nil {
+		return nil
+	}
+
+	s := &subject{}
+	s.platform, s.user, s.groups = identity.Parse(o)
+	return s
+}
+
+// matchAny reports whether value is in list, or list is empty.
+func matchAny(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b []string) bool {
+	for _, v := range b {
+		if matchAny(a, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+// Package quota limits the instances and bindings each tenant may create. A
+// tenant is the Kubernetes namespace, or the Cloud Foundry organization and
+// space, an instance is provisioned into. Quotas are loaded from a YAML or
+// JSON file, for example:
+//
+//	default:
+//	  maxInstances: 10
+//	  maxBindingsPerInstance: 5
+//	namespaces:
+//	  team-a:
+//	    maxInstances: 50
+//	    maxInstancesPerPlan:
+//	      premium-plan-id: 2
+//	organizations:
+//	  6c2d0e7a-9d1f-4b7e-8e8a-2f0d3d8f9c41:
+//	    maxInstances: 100
+//
+// Quotas are enforced by Store, which wraps a state.Store and rejects writes
+// that would exceed them inside the same transaction that makes them.
+package quota // import "github.com/pmorie/osb-starter-pack/pkg/quota"
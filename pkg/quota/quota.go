@@ -0,0 +1,140 @@
+package quota
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Limits are the quotas of a single tenant. A zero value means no limit.
+type Limits struct {
+	// MaxInstances is the number of instances the tenant may have.
+	MaxInstances int `json:"maxInstances,omitempty"`
+	// MaxInstancesPerPlan is the number of instances of each plan, keyed by
+	// plan ID, the tenant may have.
+	MaxInstancesPerPlan map[string]int `json:"maxInstancesPerPlan,omitempty"`
+	// MaxBindingsPerInstance is the number of bindings each of the tenant's
+	// instances may have.
+	MaxBindingsPerInstance int `json:"maxBindingsPerInstance,omitempty"`
+}
+
+// Config holds the quotas of every tenant.
+type Config struct {
+	// Default applies to Kubernetes namespaces and Cloud Foundry spaces that
+	// have no entry of their own.
+	Default *Limits `json:"default,omitempty"`
+	// Namespaces holds the limits of Kubernetes namespaces.
+	Namespaces map[string]*Limits `json:"namespaces,omitempty"`
+	// Organizations holds the limits of Cloud Foundry organizations. They
+	// apply in addition to the limits of the organization's spaces.
+	Organizations map[string]*Limits `json:"organizations,omitempty"`
+	// Spaces holds the limits of Cloud Foundry spaces.
+	Spaces map[string]*Limits `json:"spaces,omitempty"`
+}
+
+// Load reads a Config from the YAML or JSON file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unable to parse quota file %q: %v", path, err)
+	}
+	return c, nil
+}
+
+// scope is a set of instances that share limits, such as the instances in one
+// namespace.
+type scope struct {
+	// kind and name describe the scope in error messages, for example
+	// "namespace" and "team-a".
+	kind   string
+	name   string
+	limits *Limits
+	// contains reports whether an instance belongs to the scope.
+	contains func(i *state.Instance) bool
+}
+
+// scopes returns the scopes whose limits apply to i.
+func (c *Config) scopes(i *state.Instance) []scope {
+	scopes := []scope{}
+
+	if namespace := Namespace(i); namespace != "" {
+		limits := c.Namespaces[namespace]
+		if limits == nil {
+			limits = c.Default
+		}
+		if limits != nil {
+			scopes = append(scopes, scope{
+				kind:     "namespace",
+				name:     namespace,
+				limits:   limits,
+				contains: func(other *state.Instance) bool { return Namespace(other) == namespace },
+			})
+		}
+		return scopes
+	}
+
+	org, space := OrganizationAndSpace(i)
+	if space != "" {
+		limits := c.Spaces[space]
+		if limits == nil {
+			limits = c.Default
+		}
+		if limits != nil {
+			scopes = append(scopes, scope{
+				kind:   "space",
+				name:   space,
+				limits: limits,
+				contains: func(other *state.Instance) bool {
+					_, s := OrganizationAndSpace(other)
+					return s == space
+				},
+			})
+		}
+	}
+	if limits := c.Organizations[org]; org != "" && limits != nil {
+		scopes = append(scopes, scope{
+			kind:   "organization",
+			name:   org,
+			limits: limits,
+			contains: func(other *state.Instance) bool {
+				o, _ := OrganizationAndSpace(other)
+				return o == org
+			},
+		})
+	}
+	return scopes
+}
+
+// Namespace returns the Kubernetes namespace an instance was provisioned
+// into, or "" if it was not provisioned by Kubernetes.
+func Namespace(i *state.Instance) string {
+	if platform, _ := i.Context["platform"].(string); platform != osb.PlatformKubernetes {
+		return ""
+	}
+	namespace, _ := i.Context["namespace"].(string)
+	return namespace
+}
+
+// OrganizationAndSpace returns the Cloud Foundry organization and space GUIDs
+// an instance was provisioned into, from the request's context if it has them
+// and from its organization_guid and space_guid fields otherwise.
+func OrganizationAndSpace(i *state.Instance) (string, string) {
+	org, _ := i.Context["organization_guid"].(string)
+	if org == "" {
+		org = i.OrganizationGUID
+	}
+	space, _ := i.Context["space_guid"].(string)
+	if space == "" {
+		space = i.SpaceGUID
+	}
+	return org, space
+}
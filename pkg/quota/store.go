@@ -0,0 +1,144 @@
+package quota
+
+import (
+	"fmt"
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Store wraps a state.Store and enforces a Config's quotas on the instances
+// and bindings written in its Update transactions. Writes that would exceed a
+// quota fail with a 403 Forbidden osb.HTTPStatusCodeError, which rolls back
+// the transaction. Because the check and the write happen in one
+// transaction, concurrent requests cannot both pass the check.
+type Store struct {
+	state.Store
+
+	Config *Config
+}
+
+var _ state.Store = &Store{}
+
+// NewStore returns a Store that enforces c on the records written to s.
+func NewStore(s state.Store, c *Config) *Store {
+	return &Store{
+		Store:  s,
+		Config: c,
+	}
+}
+
+func (s *Store) Update(fn func(tx state.Tx) error) error {
+	return s.Store.Update(func(tx state.Tx) error {
+		return fn(&quotaTx{Tx: tx, config: s.Config})
+	})
+}
+
+type quotaTx struct {
+	state.Tx
+	config *Config
+}
+
+func (tx *quotaTx) PutInstance(i *state.Instance) error {
+	existing, err := tx.GetInstance(i.ID)
+	if err != nil && err != state.ErrNotFound {
+		return err
+	}
+	newInstance := existing == nil
+	var held []string
+	if existing != nil {
+		held = plans(existing)
+	}
+	var added []string
+	for _, plan := range plans(i) {
+		if !contains(held, plan) {
+			added = append(added, plan)
+		}
+	}
+
+	if newInstance || len(added) > 0 {
+		instances, err := tx.ListInstances()
+		if err != nil {
+			return err
+		}
+
+		for _, sc := range tx.config.scopes(i) {
+			total := 0
+			inPlan := map[string]int{}
+			for _, other := range instances {
+				if other.ID == i.ID || !sc.contains(other) {
+					continue
+				}
+				total++
+				for _, plan := range plans(other) {
+					inPlan[plan]++
+				}
+			}
+
+			if max := sc.limits.MaxInstances; newInstance && max > 0 && total >= max {
+				return exceeded("%s %q may have at most %d instances", sc.kind, sc.name, max)
+			}
+			for _, plan := range added {
+				if max := sc.limits.MaxInstancesPerPlan[plan]; max > 0 && inPlan[plan] >= max {
+					return exceeded("%s %q may have at most %d instances of plan %q", sc.kind, sc.name, max, plan)
+				}
+			}
+		}
+	}
+
+	return tx.Tx.PutInstance(i)
+}
+
+// plans returns the plans the instance i counts towards: its own, and the
+// one an update in progress is moving it to. The instance keeps its place in
+// both until the update finishes, so it can always return to the one it
+// does not end up in.
+func plans(i *state.Instance) []string {
+	plans := []string{i.PlanID}
+	if op := i.LastOperation; op != nil && op.State == osb.StateInProgress && op.PlanID != "" && op.PlanID != i.PlanID {
+		plans = append(plans, op.PlanID)
+	}
+	return plans
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (tx *quotaTx) PutBinding(b *state.Binding) error {
+	if _, err := tx.GetBinding(b.InstanceID, b.ID); err == state.ErrNotFound {
+		instance, err := tx.GetInstance(b.InstanceID)
+		if err != nil {
+			return err
+		}
+		bindings, err := tx.ListBindings(b.InstanceID)
+		if err != nil {
+			return err
+		}
+
+		for _, sc := range tx.config.scopes(instance) {
+			if max := sc.limits.MaxBindingsPerInstance; max > 0 && len(bindings) >= max {
+				return exceeded("instances in %s %q may have at most %d bindings", sc.kind, sc.name, max)
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Tx.PutBinding(b)
+}
+
+func exceeded(format string, args ...interface{}) error {
+	description := "Quota exceeded: " + fmt.Sprintf(format, args...)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusForbidden,
+		Description: &description,
+	}
+}
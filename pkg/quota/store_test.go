@@ -0,0 +1,154 @@
+package quota
+
+import (
+	"net/http"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+func kubernetesInstance(id, namespace, planID string) *state.Instance {
+	return &state.Instance{
+		ID:     id,
+		PlanID: planID,
+		Context: map[string]interface{}{
+			"platform":  osb.PlatformKubernetes,
+			"namespace": namespace,
+		},
+	}
+}
+
+func cloudFoundryInstance(id, org, space, planID string) *state.Instance {
+	return &state.Instance{
+		ID:               id,
+		PlanID:           planID,
+		OrganizationGUID: org,
+		SpaceGUID:        space,
+	}
+}
+
+func putInstance(s state.Store, i *state.Instance) error {
+	return s.Update(func(tx state.Tx) error {
+		return tx.PutInstance(i)
+	})
+}
+
+func putBinding(s state.Store, b *state.Binding) error {
+	return s.Update(func(tx state.Tx) error {
+		return tx.PutBinding(b)
+	})
+}
+
+// wantExceeded fails t unless err is a 403 with the given description.
+func wantExceeded(t *testing.T, err error, description string) {
+	e, ok := err.(osb.HTTPStatusCodeError)
+	if !ok || e.StatusCode != http.StatusForbidden || e.Description == nil {
+		t.Errorf("got %v, want a 403 with a description", err)
+		return
+	}
+	if want := "Quota exceeded: " + description; *e.Description != want {
+		t.Errorf("got description %q, want %q", *e.Description, want)
+	}
+}
+
+func wantOK(t *testing.T, what string, err error) {
+	if err != nil {
+		t.Errorf("%s: %v", what, err)
+	}
+}
+
+func TestMaxInstances(t *testing.T) {
+	s := NewStore(state.NewMemory(), &Config{
+		Namespaces: map[string]*Limits{"team-a": {MaxInstances: 2}},
+	})
+
+	wantOK(t, "first instance", putInstance(s, kubernetesInstance("1", "team-a", "small")))
+	wantOK(t, "second instance", putInstance(s, kubernetesInstance("2", "team-a", "small")))
+	err := putInstance(s, kubernetesInstance("3", "team-a", "small"))
+	wantExceeded(t, err, `namespace "team-a" may have at most 2 instances`)
+
+	// The rejected instance was not written.
+	err = s.View(func(tx state.Tx) error {
+		_, err := tx.GetInstance("3")
+		return err
+	})
+	if err != state.ErrNotFound {
+		t.Errorf("getting the rejected instance returned %v, want ErrNotFound", err)
+	}
+
+	// Writing an instance that exists does not count it again, and other
+	// namespaces have no limit.
+	wantOK(t, "rewriting an instance", putInstance(s, kubernetesInstance("2", "team-a", "small")))
+	wantOK(t, "instance in another namespace", putInstance(s, kubernetesInstance("3", "team-b", "small")))
+}
+
+func TestMaxInstancesPerPlan(t *testing.T) {
+	s := NewStore(state.NewMemory(), &Config{
+		Default:       &Limits{MaxInstancesPerPlan: map[string]int{"large": 1}},
+		Organizations: map[string]*Limits{"org": {MaxInstances: 3}},
+	})
+
+	wantOK(t, "large instance", putInstance(s, cloudFoundryInstance("1", "org", "space-a", "large")))
+	err := putInstance(s, cloudFoundryInstance("2", "org", "space-a", "large"))
+	wantExceeded(t, err, `space "space-a" may have at most 1 instances of plan "large"`)
+	wantOK(t, "small instance", putInstance(s, cloudFoundryInstance("2", "org", "space-a", "small")))
+
+	// The default applies to each space on its own, and the organization's
+	// limit to all of its spaces.
+	wantOK(t, "large instance in another space", putInstance(s, cloudFoundryInstance("3", "org", "space-b", "large")))
+	err = putInstance(s, cloudFoundryInstance("4", "org", "space-c", "small"))
+	wantExceeded(t, err, `organization "org" may have at most 3 instances`)
+}
+
+func TestPlanUpdate(t *testing.T) {
+	s := NewStore(state.NewMemory(), &Config{
+		Namespaces: map[string]*Limits{"team-a": {MaxInstancesPerPlan: map[string]int{"large": 1}}},
+	})
+
+	wantOK(t, "small instance", putInstance(s, kubernetesInstance("1", "team-a", "small")))
+	wantOK(t, "other small instance", putInstance(s, kubernetesInstance("2", "team-a", "small")))
+
+	// An update to the large plan takes its place in the plan while it
+	// runs, as well as keeping its place in the small plan.
+	updating := kubernetesInstance("1", "team-a", "small")
+	updating.LastOperation = &state.Operation{Key: "update", Type: "update", State: osb.StateInProgress, PlanID: "large"}
+	wantOK(t, "starting an update", putInstance(s, updating))
+	if got := plans(updating); len(got) != 2 || got[0] != "small" || got[1] != "large" {
+		t.Errorf("an updating instance counts towards %v, want small and large", got)
+	}
+
+	moving := kubernetesInstance("2", "team-a", "small")
+	moving.LastOperation = &state.Operation{Key: "update", Type: "update", State: osb.StateInProgress, PlanID: "large"}
+	err := putInstance(s, moving)
+	wantExceeded(t, err, `namespace "team-a" may have at most 1 instances of plan "large"`)
+	err = putInstance(s, kubernetesInstance("3", "team-a", "large"))
+	wantExceeded(t, err, `namespace "team-a" may have at most 1 instances of plan "large"`)
+
+	// Once the update finishes, the instance is only in the large plan.
+	updated := kubernetesInstance("1", "team-a", "large")
+	updated.LastOperation = &state.Operation{Key: "update", Type: "update", State: osb.StateSucceeded, PlanID: "large"}
+	wantOK(t, "finishing the update", putInstance(s, updated))
+	if got := plans(updated); len(got) != 1 || got[0] != "large" {
+		t.Errorf("an updated instance counts towards %v, want large", got)
+	}
+}
+
+func TestMaxBindingsPerInstance(t *testing.T) {
+	s := NewStore(state.NewMemory(), &Config{
+		Namespaces: map[string]*Limits{"team-a": {MaxBindingsPerInstance: 2}},
+	})
+	wantOK(t, "instance", putInstance(s, kubernetesInstance("instance", "team-a", "small")))
+
+	wantOK(t, "first binding", putBinding(s, &state.Binding{ID: "1", InstanceID: "instance"}))
+	wantOK(t, "second binding", putBinding(s, &state.Binding{ID: "2", InstanceID: "instance"}))
+	err := putBinding(s, &state.Binding{ID: "3", InstanceID: "instance"})
+	wantExceeded(t, err, `instances in namespace "team-a" may have at most 2 bindings`)
+	wantOK(t, "rewriting a binding", putBinding(s, &state.Binding{ID: "2", InstanceID: "instance"}))
+
+	err = putBinding(s, &state.Binding{ID: "1", InstanceID: "missing"})
+	if err != state.ErrNotFound {
+		t.Errorf("binding an instance that does not exist returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+)
+
+// Actions rate limits can be set for, named like the action label of the
+// osb_actions_total metric.
+var actions = map[string]bool{
+	"get_catalog":    true,
+	"last_operation": true,
+	"provision":      true,
+	"update":         true,
+	"deprovision":    true,
+	"bind":           true,
+	"unbind":         true,
+}
+
+// Limit is the token bucket of a client for an action.
+type Limit struct {
+	// Rate is the number of requests per second the bucket refills with.
+	Rate float64 `json:"rate"`
+	// Burst is the number of requests the bucket holds, which a client can
+	// make at once after being idle.
+	Burst int `json:"burst"`
+}
+
+// Config holds the limits of the broker's API.
+type Config struct {
+	// Default limits actions without a limit of their own. Without it, they
+	// are not limited.
+	Default *Limit `json:"default,omitempty"`
+	// Actions holds the limits of actions by name.
+	Actions map[string]*Limit `json:"actions,omitempty"`
+	// MaxConcurrentMutations is the number of provision, update, deprovision,
+	// bind and unbind requests the broker handles at once, across clients.
+	// Zero means no limit.
+	MaxConcurrentMutations int `json:"maxConcurrentMutations,omitempty"`
+}
+
+// Load reads a Config from the YAML or JSON file at path and validates it.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unable to parse rate limit file %q: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rate limit file %q: %v", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks that the config's actions are known and its limits let
+// some requests through.
+func (c *Config) Validate() error {
+	if c.Default != nil {
+		if err := c.Default.validate(); err != nil {
+			return fmt.Errorf("default: %v", err)
+		}
+	}
+	for action, l := range c.Actions {
+		if !actions[action] {
+			return fmt.Errorf("unknown action %q", action)
+		}
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("action %q: %v", action, err)
+		}
+	}
+	if c.MaxConcurrentMutations < 0 {
+		return fmt.Errorf("maxConcurrentMutations must not be negative")
+	}
+	return nil
+}
+
+func (l *Limit) validate() error {
+	if l == nil || l.Rate <= 0 || l.Burst < 1 {
+		return fmt.Errorf("rate must be positive and burst at least 1")
+	}
+	return nil
+}
+
+// limit returns the limit of action, or nil if it has none.
+func (c *Config) limit(action string) *Limit {
+	if l, ok := c.Actions[action]; ok {
+		return l
+	}
+	return c.Default
+}
@@ -0,0 +1,26 @@
+// Package ratelimit protects the broker from clients that send it more
+// requests than it can handle, such as a platform controller polling the last
+// operation of many instances at once. Each client gets a token bucket for
+// each action, and the number of provision, update, deprovision, bind and
+// unbind requests in flight at once is capped. Requests over either limit get
+// a 429 Too Many Requests with a Retry-After header. Limits are loaded from a
+// YAML or JSON file, for example:
+//
+//	default:
+//	  rate: 10
+//	  burst: 20
+//	actions:
+//	  last_operation:
+//	    rate: 2
+//	    burst: 10
+//	  provision:
+//	    rate: 0.5
+//	    burst: 5
+//	maxConcurrentMutations: 20
+//
+// Clients are told apart by the identity the broker's authentication
+// middleware authenticated them as, the user of HTTP basic authentication or
+// the bearer token, or else by their IP address. Credentials the broker does
+// not check are ignored. The Limiter keeps the buckets of at most 10000
+// clients, forgetting the least recently seen ones first.
+package ratelimit // import "github.com/pmorie/osb-starter-pack/pkg/ratelimit"
@@ -0,0 +1,251 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	"github.com/pmorie/osb-starter-pack/pkg/brokers"
+)
+
+// Reasons requests are throttled for, as the reason label of the
+// osb_throttled_requests_total metric.
+const (
+	reasonRate        = "rate"
+	reasonConcurrency = "concurrency"
+)
+
+// sweepInterval is how often a Limiter forgets the buckets of clients that
+// have been idle long enough for them to be full.
+const sweepInterval = time.Minute
+
+// maxBuckets is the most buckets a Limiter keeps. Once it has that many, the
+// least recently used bucket makes way for that of a new client.
+const maxBuckets = 10000
+
+// Limiter enforces the limits of a Config on the requests its Middleware
+// serves. It is a prometheus.Collector of the requests it throttled.
+type Limiter struct {
+	config *Config
+	// mutations holds a token for each mutating request in flight, or is nil
+	// if they are not limited.
+	mutations chan struct{}
+	throttled *prom.CounterVec
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+var _ prom.Collector = &Limiter{}
+
+type bucketKey struct {
+	client string
+	action string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	// idle is how long the bucket takes to fill up.
+	idle time.Duration
+}
+
+// New returns a Limiter that enforces c.
+func New(c *Config) *Limiter {
+	l := &Limiter{
+		config: c,
+		throttled: prom.NewCounterVec(prom.CounterOpts{
+			Name: "osb_throttled_requests_total",
+			Help: "Total amount of requests rejected for exceeding a rate or concurrency limit.",
+		}, []string{"action", "reason"}),
+		buckets:   map[bucketKey]*bucket{},
+		lastSweep: time.Now(),
+	}
+	if c.MaxConcurrentMutations > 0 {
+		l.mutations = make(chan struct{}, c.MaxConcurrentMutations)
+	}
+	return l
+}
+
+// Describe implements prometheus.Collector.
+func (l *Limiter) Describe(ch chan<- *prom.Desc) {
+	l.throttled.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *Limiter) Collect(ch chan<- prom.Metric) {
+	l.throttled.Collect(ch)
+}
+
+// Middleware is middleware for the broker's HTTP API that rejects requests
+// over the Limiter's limits with a 429 Too Many Requests. It must see paths
+// without the prefix the broker is served under.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := actionOf(r)
+		if action == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if limit := l.config.limit(action); limit != nil {
+			if delay := l.reserve(bucketKey{client: clientOf(r), action: action}, limit); delay > 0 {
+				l.throttled.WithLabelValues(action, reasonRate).Inc()
+				tooManyRequests(w, delay, "Too many requests; slow down")
+				return
+			}
+		}
+		if l.mutations != nil && mutating(action) {
+			select {
+			case l.mutations <- struct{}{}:
+				defer func() { <-l.mutations }()
+			default:
+				l.throttled.WithLabelValues(action, reasonConcurrency).Inc()
+				tooManyRequests(w, time.Second, "Too many requests are in progress")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reserve takes a token from the bucket with the given key, which has the
+// given limit. If the bucket is empty, it returns how long it will take to
+// refill by one token.
+func (l *Limiter) reserve(key bucketKey, limit *Limit) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.evict()
+		}
+		b = &bucket{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst),
+			idle:    time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second)),
+		}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// sweep forgets the buckets that are full again. It must be called with l.mu
+// held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > b.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// evict forgets the least recently used bucket. It must be called with l.mu
+// held.
+func (l *Limiter) evict() {
+	var oldest bucketKey
+	var oldestSeen time.Time
+	for key, b := range l.buckets {
+		if oldestSeen.IsZero() || b.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = key, b.lastSeen
+		}
+	}
+	delete(l.buckets, oldest)
+}
+
+// actionOf returns the action of an OSB API request, or an empty string if r
+// is not one.
+func actionOf(r *http.Request) string {
+	if r.URL.Path == "/v2/catalog" {
+		if r.Method == http.MethodGet {
+			return "get_catalog"
+		}
+		return ""
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v2/service_instances/") {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/service_instances/"), "/")
+	last := parts[len(parts)-1]
+	switch {
+	case last == "last_operation" && r.Method == http.MethodGet:
+		return "last_operation"
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodPut:
+			return "provision"
+		case http.MethodPatch:
+			return "update"
+		case http.MethodDelete:
+			return "deprovision"
+		}
+	case len(parts) == 3 && parts[1] == "service_bindings":
+		switch r.Method {
+		case http.MethodPut:
+			return "bind"
+		case http.MethodDelete:
+			return "unbind"
+		}
+	}
+	return ""
+}
+
+// mutating reports whether action changes instances or bindings.
+func mutating(action string) bool {
+	switch action {
+	case "provision", "update", "deprovision", "bind", "unbind":
+		return true
+	}
+	return false
+}
+
+// clientOf returns the key that tells apart the client that made r from
+// others: the client the broker's authentication middleware authenticated it
+// as, or else its IP address. Credentials nobody checked are ignored, since
+// a client could make up new ones for every request.
+func clientOf(r *http.Request) string {
+	if client := brokers.Client(r); client != "" {
+		return client
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// tooManyRequests writes a 429 Too Many Requests response asking the client
+// to retry after delay.
+func tooManyRequests(w http.ResponseWriter, delay time.Duration, description string) {
+	seconds := int(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	body, _ := json.Marshal(map[string]string{"description": description})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(body)
+}
@@ -0,0 +1,185 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/pmorie/osb-starter-pack/pkg/brokers"
+)
+
+// newHandler returns l's middleware in front of a handler that answers every
+// request with 200 OK.
+func newHandler(l *Limiter) http.Handler {
+	return l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// serve makes a request with the given method and path from the given IP
+// address and returns the response.
+func serve(h http.Handler, method, path, ip string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = ip + ":12345"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	h := newHandler(New(&Config{Default: &Limit{Rate: 0.1, Burst: 2}}))
+
+	for n := 0; n < 2; n++ {
+		if w := serve(h, http.MethodGet, "/v2/catalog", "10.0.0.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: got status %d, want 200", n, w.Code)
+		}
+	}
+	w := serve(h, http.MethodGet, "/v2/catalog", "10.0.0.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst: got status %d, want 429", w.Code)
+	}
+	// The bucket refills one token every 10 seconds.
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 9 || retryAfter > 10 {
+		t.Errorf("got Retry-After %q, want about 10 seconds", w.Header().Get("Retry-After"))
+	}
+
+	// Requests that are not OSB actions are not limited.
+	if w := serve(h, http.MethodGet, "/healthz", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("request for another path: got status %d, want 200", w.Code)
+	}
+}
+
+func TestBucketIsolation(t *testing.T) {
+	h := newHandler(New(&Config{
+		Actions: map[string]*Limit{
+			"provision":      {Rate: 0.1, Burst: 1},
+			"last_operation": {Rate: 0.1, Burst: 1},
+		},
+	}))
+
+	if w := serve(h, http.MethodPut, "/v2/service_instances/a", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("first provision: got status %d, want 200", w.Code)
+	}
+	if w := serve(h, http.MethodPut, "/v2/service_instances/b", "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second provision: got status %d, want 429", w.Code)
+	}
+	// The client has a bucket of its own for each action.
+	if w := serve(h, http.MethodGet, "/v2/service_instances/a/last_operation", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("last operation of the same client: got status %d, want 200", w.Code)
+	}
+	// Actions without a limit are not limited.
+	for n := 0; n < 3; n++ {
+		if w := serve(h, http.MethodDelete, "/v2/service_instances/a", "10.0.0.1"); w.Code != http.StatusOK {
+			t.Errorf("deprovision %d: got status %d, want 200", n, w.Code)
+		}
+	}
+	// Other clients have buckets of their own.
+	if w := serve(h, http.MethodPut, "/v2/service_instances/c", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Errorf("provision by another client: got status %d, want 200", w.Code)
+	}
+}
+
+func TestAuthenticatedClient(t *testing.T) {
+	l := New(&Config{Default: &Limit{Rate: 0.1, Burst: 1}})
+	h := brokers.BasicAuth("user", "password")(newHandler(l))
+
+	request := func(ip string) int {
+		r := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+		r.RemoteAddr = ip + ":12345"
+		r.SetBasicAuth("user", "password")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := request("10.0.0.1"); code != http.StatusOK {
+		t.Fatalf("first request: got status %d, want 200", code)
+	}
+	// An authenticated client has the same bucket wherever it connects from.
+	if code := request("10.0.0.2"); code != http.StatusTooManyRequests {
+		t.Errorf("request from another address: got status %d, want 429", code)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	l := New(&Config{Default: &Limit{Rate: 0.1, Burst: 1}})
+	h := newHandler(l)
+
+	if w := serve(h, http.MethodGet, "/v2/catalog", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("first request: got status %d, want 200", w.Code)
+	}
+	// Fill the Limiter with the buckets of clients seen before 10.0.0.1, the
+	// first of them longest ago.
+	seen := time.Now().Add(-time.Second)
+	l.mu.Lock()
+	for n := 0; len(l.buckets) < maxBuckets; n++ {
+		l.buckets[bucketKey{client: fmt.Sprintf("ip:filler-%d", n), action: "get_catalog"}] = &bucket{
+			limiter:  rate.NewLimiter(0.1, 1),
+			lastSeen: seen.Add(time.Duration(n)),
+			idle:     10 * time.Second,
+		}
+	}
+	l.mu.Unlock()
+
+	// A new client makes way for the least recently seen one.
+	if w := serve(h, http.MethodGet, "/v2/catalog", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Fatalf("request by a new client: got status %d, want 200", w.Code)
+	}
+	l.mu.Lock()
+	_, oldest := l.buckets[bucketKey{client: "ip:filler-0", action: "get_catalog"}]
+	_, next := l.buckets[bucketKey{client: "ip:filler-1", action: "get_catalog"}]
+	buckets := len(l.buckets)
+	l.mu.Unlock()
+	if buckets != maxBuckets {
+		t.Errorf("got %d buckets, want %d", buckets, maxBuckets)
+	}
+	if oldest || !next {
+		t.Error("the least recently seen bucket was not the one evicted")
+	}
+
+	// Recently seen clients keep their buckets.
+	if w := serve(h, http.MethodGet, "/v2/catalog", "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("request by a recently seen client: got status %d, want 429", w.Code)
+	}
+}
+
+func TestConcurrentMutations(t *testing.T) {
+	l := New(&Config{MaxConcurrentMutations: 1})
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			close(started)
+			<-finish
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan int)
+	go func() {
+		done <- serve(h, http.MethodPut, "/v2/service_instances/a", "10.0.0.1").Code
+	}()
+	<-started
+
+	w := serve(h, http.MethodDelete, "/v2/service_instances/b", "10.0.0.2")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("mutation while another is in progress: got status %d and Retry-After %q, want 429 and 1", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Requests that do not mutate are not capped.
+	if w := serve(h, http.MethodGet, "/v2/service_instances/a/last_operation", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Errorf("last operation while a mutation is in progress: got status %d, want 200", w.Code)
+	}
+
+	close(finish)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("mutation in progress: got status %d, want 200", code)
+	}
+	if w := serve(h, http.MethodDelete, "/v2/service_instances/b", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Errorf("mutation after the other finished: got status %d, want 200", w.Code)
+	}
+}
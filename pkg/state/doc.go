@@ -0,0 +1,5 @@
+// Package state holds the broker's record of the service instances and
+// bindings it has created. Records are read and written inside transactions
+// on a Store, so checks such as "does this instance already exist" and the
+// writes that depend on them happen atomically.
+package state // import "github.com/pmorie/osb-starter-pack/pkg/state"
@@ -0,0 +1,326 @@
+package state
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Export is a Snapshot of every record of a Store, for backing up a broker's
+// state or moving it to another broker. Operations are exported with the
+// instances they act on. An Export is also a valid state file.
+type Export struct {
+	Snapshot
+	// Exported is when the records were exported.
+	Exported time.Time `json:"exported"`
+	// Checksum is the hex-encoded SHA-256 of the instances and bindings,
+	// which Check verifies to detect an export that was damaged or edited.
+	Checksum string `json:"checksum"`
+}
+
+// Dump returns an Export of every record of s.
+func Dump(s Store) (*Export, error) {
+	e := &Export{
+		Snapshot: Snapshot{Version: SnapshotVersion},
+		Exported: time.Now().UTC(),
+	}
+	err := s.View(func(tx Tx) error {
+		var err error
+		e.Instances, err = tx.ListInstances()
+		if err != nil {
+			return err
+		}
+		e.Bindings = []*Binding{}
+		for _, i := range e.Instances {
+			bindings, err := tx.ListBindings(i.ID)
+			if err != nil {
+				return err
+			}
+			e.Bindings = append(e.Bindings, bindings...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.Checksum, err = e.checksum()
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// checksum returns the checksum of the records of e.
+func (e *Export) checksum() (string, error) {
+	data, err := json.Marshal(struct {
+		Instances []*Instance `json:"instances"`
+		Bindings  []*Binding  `json:"bindings"`
+	}{e.Instances, e.Bindings})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Check returns an error if e has an unsupported version, does not match its
+// checksum, or has records that could not have been stored together: records
+// without IDs, records with the same ID, instances in unknown states,
+// operations without a key, type or state, or bindings of instances it does
+// not have.
+func (e *Export) Check() error {
+	if e.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported export version %d", e.Version)
+	}
+	sum, err := e.checksum()
+	if err != nil {
+		return err
+	}
+	if e.Checksum != sum {
+		return fmt.Errorf("checksum mismatch: the export was modified or damaged after it was written")
+	}
+
+	instances := map[string]bool{}
+	for n, i := range e.Instances {
+		switch {
+		case i == nil:
+			return fmt.Errorf("instance %d is empty", n+1)
+		case i.ID == "" || i.ServiceID == "" || i.PlanID == "":
+			return fmt.Errorf("instance %d must have an id, a service_id and a plan_id", n+1)
+		case instances[i.ID]:
+			return fmt.Errorf("instance %q appears more than once", i.ID)
+		}
+		instances[i.ID] = true
+		switch i.State {
+		case "", InstanceCreating, InstanceReady, InstanceFailed, InstanceDeleting:
+		default:
+			return fmt.Errorf("instance %q has unknown state %q", i.ID, i.State)
+		}
+		for _, op := range append(i.History[:len(i.History):len(i.History)], i.LastOperation) {
+			if op == nil {
+				continue
+			}
+			if err := checkOperation(op); err != nil {
+				return fmt.Errorf("instance %q: %v", i.ID, err)
+			}
+		}
+	}
+
+	bindings := map[[2]string]bool{}
+	for n, b := range e.Bindings {
+		switch {
+		case b == nil:
+			return fmt.Errorf("binding %d is empty", n+1)
+		case b.ID == "" || b.InstanceID == "":
+			return fmt.Errorf("binding %d must have an id and an instance_id", n+1)
+		case !instances[b.InstanceID]:
+			return fmt.Errorf("binding %q is of instance %q, which the export does not have", b.ID, b.InstanceID)
+		case bindings[[2]string{b.InstanceID, b.ID}]:
+			return fmt.Errorf("binding %q of instance %q appears more than once", b.ID, b.InstanceID)
+		}
+		bindings[[2]string{b.InstanceID, b.ID}] = true
+	}
+	return nil
+}
+
+// checkOperation returns an error if op is not a valid operation record.
+func checkOperation(op *Operation) error {
+	if op.Key == "" || op.Type == "" {
+		return fmt.Errorf("operation must have a key and a type")
+	}
+	switch op.State {
+	case osb.StateInProgress, osb.StateSucceeded, osb.StateFailed:
+		return nil
+	}
+	return fmt.Errorf("operation %q has unknown state %q", op.Key, op.State)
+}
+
+// Change is a difference between the records of a Store and an Export.
+type Change struct {
+	// Action is "create", "update" or "delete".
+	Action string `json:"action"`
+	// Kind is "instance" or "binding".
+	Kind       string `json:"kind"`
+	InstanceID string `json:"instance_id"`
+	BindingID  string `json:"binding_id,omitempty"`
+	// Fields are the JSON names of the fields an update changes.
+	Fields []string `json:"fields,omitempty"`
+}
+
+func (c *Change) String() string {
+	id := c.InstanceID
+	if c.BindingID != "" {
+		id += "/" + c.BindingID
+	}
+	s := fmt.Sprintf("%s %s %s", c.Action, c.Kind, id)
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	return s
+}
+
+// Import writes the records of e, which must pass Check, to s in one
+// transaction, replacing the records with the same IDs. If replace is set,
+// the records of s that e does not have are deleted, so s ends up with
+// exactly the records of e. If dryRun is set, nothing is written. Import
+// returns the changes it made, or would make.
+func Import(s Store, e *Export, replace, dryRun bool) ([]*Change, error) {
+	if err := e.Check(); err != nil {
+		return nil, err
+	}
+
+	var changes []*Change
+	if dryRun {
+		err := s.View(func(tx Tx) error {
+			var err error
+			changes, err = diff(tx, e, replace)
+			return err
+		})
+		return changes, err
+	}
+
+	err := s.Update(func(tx Tx) error {
+		var err error
+		changes, err = diff(tx, e, replace)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			if c.Action == "delete" && c.Kind == "instance" {
+				if err := tx.DeleteInstance(c.InstanceID); err != nil {
+					return err
+				}
+			}
+			if c.Action == "delete" && c.Kind == "binding" {
+				if err := tx.DeleteBinding(c.InstanceID, c.BindingID); err != nil && err != ErrNotFound {
+					return err
+				}
+			}
+		}
+		for _, i := range e.Instances {
+			if err := tx.PutInstance(i); err != nil {
+				return err
+			}
+		}
+		for _, b := range e.Bindings {
+			if err := tx.PutBinding(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diff returns the changes that importing e in tx makes.
+func diff(tx Tx, e *Export, replace bool) ([]*Change, error) {
+	var changes []*Change
+	imported := map[string]bool{}
+	importedBindings := map[[2]string]bool{}
+	for _, i := range e.Instances {
+		imported[i.ID] = true
+		existing, err := tx.GetInstance(i.ID)
+		if err == ErrNotFound {
+			changes = append(changes, &Change{Action: "create", Kind: "instance", InstanceID: i.ID})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		fields, err := changedFields(existing, i)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			changes = append(changes, &Change{Action: "update", Kind: "instance", InstanceID: i.ID, Fields: fields})
+		}
+	}
+	for _, b := range e.Bindings {
+		importedBindings[[2]string{b.InstanceID, b.ID}] = true
+		existing, err := tx.GetBinding(b.InstanceID, b.ID)
+		if err == ErrNotFound {
+			changes = append(changes, &Change{Action: "create", Kind: "binding", InstanceID: b.InstanceID, BindingID: b.ID})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		fields, err := changedFields(existing, b)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			changes = append(changes, &Change{Action: "update", Kind: "binding", InstanceID: b.InstanceID, BindingID: b.ID, Fields: fields})
+		}
+	}
+	if !replace {
+		return changes, nil
+	}
+
+	instances, err := tx.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range instances {
+		if !imported[i.ID] {
+			changes = append(changes, &Change{Action: "delete", Kind: "instance", InstanceID: i.ID})
+		}
+		bindings, err := tx.ListBindings(i.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bindings {
+			if !importedBindings[[2]string{b.InstanceID, b.ID}] {
+				changes = append(changes, &Change{Action: "delete", Kind: "binding", InstanceID: b.InstanceID, BindingID: b.ID})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// changedFields returns the JSON names of the fields that differ between two
+// records, sorted.
+func changedFields(a, b interface{}) ([]string, error) {
+	fieldsA, err := jsonFields(a)
+	if err != nil {
+		return nil, err
+	}
+	fieldsB, err := jsonFields(b)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for name, value := range fieldsA {
+		if other, ok := fieldsB[name]; !ok || !bytes.Equal(value, other) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range fieldsB {
+		if _, ok := fieldsA[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func jsonFields(v interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
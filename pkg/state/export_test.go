@@ -0,0 +1,128 @@
+package state
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	source := NewMemory()
+	instance, binding := testRecords()
+	put(t, source, instance, binding)
+
+	e, err := Dump(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Round-trip the export through JSON, as the export and import
+	// commands do.
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Export{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	target := NewMemory()
+	changes, err := Import(target, decoded, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].String() != "create instance instance" || changes[1].String() != "create binding instance/binding" {
+		t.Errorf("got changes %v, want the instance and binding created", changes)
+	}
+	i, b := get(t, target)
+	if !reflect.DeepEqual(i, instance) {
+		t.Errorf("imported instance %+v, want %+v", i, instance)
+	}
+	if !reflect.DeepEqual(b, binding) {
+		t.Errorf("imported binding %+v, want %+v", b, binding)
+	}
+
+	again, err := Dump(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Checksum != e.Checksum {
+		t.Error("exporting the imported records gave a different checksum")
+	}
+
+	// Importing the same export again changes nothing.
+	if changes, err := Import(target, decoded, false, false); err != nil || len(changes) != 0 {
+		t.Errorf("importing again returned %v, %v; want no changes", changes, err)
+	}
+}
+
+func TestImportReplace(t *testing.T) {
+	source := NewMemory()
+	instance, binding := testRecords()
+	put(t, source, instance, binding)
+	e, err := Dump(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := NewMemory()
+	other := &Instance{ID: "other", ServiceID: "service", PlanID: "plan"}
+	put(t, target, other, &Binding{ID: "binding", InstanceID: "other"})
+
+	// A dry run only reports the changes.
+	changes, err := Import(target, e, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 4 {
+		t.Errorf("dry run returned changes %v, want 4", changes)
+	}
+	if err := target.View(func(tx Tx) error { _, err := tx.GetInstance("instance"); return err }); err != ErrNotFound {
+		t.Errorf("a dry run imported the instance: %v", err)
+	}
+
+	if _, err := Import(target, e, true, false); err != nil {
+		t.Fatal(err)
+	}
+	var instances []*Instance
+	target.View(func(tx Tx) error {
+		instances, err = tx.ListInstances()
+		return err
+	})
+	if len(instances) != 1 || instances[0].ID != "instance" {
+		t.Errorf("after replacing, got instances %v, want only the imported one", instances)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	source := NewMemory()
+	instance, binding := testRecords()
+	put(t, source, instance, binding)
+
+	for name, modify := range map[string]func(e *Export){
+		"unsupported version": func(e *Export) { e.Version = SnapshotVersion + 1 },
+		"changed record":      func(e *Export) { e.Instances[0].PlanID = "other" },
+		"orphaned binding": func(e *Export) {
+			e.Bindings[0].InstanceID = "missing"
+			e.Checksum, _ = e.checksum()
+		},
+	} {
+		e, err := Dump(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modify(e)
+		target := NewMemory()
+		if _, err := Import(target, e, false, false); err == nil {
+			t.Errorf("%s: import succeeded", name)
+		}
+		var instances []*Instance
+		target.View(func(tx Tx) error {
+			instances, err = tx.ListInstances()
+			return err
+		})
+		if len(instances) != 0 {
+			t.Errorf("%s: a rejected import wrote %d instances", name, len(instances))
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SnapshotVersion is the version of the Snapshot format written by this
+// package.
+const SnapshotVersion = 1
+
+// Snapshot holds every record of a Store. It is the format of the file
+// written by a file-backed Store.
+type Snapshot struct {
+	Version   int         `json:"version"`
+	Instances []*Instance `json:"instances"`
+	Bindings  []*Binding  `json:"bindings"`
+}
+
+// NewFile returns a Store that keeps its records in memory and writes them to
+// the file at path before committing every Update transaction, so they
+// survive a restart. Records already in the file are loaded.
+//
+// The store holds an exclusive lock on path + ".lock" for as long as the
+// process runs, so that a second store on the same file, such as 'state
+// import' against a running broker, fails with ErrLocked instead of having
+// its records overwritten.
+func NewFile(path string) (*Memory, error) {
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	m, err := LoadFile(path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	m.lock = lock
+	m.persist = func(d *data) error {
+		return writeFile(path, d.snapshot())
+	}
+	return m, nil
+}
+
+// ErrLocked is returned by NewFile when another store holds the lock on the
+// state file.
+var ErrLocked = errors.New("the state file is in use by another process, such as a running broker")
+
+// LoadFile returns a Memory store with the records in the file at path, if it
+// exists. Unlike NewFile, it neither locks the file nor writes to it, so it
+// can read the state of a running broker.
+func LoadFile(path string) (*Memory, error) {
+	m := NewMemory()
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(contents, snapshot); err != nil {
+		return nil, fmt.Errorf("unable to parse state file %q: %v", path, err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		return nil, fmt.Errorf("state file %q has unsupported version %d", path, snapshot.Version)
+	}
+	for _, i := range snapshot.Instances {
+		m.data.instances[i.ID] = *i
+	}
+	for _, b := range snapshot.Bindings {
+		if m.data.bindings[b.InstanceID] == nil {
+			m.data.bindings[b.InstanceID] = map[string]Binding{}
+		}
+		m.data.bindings[b.InstanceID][b.ID] = *b
+	}
+	return m, nil
+}
+
+func (d *data) snapshot() *Snapshot {
+	tx := &memoryTx{data: d}
+	snapshot := &Snapshot{Version: SnapshotVersion}
+	snapshot.Instances, _ = tx.ListInstances()
+	snapshot.Bindings = []*Binding{}
+	for _, i := range snapshot.Instances {
+		bindings, _ := tx.ListBindings(i.ID)
+		snapshot.Bindings = append(snapshot.Bindings, bindings...)
+	}
+	return snapshot
+}
+
+// writeFile replaces the file at path with the snapshot. The snapshot is
+// written to a temporary file that is renamed over path, so a crash never
+// leaves a partially written file behind.
+func writeFile(path string, snapshot *Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
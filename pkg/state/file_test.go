@@ -0,0 +1,178 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// testRecords returns an instance with an operation and a binding of it.
+func testRecords() (*Instance, *Binding) {
+	now := time.Now().UTC().Truncate(time.Second)
+	instance := &Instance{
+		ID:         "instance",
+		ServiceID:  "service",
+		PlanID:     "plan",
+		Parameters: map[string]interface{}{"size": "large"},
+		State:      InstanceCreating,
+		Owner:      &osb.OriginatingIdentity{Platform: "kubernetes", Value: `{"username":"alice"}`},
+		LastOperation: &Operation{
+			Key:     "provision-1",
+			Type:    "provision",
+			State:   osb.StateInProgress,
+			Started: now,
+		},
+		Created: now,
+		Updated: now,
+	}
+	binding := &Binding{
+		ID:          "binding",
+		InstanceID:  "instance",
+		Credentials: map[string]interface{}{"password": "hunter2"},
+		Created:     now,
+	}
+	return instance, binding
+}
+
+func put(t *testing.T, s Store, i *Instance, b *Binding) {
+	err := s.Update(func(tx Tx) error {
+		if err := tx.PutInstance(i); err != nil {
+			return err
+		}
+		return tx.PutBinding(b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// get returns the instance and binding put stores.
+func get(t *testing.T, s Store) (*Instance, *Binding) {
+	var i *Instance
+	var b *Binding
+	err := s.View(func(tx Tx) error {
+		var err error
+		if i, err = tx.GetInstance("instance"); err != nil {
+			return err
+		}
+		b, err = tx.GetBinding("instance", "binding")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i, b
+}
+
+func TestFileReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, binding := testRecords()
+	put(t, s, instance, binding)
+
+	// The lock keeps a second store off the file while the first is open,
+	// but LoadFile can read it.
+	if _, err := NewFile(path); err != ErrLocked {
+		t.Errorf("opening a locked state file returned %v, want ErrLocked", err)
+	}
+	loaded, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := get(t, loaded); !reflect.DeepEqual(i, instance) {
+		t.Errorf("LoadFile read instance %+v, want %+v", i, instance)
+	}
+
+	s.lock.Close()
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.lock.Close()
+	i, b := get(t, reopened)
+	if !reflect.DeepEqual(i, instance) {
+		t.Errorf("reopened instance %+v, want %+v", i, instance)
+	}
+	if !reflect.DeepEqual(b, binding) {
+		t.Errorf("reopened binding %+v, want %+v", b, binding)
+	}
+
+	// Deletes are persisted too.
+	err = reopened.Update(func(tx Tx) error {
+		return tx.DeleteBinding("instance", "binding")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loaded.View(func(tx Tx) error {
+		_, err := tx.GetBinding("instance", "binding")
+		return err
+	})
+	if err != ErrNotFound {
+		t.Errorf("getting a deleted binding after reloading returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileFailedUpdate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.lock.Close()
+	instance, binding := testRecords()
+	put(t, s, instance, binding)
+
+	// A transaction that cannot be persisted, here because the directory of
+	// the file is gone, is not committed.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Update(func(tx Tx) error {
+		return tx.DeleteInstance("instance")
+	})
+	if err == nil {
+		t.Fatal("Update succeeded although it could not be persisted")
+	}
+	if i, _ := get(t, s); i.ID != "instance" {
+		t.Errorf("got instance %+v after a failed update", i)
+	}
+}
+
+func TestFileVersion(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(path, []byte(`{"version":2,"instances":[],"bindings":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFile(path); err == nil {
+		t.Error("opening a state file of an unknown version succeeded")
+	}
+}
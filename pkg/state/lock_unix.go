@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package state
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile opens, or creates, the file at path and takes an exclusive lock
+// on it, which is released when the file is closed or the process exits. It
+// returns ErrLocked if another open file holds the lock.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,11 @@
+package state
+
+import (
+	"os"
+)
+
+// lockFile opens, or creates, the file at path. State files are not locked
+// on Windows.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
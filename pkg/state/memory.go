@@ -0,0 +1,255 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// errReadOnly is returned by writes in a View transaction.
+var errReadOnly = errors.New("write in read-only transaction")
+
+// Memory is a Store that keeps records in memory. Unless it was created with
+// NewFile, its contents are lost when the process exits.
+type Memory struct {
+	mu   sync.RWMutex
+	data *data
+	// persist, if set, is called with the records of every Update
+	// transaction before they are committed.
+	persist func(d *data) error
+	// lock, if set, is the lock NewFile holds on the state file. It is
+	// referenced so that it is not closed, and released, while the store
+	// is in use.
+	lock *os.File
+}
+
+var _ Store = &Memory{}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{data: newData()}
+}
+
+func (m *Memory) View(fn func(tx Tx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return fn(&memoryTx{data: m.data})
+}
+
+func (m *Memory) Update(fn func(tx Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	working := m.data.clone()
+	if err := fn(&memoryTx{data: working, writable: true}); err != nil {
+		return err
+	}
+	if m.persist != nil {
+		if err := m.persist(working); err != nil {
+			return err
+		}
+	}
+	m.data = working
+	return nil
+}
+
+// data is a snapshot of a Memory store's records. Records are deep-copied on
+// the way in and out, so callers cannot modify them, or the maps and
+// operations they refer to, without going through a transaction. Snapshots
+// can therefore share records.
+type data struct {
+	instances map[string]Instance
+	// bindings is keyed by instance ID and then binding ID.
+	bindings map[string]map[string]Binding
+}
+
+func newData() *data {
+	return &data{
+		instances: map[string]Instance{},
+		bindings:  map[string]map[string]Binding{},
+	}
+}
+
+func (d *data) clone() *data {
+	c := newData()
+	for id, i := range d.instances {
+		c.instances[id] = i
+	}
+	for instanceID, bindings := range d.bindings {
+		c.bindings[instanceID] = make(map[string]Binding, len(bindings))
+		for id, b := range bindings {
+			c.bindings[instanceID][id] = b
+		}
+	}
+	return c
+}
+
+type memoryTx struct {
+	data     *data
+	writable bool
+}
+
+func (tx *memoryTx) GetInstance(id string) (*Instance, error) {
+	i, ok := tx.data.instances[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	i = copyInstance(i)
+	return &i, nil
+}
+
+func (tx *memoryTx) ListInstances() ([]*Instance, error) {
+	instances := make([]*Instance, 0, len(tx.data.instances))
+	for _, i := range tx.data.instances {
+		i := copyInstance(i)
+		instances = append(instances, &i)
+	}
+	sort.Slice(instances, func(a, b int) bool { return instances[a].ID < instances[b].ID })
+	return instances, nil
+}
+
+func (tx *memoryTx) PutInstance(i *Instance) error {
+	if !tx.writable {
+		return errReadOnly
+	}
+	tx.data.instances[i.ID] = copyInstance(*i)
+	return nil
+}
+
+func (tx *memoryTx) DeleteInstance(id string) error {
+	if !tx.writable {
+		return errReadOnly
+	}
+	delete(tx.data.instances, id)
+	delete(tx.data.bindings, id)
+	return nil
+}
+
+func (tx *memoryTx) GetBinding(instanceID, bindingID string) (*Binding, error) {
+	b, ok := tx.data.bindings[instanceID][bindingID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	b = copyBinding(b)
+	return &b, nil
+}
+
+func (tx *memoryTx) ListBindings(instanceID string) ([]*Binding, error) {
+	bindings := make([]*Binding, 0, len(tx.data.bindings[instanceID]))
+	for _, b := range tx.data.bindings[instanceID] {
+		b := copyBinding(b)
+		bindings = append(bindings, &b)
+	}
+	sort.Slice(bindings, func(a, b int) bool { return bindings[a].ID < bindings[b].ID })
+	return bindings, nil
+}
+
+func (tx *memoryTx) PutBinding(b *Binding) error {
+	if !tx.writable {
+		return errReadOnly
+	}
+	if tx.data.bindings[b.InstanceID] == nil {
+		tx.data.bindings[b.InstanceID] = map[string]Binding{}
+	}
+	tx.data.bindings[b.InstanceID][b.ID] = copyBinding(*b)
+	return nil
+}
+
+func (tx *memoryTx) DeleteBinding(instanceID, bindingID string) error {
+	if !tx.writable {
+		return errReadOnly
+	}
+	delete(tx.data.bindings[instanceID], bindingID)
+	return nil
+}
+
+// copyInstance returns a deep copy of i.
+func copyInstance(i Instance) Instance {
+	i.Parameters = copyMap(i.Parameters)
+	i.Context = copyMap(i.Context)
+	if i.Cleanup != nil {
+		cleanup := *i.Cleanup
+		cleanup.Finished = copyTime(cleanup.Finished)
+		i.Cleanup = &cleanup
+	}
+	i.Owner = copyIdentity(i.Owner)
+	i.LastOperation = copyOperation(i.LastOperation)
+	if i.History != nil {
+		history := make([]*Operation, len(i.History))
+		for n, op := range i.History {
+			history[n] = copyOperation(op)
+		}
+		i.History = history
+	}
+	return i
+}
+
+// copyBinding returns a deep copy of b.
+func copyBinding(b Binding) Binding {
+	b.Parameters = copyMap(b.Parameters)
+	b.Context = copyMap(b.Context)
+	b.Credentials = copyMap(b.Credentials)
+	b.Owner = copyIdentity(b.Owner)
+	return b
+}
+
+func copyOperation(op *Operation) *Operation {
+	if op == nil {
+		return nil
+	}
+	c := *op
+	c.Deadline = copyTime(op.Deadline)
+	c.Finished = copyTime(op.Finished)
+	if op.MaintenanceVersion != nil {
+		version := *op.MaintenanceVersion
+		c.MaintenanceVersion = &version
+	}
+	return &c
+}
+
+func copyIdentity(o *osb.OriginatingIdentity) *osb.OriginatingIdentity {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	return &c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// copyMap returns a deep copy of m, whose values are those of decoded JSON.
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return copyMap(t)
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for n := range t {
+			c[n] = copyValue(t[n])
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,183 @@
+package state
+
+import (
+	"errors"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// ErrNotFound is returned when a requested record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Instance is the broker's record of a service instance.
+type Instance struct {
+	ID               string                 `json:"id"`
+	ServiceID        string                 `json:"service_id"`
+	PlanID           string                 `json:"plan_id"`
+	Parameters       map[string]interface{} `json:"parameters,omitempty"`
+	Context          map[string]interface{} `json:"context,omitempty"`
+	OrganizationGUID string                 `json:"organization_guid,omitempty"`
+	SpaceGUID        string                 `json:"space_guid,omitempty"`
+	DashboardURL     string                 `json:"dashboard_url,omitempty"`
+	// MaintenanceVersion is the maintenance_info version of the instance's
+	// plan when it was provisioned or last upgraded, if the plan had one.
+	MaintenanceVersion string `json:"maintenance_version,omitempty"`
+	// State is where the instance is in its lifecycle.
+	State InstanceState `json:"state,omitempty"`
+	// Cleanup records the broker's attempts to clean up the resources of a
+	// failed instance, if it has made any.
+	Cleanup *Cleanup `json:"cleanup,omitempty"`
+	// Owner is the originating identity of the user that provisioned the
+	// instance, if the platform sent one.
+	Owner *osb.OriginatingIdentity `json:"owner,omitempty"`
+	// LastOperation is the most recent operation on the instance, if there
+	// has been one.
+	LastOperation *Operation `json:"last_operation,omitempty"`
+	// History holds the operations on the instance before its last one,
+	// oldest first, up to MaxHistory of them.
+	History []*Operation `json:"history,omitempty"`
+	Created time.Time    `json:"created"`
+	Updated time.Time    `json:"updated"`
+}
+
+// MaxHistory is the most operations an instance keeps in its History.
+const MaxHistory = 20
+
+// StartOperation makes op the last operation of the instance and moves the
+// one it replaces to the instance's History, dropping the oldest operations
+// beyond MaxHistory.
+func (i *Instance) StartOperation(op *Operation) {
+	if i.LastOperation != nil {
+		// Copy the history, which the stored record may share.
+		history := make([]*Operation, 0, len(i.History)+1)
+		history = append(history, i.History...)
+		history = append(history, i.LastOperation)
+		if len(history) > MaxHistory {
+			history = history[len(history)-MaxHistory:]
+		}
+		i.History = history
+	}
+	i.LastOperation = op
+}
+
+// Apply moves the instance to the plan and maintenance version of its update
+// operation op.
+func (i *Instance) Apply(op *Operation) {
+	if op.PlanID != "" {
+		i.PlanID = op.PlanID
+	}
+	if op.MaintenanceVersion != nil {
+		i.MaintenanceVersion = *op.MaintenanceVersion
+	}
+}
+
+// InstanceState is where an instance is in its lifecycle.
+type InstanceState string
+
+const (
+	// InstanceCreating means the instance is being provisioned.
+	InstanceCreating InstanceState = "creating"
+	// InstanceReady means the instance was provisioned. It may be being
+	// updated.
+	InstanceReady InstanceState = "ready"
+	// InstanceFailed means provisioning or deprovisioning the instance
+	// failed, which may have left some of its resources behind. The broker
+	// cleans them up in the background until the platform deprovisions the
+	// instance.
+	InstanceFailed InstanceState = "failed"
+	// InstanceDeleting means the instance is being deprovisioned.
+	InstanceDeleting InstanceState = "deleting"
+)
+
+// Cleanup is the record of the broker's attempts to clean up the resources
+// of a failed instance.
+type Cleanup struct {
+	Attempts int `json:"attempts"`
+	// LastError is the error of the last attempt, if it failed.
+	LastError string `json:"last_error,omitempty"`
+	// Next is when the broker will next attempt the cleanup.
+	Next time.Time `json:"next"`
+	// Finished is set once the cleanup has succeeded.
+	Finished *time.Time `json:"finished,omitempty"`
+	// Orphaned is set while the cleanup has failed often enough that the
+	// resources are reported as orphans for an operator to remove.
+	Orphaned bool `json:"orphaned,omitempty"`
+}
+
+// Operation is the record of an operation on an instance. Operations are
+// asynchronous unless the platform did not accept asynchronous operations.
+type Operation struct {
+	// Key identifies the operation. It is returned to the platform as the
+	// operation key.
+	Key string `json:"key"`
+	// Type is the OSB action that started the operation, for example
+	// "provision".
+	Type        string                 `json:"type"`
+	State       osb.LastOperationState `json:"state"`
+	Description string                 `json:"description,omitempty"`
+	Started     time.Time              `json:"started"`
+	// Deadline, if set, is when the operation fails if it has not finished,
+	// from the maximum polling duration of the instance's plan.
+	Deadline *time.Time `json:"deadline,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Interrupted is set if the broker shut down before the operation
+	// finished. Interrupted operations are resumed when the broker starts.
+	Interrupted bool `json:"interrupted,omitempty"`
+	// PlanID and MaintenanceVersion, if set, are the plan and maintenance
+	// version an update moves the instance to. They are applied to the
+	// instance only once the update succeeds.
+	PlanID             string  `json:"plan_id,omitempty"`
+	MaintenanceVersion *string `json:"maintenance_version,omitempty"`
+}
+
+// Binding is the broker's record of a service binding.
+type Binding struct {
+	ID         string                 `json:"id"`
+	InstanceID string                 `json:"instance_id"`
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Context is the platform's context of the bind request, such as the
+	// Kubernetes namespace of the binding.
+	Context map[string]interface{} `json:"context,omitempty"`
+	// Credentials are the credentials returned when the binding was
+	// created, which are returned again if the platform repeats the request.
+	Credentials map[string]interface{} `json:"credentials,omitempty"`
+	// Owner is the originating identity of the user that created the
+	// binding, if the platform sent one.
+	Owner   *osb.OriginatingIdentity `json:"owner,omitempty"`
+	Created time.Time                `json:"created"`
+}
+
+// Store holds instance and binding records.
+type Store interface {
+	// View calls fn with a read-only transaction.
+	View(fn func(tx Tx) error) error
+	// Update calls fn with a read-write transaction. The transaction's writes
+	// are committed if fn returns nil and discarded otherwise. Update
+	// transactions are serialized.
+	Update(fn func(tx Tx) error) error
+}
+
+// Tx is a transaction on a Store. Records returned by a Tx are copies; changes
+// to them are only stored by passing them to a Put method.
+type Tx interface {
+	// GetInstance returns the instance with the given ID or ErrNotFound.
+	GetInstance(id string) (*Instance, error)
+	// ListInstances returns every instance, ordered by ID.
+	ListInstances() ([]*Instance, error)
+	// PutInstance creates or replaces an instance.
+	PutInstance(i *Instance) error
+	// DeleteInstance deletes an instance and its bindings.
+	DeleteInstance(id string) error
+
+	// GetBinding returns the binding with the given ID or ErrNotFound.
+	GetBinding(instanceID, bindingID string) (*Binding, error)
+	// ListBindings returns the bindings of an instance, ordered by ID.
+	ListBindings(instanceID string) ([]*Binding, error)
+	// PutBinding creates or replaces a binding.
+	PutBinding(b *Binding) error
+	// DeleteBinding deletes a binding.
+	DeleteBinding(instanceID, bindingID string) error
+}
@@ -0,0 +1,221 @@
+package testing
+
+import (
+	"fmt"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/uuid"
+)
+
+// Instance is an instance provisioned by Provision.
+type Instance struct {
+	// ProvisionRequest is the request the instance was provisioned with.
+	*osb.ProvisionRequest
+	Response *osb.ProvisionResponse
+}
+
+// Binding is a binding created by Bind.
+type Binding struct {
+	// BindRequest is the request the binding was created with.
+	*osb.BindRequest
+	// Response holds the binding's credentials. For an asynchronous binding
+	// it is read back from the broker once the binding has been created.
+	Response *osb.BindResponse
+}
+
+// Provision provisions an instance and waits for the operation to succeed,
+// failing t otherwise. An empty InstanceID, OrganizationGUID or SpaceGUID in r
+// is filled in with a random ID, and the request accepts asynchronous
+// operations.
+func (s *Server) Provision(t T, r *osb.ProvisionRequest) *Instance {
+	if r.InstanceID == "" {
+		r.InstanceID = uuid.New()
+	}
+	if r.OrganizationGUID == "" {
+		r.OrganizationGUID = uuid.New()
+	}
+	if r.SpaceGUID == "" {
+		r.SpaceGUID = uuid.New()
+	}
+	r.AcceptsIncomplete = true
+
+	response, err := s.Client.ProvisionInstance(r)
+	if err != nil {
+		t.Fatalf("unable to provision instance %q: %v", r.InstanceID, err)
+		return nil
+	}
+	if response.Async {
+		s.succeed(t, "provision of "+r.InstanceID, func() (*osb.LastOperationResponse, error) {
+			return s.waitForOperation(r.InstanceID, response.OperationKey, false)
+		})
+	}
+	return &Instance{ProvisionRequest: r, Response: response}
+}
+
+// Update updates an instance and waits for the operation to succeed, failing t
+// otherwise.
+func (s *Server) Update(t T, r *osb.UpdateInstanceRequest) *osb.UpdateInstanceResponse {
+	r.AcceptsIncomplete = true
+
+	response, err := s.Client.UpdateInstance(r)
+	if err != nil {
+		t.Fatalf("unable to update instance %q: %v", r.InstanceID, err)
+		return nil
+	}
+	if response.Async {
+		s.succeed(t, "update of "+r.InstanceID, func() (*osb.LastOperationResponse, error) {
+			return s.waitForOperation(r.InstanceID, response.OperationKey, false)
+		})
+	}
+	return response
+}
+
+// Deprovision deprovisions an instance and waits for the operation to
+// succeed, failing t otherwise.
+func (s *Server) Deprovision(t T, r *osb.DeprovisionRequest) {
+	r.AcceptsIncomplete = true
+
+	response, err := s.Client.DeprovisionInstance(r)
+	if err != nil {
+		t.Fatalf("unable to deprovision instance %q: %v", r.InstanceID, err)
+		return
+	}
+	if response.Async {
+		s.succeed(t, "deprovision of "+r.InstanceID, func() (*osb.LastOperationResponse, error) {
+			return s.waitForOperation(r.InstanceID, response.OperationKey, true)
+		})
+	}
+}
+
+// Bind creates a binding and waits for the operation to succeed, failing t
+// otherwise. An empty BindingID in r is filled in with a random ID, and the
+// request accepts asynchronous operations.
+func (s *Server) Bind(t T, r *osb.BindRequest) *Binding {
+	if r.BindingID == "" {
+		r.BindingID = uuid.New()
+	}
+	r.AcceptsIncomplete = true
+
+	response, err := s.Client.Bind(r)
+	if err != nil {
+		t.Fatalf("unable to bind to instance %q: %v", r.InstanceID, err)
+		return nil
+	}
+	if response.Async {
+		s.succeed(t, "binding "+r.BindingID, func() (*osb.LastOperationResponse, error) {
+			return s.waitForBindingOperation(r.InstanceID, r.BindingID, response.OperationKey, false)
+		})
+		binding, err := s.Client.GetBinding(&osb.GetBindingRequest{
+			InstanceID: r.InstanceID,
+			BindingID:  r.BindingID,
+		})
+		if err != nil {
+			t.Fatalf("unable to get binding %q: %v", r.BindingID, err)
+			return nil
+		}
+		response.Credentials = binding.Credentials
+		response.SyslogDrainURL = binding.SyslogDrainURL
+		response.RouteServiceURL = binding.RouteServiceURL
+		response.VolumeMounts = binding.VolumeMounts
+	}
+	return &Binding{BindRequest: r, Response: response}
+}
+
+// Unbind deletes a binding and waits for the operation to succeed, failing t
+// otherwise.
+func (s *Server) Unbind(t T, r *osb.UnbindRequest) {
+	r.AcceptsIncomplete = true
+
+	response, err := s.Client.Unbind(r)
+	if err != nil {
+		t.Fatalf("unable to unbind %q: %v", r.BindingID, err)
+		return
+	}
+	if response.Async {
+		s.succeed(t, "unbinding "+r.BindingID, func() (*osb.LastOperationResponse, error) {
+			return s.waitForBindingOperation(r.InstanceID, r.BindingID, response.OperationKey, true)
+		})
+	}
+}
+
+// WaitForOperation polls the last operation of an instance until it
+// succeeds or fails and returns the final response. It fails t if polling
+// fails or the operation does not finish within the Server's PollTimeout.
+func (s *Server) WaitForOperation(t T, instanceID string, key *osb.OperationKey) *osb.LastOperationResponse {
+	response, err := s.waitForOperation(instanceID, key, false)
+	if err != nil {
+		t.Fatalf("unable to wait for operation on instance %q: %v", instanceID, err)
+	}
+	return response
+}
+
+// WaitForBindingOperation polls the last operation of a binding until it
+// succeeds or fails and returns the final response. It fails t if polling
+// fails or the operation does not finish within the Server's PollTimeout.
+func (s *Server) WaitForBindingOperation(t T, instanceID, bindingID string, key *osb.OperationKey) *osb.LastOperationResponse {
+	response, err := s.waitForBindingOperation(instanceID, bindingID, key, false)
+	if err != nil {
+		t.Fatalf("unable to wait for operation on binding %q: %v", bindingID, err)
+	}
+	return response
+}
+
+// succeed fails t unless wait returns a succeeded operation.
+func (s *Server) succeed(t T, what string, wait func() (*osb.LastOperationResponse, error)) {
+	response, err := wait()
+	if err != nil {
+		t.Fatalf("unable to wait for %s: %v", what, err)
+		return
+	}
+	if response.State != osb.StateSucceeded {
+		description := ""
+		if response.Description != nil {
+			description = ": " + *response.Description
+		}
+		t.Fatalf("%s %s%s", what, response.State, description)
+	}
+}
+
+func (s *Server) waitForOperation(instanceID string, key *osb.OperationKey, gone bool) (*osb.LastOperationResponse, error) {
+	return s.poll(gone, func() (*osb.LastOperationResponse, error) {
+		return s.Client.PollLastOperation(&osb.LastOperationRequest{
+			InstanceID:   instanceID,
+			OperationKey: key,
+		})
+	})
+}
+
+func (s *Server) waitForBindingOperation(instanceID, bindingID string, key *osb.OperationKey, gone bool) (*osb.LastOperationResponse, error) {
+	return s.poll(gone, func() (*osb.LastOperationResponse, error) {
+		return s.Client.PollBindingLastOperation(&osb.BindingLastOperationRequest{
+			InstanceID:   instanceID,
+			BindingID:    bindingID,
+			OperationKey: key,
+		})
+	})
+}
+
+// poll calls lastOperation until the operation is no longer in progress. If
+// gone is true, a 410 Gone response means the operation succeeded, as it does
+// for deletions.
+func (s *Server) poll(gone bool, lastOperation func() (*osb.LastOperationResponse, error)) (*osb.LastOperationResponse, error) {
+	deadline := time.Now().Add(s.PollTimeout)
+	for {
+		response, err := lastOperation()
+		if err != nil && gone && osb.IsGoneError(err) {
+			return &osb.LastOperationResponse{State: osb.StateSucceeded}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if response.State != osb.StateInProgress {
+			return response, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the operation did not finish within %v", s.PollTimeout)
+		}
+		time.Sleep(s.PollInterval)
+	}
+}
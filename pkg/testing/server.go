@@ -0,0 +1,130 @@
+// Package testing runs a broker.Interface in-process behind the same HTTP
+// handlers the broker serves with, so BusinessLogic implementations can be
+// tested end to end over real HTTP with an OSB client:
+//
+//	func TestProvision(t *testing.T) {
+//		b, err := broker.NewBusinessLogic(broker.Options{Async: true}, state.NewMemory())
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		s := brokertesting.NewServer(t, b)
+//		defer s.Close()
+//
+//		instance := s.Provision(t, &osb.ProvisionRequest{
+//			ServiceID: "4f6e6cf6-ffdd-425f-a2c7-3c9258ad246a",
+//			PlanID:    "86064792-7ea2-467b-af93-ac9694d96d5b",
+//		})
+//		binding := s.Bind(t, &osb.BindRequest{
+//			InstanceID: instance.InstanceID,
+//			ServiceID:  instance.ServiceID,
+//			PlanID:     instance.PlanID,
+//		})
+//		...
+//	}
+//
+// Helpers such as Provision and Bind fill in the IDs and GUIDs a test does
+// not care about, wait for asynchronous operations to finish and fail the
+// test on any error. Use the Server's Client directly to test error cases.
+package testing // import "github.com/pmorie/osb-starter-pack/pkg/testing"
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"github.com/pmorie/osb-broker-lib/pkg/metrics"
+	"github.com/pmorie/osb-broker-lib/pkg/rest"
+	"github.com/pmorie/osb-broker-lib/pkg/server"
+
+	"github.com/pmorie/osb-starter-pack/pkg/catalog"
+	"github.com/pmorie/osb-starter-pack/pkg/maintenance"
+)
+
+// T is the part of testing.TB the helpers report failures through.
+type T interface {
+	Fatalf(format string, args ...interface{})
+}
+
+// Server serves a broker.Interface from an httptest.Server.
+type Server struct {
+	// URL of the broker, for clients other than Client.
+	URL string
+	// Client is an OSB client configured to talk to the broker.
+	Client osb.Client
+
+	// PollInterval is how often helpers poll asynchronous operations, and
+	// PollTimeout how long they wait for them to finish.
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+
+	http *httptest.Server
+}
+
+// Options configure a Server.
+type Options struct {
+	// Middleware, if set, wraps the broker's handler, for example to
+	// authenticate requests.
+	Middleware func(http.Handler) http.Handler
+	// Configure, if set, adjusts the configuration of the Server's Client,
+	// for example to set credentials.
+	Configure func(c *osb.ClientConfiguration)
+}
+
+// NewServer starts a Server for b, failing t if it cannot.
+func NewServer(t T, b broker.Interface) *Server {
+	s, err := Start(b, Options{})
+	if err != nil {
+		t.Fatalf("unable to start broker: %v", err)
+	}
+	return s
+}
+
+// Start starts a Server for b with the given options. If b has a Catalog
+// method returning a catalog.Source, as the starter pack's BusinessLogic does,
+// the Server serves the catalog from it like the broker does.
+func Start(b broker.Interface, o Options) (*Server, error) {
+	api, err := rest.NewAPISurface(b, metrics.New())
+	if err != nil {
+		return nil, err
+	}
+	handler := server.NewHTTPHandler(api)
+	if c, ok := b.(interface {
+		Catalog() catalog.Source
+	}); ok {
+		handler = catalog.Middleware(c.Catalog())(handler)
+	}
+	handler = maintenance.Middleware(handler)
+	if o.Middleware != nil {
+		handler = o.Middleware(handler)
+	}
+	srv := httptest.NewServer(handler)
+
+	config := osb.DefaultClientConfiguration()
+	config.Name = "test"
+	config.URL = srv.URL
+	// Asynchronous bindings are an alpha feature of the client.
+	config.EnableAlphaFeatures = true
+	if o.Configure != nil {
+		o.Configure(config)
+	}
+	client, err := osb.NewClient(config)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+
+	return &Server{
+		URL:          srv.URL,
+		Client:       client,
+		PollInterval: 10 * time.Millisecond,
+		PollTimeout:  30 * time.Second,
+		http:         srv,
+	}, nil
+}
+
+// Close stops the Server. It does not stop the broker.Interface it serves.
+func (s *Server) Close() {
+	s.http.Close()
+}
@@ -0,0 +1,21 @@
+// Package uuid generates the random IDs that clients of the broker, such as
+// osbctl, the conformance checker and the test harness, give the instances
+// and bindings they create.
+package uuid // import "github.com/pmorie/osb-starter-pack/pkg/uuid"
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// New returns a random version 4 UUID, as the OSB API recommends for
+// instance and binding IDs.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,20 @@
+// Package visibility restricts which platforms and users see services and
+// plans in the catalog and may provision and bind them. A broker that serves
+// both Kubernetes and Cloud Foundry can, for example, offer a service only on
+// one of them, or a plan only to some Kubernetes groups or Cloud Foundry
+// organizations. Rules are set on services and plans in the catalog file:
+//
+//	services:
+//	- id: 4f6e6cf6-ffdd-425f-a2c7-3c9258ad246a
+//	  visibility:
+//	    platforms: [kubernetes]
+//	  plans:
+//	  - id: 86064792-7ea2-467b-af93-ac9694d96d5b
+//	    visibility:
+//	      groups: [team-db-admins]
+//
+// Platforms do not always say who they fetch the catalog for, so the catalog
+// only hides what a Rule is known to deny from what the request carries.
+// Provision and bind requests are refused unless their Rules are known to
+// allow them.
+package visibility // import "github.com/pmorie/osb-starter-pack/pkg/visibility"
@@ -0,0 +1,118 @@
+package visibility
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/identity"
+)
+
+// Rule restricts who sees a service or plan. Every non-empty field of a rule
+// must match a viewer for the rule to allow it; list fields match if any of
+// their entries do. An empty Rule allows everyone.
+type Rule struct {
+	// Platforms match the platform of the viewer, for example "kubernetes"
+	// or "cloudfoundry".
+	Platforms []string `json:"platforms,omitempty"`
+	// Groups match any of the Kubernetes groups of the viewer.
+	Groups []string `json:"groups,omitempty"`
+	// Organizations match the GUID of the Cloud Foundry organization the
+	// viewer acts in.
+	Organizations []string `json:"organizations,omitempty"`
+}
+
+// Viewer is who a request is made by, as far as the request tells.
+type Viewer struct {
+	// Platform is the platform of the request, or empty if it is not known.
+	// The other fields are only known if it is.
+	Platform string
+	// Groups are the Kubernetes groups of the user on whose behalf the
+	// request is made.
+	Groups []string
+	// Organization is the GUID of the organization the request acts in, or
+	// empty if it is not known.
+	Organization string
+}
+
+// NewViewer returns the Viewer of a request with the given originating
+// identity, which may be nil, and the given context and organization GUID of
+// a provision or bind request.
+func NewViewer(o *osb.OriginatingIdentity, context map[string]interface{}, organizationGUID string) *Viewer {
+	v := &Viewer{}
+	v.Platform, _, v.Groups = identity.Parse(o)
+	if v.Platform == "" {
+		v.Platform, _ = context["platform"].(string)
+	}
+	v.Organization, _ = context["organization_guid"].(string)
+	if v.Organization == "" {
+		v.Organization = organizationGUID
+	}
+	return v
+}
+
+// FromRequest returns the Viewer of r from its originating identity header.
+// It returns an empty Viewer if r is nil or has no valid header.
+func FromRequest(r *http.Request) *Viewer {
+	if r == nil {
+		return &Viewer{}
+	}
+	parts := strings.Split(r.Header.Get(osb.OriginatingIdentityHeader), " ")
+	if len(parts) != 2 {
+		return &Viewer{}
+	}
+	value, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return &Viewer{}
+	}
+	return NewViewer(&osb.OriginatingIdentity{Platform: parts[0], Value: string(value)}, nil, "")
+}
+
+// Allows reports whether the rule allows v, failing fields that match
+// attributes v does not know.
+func (r *Rule) Allows(v *Viewer) bool {
+	return r.allows(v, false)
+}
+
+// MayAllow reports whether the rule may allow v, passing fields that match
+// attributes v does not know.
+func (r *Rule) MayAllow(v *Viewer) bool {
+	return r.allows(v, true)
+}
+
+func (r *Rule) allows(v *Viewer, assume bool) bool {
+	if r == nil {
+		return true
+	}
+	platformKnown := v.Platform != ""
+	if len(r.Platforms) > 0 && (platformKnown || !assume) && !contains(r.Platforms, v.Platform) {
+		return false
+	}
+	if len(r.Groups) > 0 && (platformKnown || !assume) && !intersects(r.Groups, v.Groups) {
+		return false
+	}
+	if len(r.Organizations) > 0 && (v.Organization != "" || !assume) && !contains(r.Organizations, v.Organization) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b []string) bool {
+	for _, v := range b {
+		if contains(a, v) {
+			return true
+		}
+	}
+	return false
+}
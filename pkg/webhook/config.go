@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/pmorie/osb-starter-pack/pkg/config"
+)
+
+// Endpoint is a receiver of events.
+type Endpoint struct {
+	// URL is where events are posted.
+	URL string `json:"url"`
+	// Secret signs the requests to the endpoint. It may be a file
+	// reference, as in the file named by --config.
+	Secret string `json:"secret"`
+	// Events are the types of the events the endpoint gets. It gets every
+	// event if empty.
+	Events []string `json:"events,omitempty"`
+}
+
+// wants reports whether the endpoint gets events of the given type.
+func (e *Endpoint) wants(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Config configures the delivery of events.
+type Config struct {
+	Endpoints []*Endpoint `json:"endpoints"`
+	// QueueFile is the file deliveries wait in until they succeed. They are
+	// only kept in memory, and lost when the broker exits, if it is empty.
+	QueueFile string `json:"queueFile,omitempty"`
+	// MaxAttempts is the number of times a delivery is attempted before it
+	// is dropped. It defaults to DefaultMaxAttempts.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+}
+
+// DefaultMaxAttempts is the number of times a delivery is attempted unless a
+// Config says otherwise. With the Dispatcher's default backoff, the last
+// attempt is made about 18 hours after the first.
+const DefaultMaxAttempts = 30
+
+// Load reads a Config from the YAML or JSON file at path, reads the secrets
+// it references and validates it.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unable to parse webhooks file %q: %v", path, err)
+	}
+	for _, e := range c.Endpoints {
+		if e == nil || !strings.HasPrefix(e.Secret, config.FileReferencePrefix) {
+			continue
+		}
+		secret, err := ioutil.ReadFile(strings.TrimPrefix(e.Secret, config.FileReferencePrefix))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read secret of webhook %q: %v", e.URL, err)
+		}
+		e.Secret = strings.TrimRight(string(secret), "\r\n")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid webhooks file %q: %v", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks that the config's endpoints have HTTP URLs and secrets,
+// and only ask for known events.
+func (c *Config) Validate() error {
+	urls := map[string]bool{}
+	for n, e := range c.Endpoints {
+		if e == nil {
+			return fmt.Errorf("endpoint %d is empty", n+1)
+		}
+		u, err := url.Parse(e.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint %d must have an http or https url", n+1)
+		}
+		if urls[e.URL] {
+			return fmt.Errorf("more than one endpoint has url %q", e.URL)
+		}
+		urls[e.URL] = true
+		if e.Secret == "" {
+			return fmt.Errorf("endpoint %q must have a secret", e.URL)
+		}
+		for _, t := range e.Events {
+			if !eventTypes[t] {
+				return fmt.Errorf("endpoint %q asks for unknown event %q", e.URL, t)
+			}
+		}
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("maxAttempts must not be negative")
+	}
+	return nil
+}
+
+// endpoint returns the endpoint with the given URL, or nil if there is none.
+func (c *Config) endpoint(url string) *Endpoint {
+	for _, e := range c.Endpoints {
+		if e.URL == url {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *Config) maxAttempts() int {
+	if c.MaxAttempts == 0 {
+		return DefaultMaxAttempts
+	}
+	return c.MaxAttempts
+}
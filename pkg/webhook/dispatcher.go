@@ -0,0 +1,433 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pmorie/osb-starter-pack/pkg/log"
+)
+
+// The headers of the requests that deliver events.
+const (
+	EventHeader     = "X-Broker-Webhook-Event"
+	DeliveryHeader  = "X-Broker-Webhook-Delivery"
+	TimestampHeader = "X-Broker-Webhook-Timestamp"
+	SignatureHeader = "X-Broker-Webhook-Signature"
+)
+
+// Outcomes of delivery attempts, as the outcome label of the
+// osb_webhook_deliveries_total metric.
+const (
+	outcomeDelivered = "delivered"
+	outcomeFailed    = "failed"
+	outcomeDropped   = "dropped"
+)
+
+// queueVersion is the version of the format of queue files.
+const queueVersion = 1
+
+// Dispatcher delivers events to the endpoints of a Config in the background.
+// It is a prometheus.Collector of its deliveries.
+type Dispatcher struct {
+	// MinBackoff is the delay after the first failed attempt to deliver an
+	// event to an endpoint. It doubles after every further failure, up to
+	// MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+
+	config     *Config
+	client     *http.Client
+	deliveries *prom.CounterVec
+	queued     prom.Gauge
+
+	mu    sync.Mutex
+	queue []*delivery
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+var _ prom.Collector = &Dispatcher{}
+
+// delivery is an event waiting to be delivered to an endpoint.
+type delivery struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts"`
+	// Next is when the delivery is next attempted.
+	Next      time.Time `json:"next"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// queueFile is the format of the file named by Config.QueueFile.
+type queueFile struct {
+	Version    int         `json:"version"`
+	Deliveries []*delivery `json:"deliveries"`
+}
+
+// New returns a Dispatcher for the endpoints of c, with the deliveries left
+// in c's queue file when the broker last stopped. Call Start to deliver
+// them.
+func New(c *Config) (*Dispatcher, error) {
+	d := &Dispatcher{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Hour,
+		Timeout:    10 * time.Second,
+		config:     c,
+		client:     &http.Client{},
+		deliveries: prom.NewCounterVec(prom.CounterOpts{
+			Name: "osb_webhook_deliveries_total",
+			Help: "Total amount of attempts to deliver events to webhook endpoints.",
+		}, []string{"event", "outcome"}),
+		queued: prom.NewGauge(prom.GaugeOpts{
+			Name: "osb_webhook_queue_length",
+			Help: "Amount of webhook deliveries waiting to be attempted.",
+		}),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if c.QueueFile != "" {
+		data, err := ioutil.ReadFile(c.QueueFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			q := &queueFile{}
+			if err := json.Unmarshal(data, q); err != nil {
+				return nil, fmt.Errorf("unable to parse webhook queue file %q: %v", c.QueueFile, err)
+			}
+			if q.Version != queueVersion {
+				return nil, fmt.Errorf("webhook queue file %q has unsupported version %d", c.QueueFile, q.Version)
+			}
+			d.queue = q.Deliveries
+		}
+	}
+	return d, nil
+}
+
+// Describe implements prometheus.Collector.
+func (d *Dispatcher) Describe(ch chan<- *prom.Desc) {
+	d.deliveries.Describe(ch)
+	d.queued.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (d *Dispatcher) Collect(ch chan<- prom.Metric) {
+	d.mu.Lock()
+	d.queued.Set(float64(len(d.queue)))
+	d.mu.Unlock()
+	d.deliveries.Collect(ch)
+	d.queued.Collect(ch)
+}
+
+// Emit queues e for delivery to the endpoints that want it.
+func (d *Dispatcher) Emit(e *Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.With("event", e.Type, "error", err).Error("unable to serialize webhook event")
+		return
+	}
+
+	d.mu.Lock()
+	now := time.Now()
+	for _, endpoint := range d.config.Endpoints {
+		if !endpoint.wants(e.Type) {
+			continue
+		}
+		d.queue = append(d.queue, &delivery{
+			ID:    newID(),
+			URL:   endpoint.URL,
+			Event: e.Type,
+			Body:  body,
+			Next:  now,
+		})
+	}
+	d.persist()
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start starts delivering events in the background.
+func (d *Dispatcher) Start() {
+	go func() {
+		defer close(d.done)
+		for {
+			wait := d.deliverDue()
+			select {
+			case <-d.stop:
+				return
+			case <-d.wake:
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop stops delivering events and waits for the attempt in progress, if
+// any, to finish. Deliveries still queued are attempted when a Dispatcher
+// for the same queue file is started again.
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() { close(d.stop) })
+	<-d.done
+}
+
+// idleWait is how long the Dispatcher waits for new events when no delivery
+// is due.
+const idleWait = time.Minute
+
+// deliverDue attempts the deliveries that are due and returns how long to
+// wait until the next one is.
+func (d *Dispatcher) deliverDue() time.Duration {
+	d.mu.Lock()
+	now := time.Now()
+	var due []*delivery
+	for _, dl := range d.queue {
+		if !dl.Next.After(now) {
+			due = append(due, dl)
+		}
+	}
+	d.mu.Unlock()
+
+	// Each endpoint gets its deliveries in order, concurrently with the
+	// others, so an endpoint that is down does not hold up the rest.
+	byURL := map[string][]*delivery{}
+	for _, dl := range due {
+		byURL[dl.URL] = append(byURL[dl.URL], dl)
+	}
+	var wg sync.WaitGroup
+	for _, deliveries := range byURL {
+		wg.Add(1)
+		go func(deliveries []*delivery) {
+			defer wg.Done()
+			d.deliverAll(deliveries)
+		}(deliveries)
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wait := idleWait
+	now = time.Now()
+	for _, dl := range d.queue {
+		if w := dl.Next.Sub(now); w < wait {
+			wait = w
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// deliverAll attempts the deliveries to one endpoint in order. After one
+// fails, the rest are postponed until it is retried rather than attempted,
+// so that an endpoint that is down costs a single timeout per pass.
+func (d *Dispatcher) deliverAll(deliveries []*delivery) {
+	for i, dl := range deliveries {
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+		err := d.deliver(dl)
+		d.record(dl, err)
+		if err != nil && err != errNoEndpoint {
+			next := dl.Next
+			if now := time.Now(); !next.After(now) {
+				// dl was dropped rather than rescheduled.
+				next = now.Add(d.MinBackoff)
+			}
+			d.postpone(deliveries[i+1:], next)
+			return
+		}
+	}
+}
+
+// deliver attempts the delivery dl.
+func (d *Dispatcher) deliver(dl *delivery) error {
+	endpoint := d.config.endpoint(dl.URL)
+	if endpoint == nil {
+		return errNoEndpoint
+	}
+	req, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, timestamp, dl.Body))
+
+	client := *d.client
+	client.Timeout = d.Timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// errNoEndpoint is the error of deliveries to endpoints that are no longer
+// configured.
+var errNoEndpoint = fmt.Errorf("the endpoint is no longer configured")
+
+// record records the outcome of an attempt of the delivery dl: it is removed
+// from the queue if it succeeded or will not be attempted again, and
+// rescheduled otherwise.
+func (d *Dispatcher) record(dl *delivery, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l := log.With("event", dl.Event, "delivery", dl.ID, "url", dl.URL)
+	dl.Attempts++
+	keep := false
+	switch {
+	case err == nil:
+		d.deliveries.WithLabelValues(dl.Event, outcomeDelivered).Inc()
+		l.V(4).Info("delivered webhook event")
+	case err == errNoEndpoint || dl.Attempts >= d.config.maxAttempts():
+		d.deliveries.WithLabelValues(dl.Event, outcomeDropped).Inc()
+		l.With("attempts", dl.Attempts, "error", err).Error("dropping webhook event that could not be delivered")
+	default:
+		d.deliveries.WithLabelValues(dl.Event, outcomeFailed).Inc()
+		dl.LastError = err.Error()
+		dl.Next = time.Now().Add(d.backoff(dl.Attempts))
+		l.With("attempts", dl.Attempts, "next", dl.Next, "error", err).Warning("unable to deliver webhook event")
+		keep = true
+	}
+
+	if !keep {
+		queue := d.queue[:0]
+		for _, other := range d.queue {
+			if other != dl {
+				queue = append(queue, other)
+			}
+		}
+		d.queue = queue
+	}
+	d.persist()
+}
+
+// postpone reschedules deliveries that were due to be attempted at next
+// instead, without counting an attempt.
+func (d *Dispatcher) postpone(deliveries []*delivery, next time.Time) {
+	if len(deliveries) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, dl := range deliveries {
+		dl.Next = next
+	}
+	d.persist()
+}
+
+// backoff returns the delay after the given number of failed attempts of a
+// delivery.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.MinBackoff
+	for n := 1; n < attempts && b < d.MaxBackoff; n++ {
+		b *= 2
+	}
+	if b > d.MaxBackoff {
+		b = d.MaxBackoff
+	}
+	return b
+}
+
+// persist writes the queue to the queue file, if there is one. It must be
+// called with d.mu held.
+func (d *Dispatcher) persist() {
+	if d.config.QueueFile == "" {
+		return
+	}
+	deliveries := d.queue
+	if deliveries == nil {
+		deliveries = []*delivery{}
+	}
+	if err := writeFile(d.config.QueueFile, &queueFile{Version: queueVersion, Deliveries: deliveries}); err != nil {
+		log.With("error", err).Error("unable to write the webhook queue")
+	}
+}
+
+// writeFile replaces the file at path with v as JSON, through a temporary
+// file that is renamed over it so a crash never leaves a partial file.
+func writeFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Sign returns the signature of a delivery of body made at the given Unix
+// time with secret, as sent in the SignatureHeader.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that the request r, whose body is body, is a delivery signed
+// with secret and made within tolerance of now, so that a captured delivery
+// cannot be replayed later.
+func Verify(r *http.Request, body []byte, secret string, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s header", TimestampHeader)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("the delivery was signed %s ago, more than the tolerance of %s", age, tolerance)
+	}
+	if !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return fmt.Errorf("the signature does not match")
+	}
+	return nil
+}
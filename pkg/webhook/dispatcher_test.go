@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+const testSecret = "s3cret"
+
+// receiver is an endpoint that records the deliveries it gets and responds
+// with the status codes it is given, then 200.
+type receiver struct {
+	t      *testing.T
+	mu     sync.Mutex
+	codes  []int
+	events []string
+	got    chan string
+}
+
+func newReceiver(t *testing.T, codes ...int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, codes: codes, got: make(chan string, 100)}
+	return r, httptest.NewServer(r)
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("unable to read delivery: %v", err)
+	}
+	if err := Verify(req, body, testSecret, time.Minute); err != nil {
+		r.t.Errorf("delivery does not verify: %v", err)
+	}
+
+	r.mu.Lock()
+	code := http.StatusOK
+	if len(r.codes) > 0 {
+		code, r.codes = r.codes[0], r.codes[1:]
+	}
+	r.events = append(r.events, req.Header.Get(EventHeader))
+	r.mu.Unlock()
+
+	w.WriteHeader(code)
+	r.got <- req.Header.Get(EventHeader)
+}
+
+// wait waits for n deliveries.
+func (r *receiver) wait(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.got:
+		case <-time.After(5 * time.Second):
+			r.t.Fatalf("timed out waiting for delivery %d of %d", i+1, n)
+		}
+	}
+}
+
+func (r *receiver) attempts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+func newTestDispatcher(t *testing.T, c *Config) *Dispatcher {
+	d, err := New(c)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	d.MinBackoff = 10 * time.Millisecond
+	d.MaxBackoff = 40 * time.Millisecond
+	d.Timeout = time.Second
+	return d
+}
+
+func testEvent() *Event {
+	return newEvent(InstanceProvisioned, &state.Instance{ID: "instance"}, nil)
+}
+
+func queueLength(d *Dispatcher) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.queue)
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"instance.provisioned"}`)
+	now := time.Now().Unix()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now, 10))
+	req.Header.Set(SignatureHeader, Sign(testSecret, now, body))
+
+	if err := Verify(req, body, testSecret, time.Minute); err != nil {
+		t.Errorf("Verify of a signed delivery: %v", err)
+	}
+	if err := Verify(req, body, "other", time.Minute); err == nil {
+		t.Error("Verify with the wrong secret succeeded")
+	}
+	if err := Verify(req, []byte(`{}`), testSecret, time.Minute); err == nil {
+		t.Error("Verify of a changed body succeeded")
+	}
+
+	old := now - 3600
+	req.Header.Set(TimestampHeader, strconv.FormatInt(old, 10))
+	req.Header.Set(SignatureHeader, Sign(testSecret, old, body))
+	if err := Verify(req, body, testSecret, time.Minute); err == nil {
+		t.Error("Verify of a delivery signed an hour ago succeeded")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	r, server := newReceiver(t)
+	defer server.Close()
+	d := newTestDispatcher(t, &Config{Endpoints: []*Endpoint{{URL: server.URL, Secret: testSecret}}})
+	d.Start()
+	defer d.Stop()
+
+	d.Emit(testEvent())
+	if got := <-r.got; got != InstanceProvisioned {
+		t.Errorf("got event %q, want %q", got, InstanceProvisioned)
+	}
+}
+
+func TestRetryAfterFailure(t *testing.T) {
+	r, server := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	defer server.Close()
+	d := newTestDispatcher(t, &Config{Endpoints: []*Endpoint{{URL: server.URL, Secret: testSecret}}})
+	d.Start()
+	defer d.Stop()
+
+	start := time.Now()
+	d.Emit(testEvent())
+	r.wait(3)
+	// The retries wait 10ms, then 20ms.
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("three attempts took %s, less than the backoff of 30ms", elapsed)
+	}
+	waitFor(t, func() bool { return queueLength(d) == 0 })
+}
+
+func TestDropAfterMaxAttempts(t *testing.T) {
+	r, server := newReceiver(t, 500, 500, 500, 500, 500)
+	defer server.Close()
+	d := newTestDispatcher(t, &Config{
+		Endpoints:   []*Endpoint{{URL: server.URL, Secret: testSecret}},
+		MaxAttempts: 3,
+	})
+	d.Start()
+	defer d.Stop()
+
+	d.Emit(testEvent())
+	r.wait(3)
+	waitFor(t, func() bool { return queueLength(d) == 0 })
+	time.Sleep(100 * time.Millisecond)
+	if n := r.attempts(); n != 3 {
+		t.Errorf("got %d attempts, want 3", n)
+	}
+}
+
+func TestDeadEndpointDoesNotStallOthers(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer dead.Close()
+	r, server := newReceiver(t)
+	defer server.Close()
+	d := newTestDispatcher(t, &Config{Endpoints: []*Endpoint{
+		{URL: dead.URL, Secret: testSecret},
+		{URL: server.URL, Secret: testSecret},
+	}})
+	d.Timeout = 200 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		d.Emit(testEvent())
+	}
+	r.wait(3)
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("deliveries to the live endpoint took %s", elapsed)
+	}
+}
+
+func TestQueueFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queue := filepath.Join(dir, "queue.json")
+
+	r, server := newReceiver(t)
+	defer server.Close()
+	// Nothing is delivered until the first Dispatcher is started, which it
+	// never is.
+	c := &Config{Endpoints: []*Endpoint{{URL: server.URL, Secret: testSecret}}, QueueFile: queue}
+	first := newTestDispatcher(t, c)
+	first.Emit(testEvent())
+	first.Emit(testEvent())
+
+	second := newTestDispatcher(t, c)
+	if n := queueLength(second); n != 2 {
+		t.Fatalf("reloaded %d deliveries, want 2", n)
+	}
+	second.Start()
+	defer second.Stop()
+	r.wait(2)
+	waitFor(t, func() bool { return queueLength(second) == 0 })
+
+	third := newTestDispatcher(t, c)
+	if n := queueLength(third); n != 0 {
+		t.Errorf("reloaded %d deliveries after they were delivered, want 0", n)
+	}
+}
+
+func TestQueueFileVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queue := filepath.Join(dir, "queue.json")
+	if err := ioutil.WriteFile(queue, []byte(`{"version":2,"deliveries":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(&Config{QueueFile: queue}); err == nil {
+		t.Error("New with a queue file of an unknown version succeeded")
+	}
+}
+
+// waitFor waits for cond to hold.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
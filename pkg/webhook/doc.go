@@ -0,0 +1,36 @@
+// Package webhook tells other systems, such as billing or an inventory, when
+// the broker's instances and bindings change. It emits these events:
+//
+//	instance.provisioned    a provision succeeded
+//	instance.updated        an update succeeded
+//	instance.deprovisioned  the broker deleted its record of an instance
+//	binding.created         a binding was created
+//	binding.deleted         a binding was deleted
+//	operation.failed        a provision, update or deprovision failed
+//
+// Events are posted as JSON to the endpoints of a YAML or JSON file, for
+// example:
+//
+//	endpoints:
+//	- url: https://billing.example.com/hooks/servicebroker
+//	  secret: file:/etc/servicebroker/billing-webhook-secret
+//	  events: [instance.provisioned, instance.deprovisioned]
+//	- url: https://cmdb.example.com/osb
+//	  secret: s3cr3t
+//	queueFile: /var/lib/servicebroker/webhooks.json
+//	maxAttempts: 15
+//
+// An endpoint without events gets every event. Each request carries the
+// event's type and a delivery ID, and is signed with the endpoint's secret:
+// its X-Broker-Webhook-Signature header is "sha256=" followed by the
+// hex-encoded HMAC-SHA256 of the X-Broker-Webhook-Timestamp header, a period
+// and the body. Receivers written in Go can check it with Verify.
+//
+// Events are emitted by Store, which wraps a state.Store, once the
+// transaction that makes the change commits. Deprovisioning an instance
+// deletes its bindings without binding.deleted events. Events wait in a
+// Dispatcher's queue until their endpoints respond with a 2xx status; failed
+// deliveries are retried with exponential backoff until maxAttempts is
+// reached. The queue is kept in queueFile, if it is set, so deliveries
+// survive a restart.
+package webhook // import "github.com/pmorie/osb-starter-pack/pkg/webhook"
@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/audit"
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// The types of events.
+const (
+	InstanceProvisioned   = "instance.provisioned"
+	InstanceUpdated       = "instance.updated"
+	InstanceDeprovisioned = "instance.deprovisioned"
+	BindingCreated        = "binding.created"
+	BindingDeleted        = "binding.deleted"
+	OperationFailed       = "operation.failed"
+)
+
+var eventTypes = map[string]bool{
+	InstanceProvisioned:   true,
+	InstanceUpdated:       true,
+	InstanceDeprovisioned: true,
+	BindingCreated:        true,
+	BindingDeleted:        true,
+	OperationFailed:       true,
+}
+
+// Event is a change to an instance or binding, as posted to endpoints.
+type Event struct {
+	// ID identifies the event. An event delivered more than once, because
+	// the endpoint's response was lost, has the same ID each time.
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Broker is the name of the broker, if the process serves several.
+	Broker string `json:"broker,omitempty"`
+	// Instance is the instance the event is about, or the instance of its
+	// binding. Its parameters are redacted as in the audit trail.
+	Instance *state.Instance `json:"instance"`
+	// Binding is the binding the event is about, if any, without its
+	// credentials.
+	Binding *state.Binding `json:"binding,omitempty"`
+	// Operation is the operation that provisioned, updated or
+	// deprovisioned the instance or failed.
+	Operation *state.Operation `json:"operation,omitempty"`
+}
+
+// newEvent returns an event of the given type about the instance i and, if b
+// is not nil, its binding b.
+func newEvent(eventType string, i *state.Instance, b *state.Binding) *Event {
+	e := &Event{
+		ID:        newID(),
+		Type:      eventType,
+		Time:      time.Now().UTC(),
+		Instance:  redactInstance(i),
+		Operation: i.LastOperation,
+	}
+	if b != nil {
+		redacted := *b
+		redacted.Parameters = audit.Redact(b.Parameters)
+		redacted.Credentials = nil
+		e.Binding = &redacted
+		e.Operation = nil
+	}
+	return e
+}
+
+// redactInstance returns a copy of i without its history and with its
+// parameters redacted.
+func redactInstance(i *state.Instance) *state.Instance {
+	redacted := *i
+	redacted.Parameters = audit.Redact(i.Parameters)
+	redacted.History = nil
+	redacted.LastOperation = nil
+	return &redacted
+}
+
+// instanceEvent returns the type of the event that replacing the instance
+// existing, which may be nil, with i emits, or "" if it emits none. Events
+// are emitted when the last operation of the instance finishes.
+func instanceEvent(existing, i *state.Instance) string {
+	op := i.LastOperation
+	if op == nil || op.State == osb.StateInProgress {
+		return ""
+	}
+	if existing != nil && existing.LastOperation != nil &&
+		existing.LastOperation.Key == op.Key && existing.LastOperation.State == op.State {
+		return ""
+	}
+	switch {
+	case op.State == osb.StateFailed:
+		return OperationFailed
+	case op.Type == "provision":
+		return InstanceProvisioned
+	case op.Type == "update":
+		return InstanceUpdated
+	}
+	return ""
+}
+
+// newID returns a random ID for an event or delivery.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
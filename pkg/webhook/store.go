@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	"github.com/pmorie/osb-starter-pack/pkg/state"
+)
+
+// Store wraps a state.Store and emits events for the changes its Update
+// transactions make to a Dispatcher once they commit. Changes of
+// transactions that are rolled back emit nothing.
+type Store struct {
+	state.Store
+
+	dispatcher *Dispatcher
+	broker     string
+}
+
+var _ state.Store = &Store{}
+
+// NewStore returns a Store that emits the events of the changes to s to d.
+// broker is the name of the broker s belongs to, which may be empty if the
+// process serves only one.
+func NewStore(s state.Store, d *Dispatcher, broker string) *Store {
+	return &Store{
+		Store:      s,
+		dispatcher: d,
+		broker:     broker,
+	}
+}
+
+func (s *Store) Update(fn func(tx state.Tx) error) error {
+	tx := &eventTx{}
+	err := s.Store.Update(func(inner state.Tx) error {
+		tx.Tx = inner
+		tx.events = nil
+		return fn(tx)
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range tx.events {
+		e.Broker = s.broker
+		s.dispatcher.Emit(e)
+	}
+	return nil
+}
+
+// eventTx records the events of the changes made in a transaction.
+type eventTx struct {
+	state.Tx
+	events []*Event
+}
+
+func (tx *eventTx) PutInstance(i *state.Instance) error {
+	existing, err := tx.GetInstance(i.ID)
+	if err != nil && err != state.ErrNotFound {
+		return err
+	}
+	if err := tx.Tx.PutInstance(i); err != nil {
+		return err
+	}
+	if t := instanceEvent(existing, i); t != "" {
+		tx.events = append(tx.events, newEvent(t, i, nil))
+	}
+	return nil
+}
+
+func (tx *eventTx) DeleteInstance(id string) error {
+	existing, err := tx.GetInstance(id)
+	if err == state.ErrNotFound {
+		return tx.Tx.DeleteInstance(id)
+	} else if err != nil {
+		return err
+	}
+	if err := tx.Tx.DeleteInstance(id); err != nil {
+		return err
+	}
+	// The instance is deleted once its deprovision operation succeeds,
+	// without the operation being stored as succeeded first.
+	if op := existing.LastOperation; op != nil && op.State == osb.StateInProgress {
+		finished := *op
+		now := time.Now()
+		finished.State = osb.StateSucceeded
+		finished.Finished = &now
+		existing.LastOperation = &finished
+	}
+	tx.events = append(tx.events, newEvent(InstanceDeprovisioned, existing, nil))
+	return nil
+}
+
+func (tx *eventTx) PutBinding(b *state.Binding) error {
+	_, err := tx.GetBinding(b.InstanceID, b.ID)
+	if err != nil && err != state.ErrNotFound {
+		return err
+	}
+	created := err == state.ErrNotFound
+	if err := tx.Tx.PutBinding(b); err != nil {
+		return err
+	}
+	if created {
+		return tx.bindingEvent(BindingCreated, b)
+	}
+	return nil
+}
+
+func (tx *eventTx) DeleteBinding(instanceID, bindingID string) error {
+	existing, err := tx.GetBinding(instanceID, bindingID)
+	if err == state.ErrNotFound {
+		return tx.Tx.DeleteBinding(instanceID, bindingID)
+	} else if err != nil {
+		return err
+	}
+	if err := tx.Tx.DeleteBinding(instanceID, bindingID); err != nil {
+		return err
+	}
+	return tx.bindingEvent(BindingDeleted, existing)
+}
+
+// bindingEvent records an event of the given type about the binding b.
+func (tx *eventTx) bindingEvent(eventType string, b *state.Binding) error {
+	instance, err := tx.GetInstance(b.InstanceID)
+	if err != nil {
+		return err
+	}
+	tx.events = append(tx.events, newEvent(eventType, instance, b))
+	return nil
+}